@@ -100,4 +100,14 @@ func (s *Stack[T]) MarshalJSON() ([]byte, error) {
 	defer s.lock.Unlock()
 
 	return json.Marshal(s.s)
-}
\ No newline at end of file
+}
+
+// Values returns a copy of the slice of the elements, from the bottom to the top of
+// the stack. Unlike RawArrayCopy, the elements themselves are not cloned.
+func (s *Stack[T]) Values() []T {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	values := make([]T, len(s.s))
+	copy(values, s.s)
+	return values
+}
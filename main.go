@@ -11,19 +11,28 @@ import (
     "os"
     "path/filepath"
     "slices"
+    "strings"
     "time"
 )
 
 var isPlayground bool
+var perfModelFileName string
+var echoPrints bool
+
+// maxPrintedStates is the number of the most likely states printed by the
+// performance analysis. All of them are written to the results file.
+const maxPrintedStates = 20
 
 func main() {
     flag.BoolVar(&isPlayground, "playground", false, "is for playground")
+    flag.StringVar(&perfModelFileName, "perf", "", "performance model yaml file, to run the performance analysis after model checking")
+    flag.BoolVar(&echoPrints, "echo_prints", false, "also write the output of print() to the console as soon as it is printed")
     flag.Parse()
 
     args := flag.Args()
     // Check if the correct number of arguments is provided
     if len(args) != 1 {
-        fmt.Println("Usage:", os.Args[0], "<json_file>")
+        fmt.Println("Usage:", os.Args[0], "[--echo_prints] [--perf <perf_model.yaml>] <json_file>")
         os.Exit(1)
     }
 
@@ -67,16 +76,34 @@ func main() {
         }
 
     }
+    if echoPrints {
+        stateConfig.EchoPrints = true
+    }
     fmt.Printf("StateSpaceOptions: %+v\n", stateConfig)
     if stateConfig.Options.MaxConcurrentActions == 0 {
         stateConfig.Options.MaxConcurrentActions = stateConfig.Options.MaxActions
     }
+    var perfModel *ast.PerformanceModel
+    if perfModelFileName != "" {
+        perfModel, err = modelchecker.ReadPerformanceModelFromYaml(perfModelFileName)
+        if err != nil {
+            fmt.Println("Error reading the performance model:", err)
+            os.Exit(1)
+        }
+    }
 
-    p1 := modelchecker.NewProcessor([]*ast.File{f}, stateConfig)
+    p1, err := modelchecker.NewProcessor([]*ast.File{f}, stateConfig)
+    if err != nil {
+        fmt.Println("Error in fizz.yaml:", err)
+        os.Exit(1)
+    }
     startTime := time.Now()
     rootNode, failedNode, err := p1.Start()
     endTime := time.Now()
     fmt.Printf("Time taken for model checking: %v\n", endTime.Sub(startTime))
+    if p1.GetBoundaryNodesCount() > 0 {
+        fmt.Printf("bounded: %d states cut off\n", p1.GetBoundaryNodesCount())
+    }
 
     outDir, err := createOutputDir(dirPath)
     if err != nil {
@@ -116,12 +143,27 @@ func main() {
         //failedInvariant := nil
         var failurePath []*modelchecker.Link
         var failedInvariant *modelchecker.InvariantPosition
-        nodes, deadlock, _ := modelchecker.GetAllNodes(rootNode)
-        if deadlock != nil && stateConfig.GetDeadlockDetection() {
-            fmt.Println("DEADLOCK detected")
-            fmt.Println("FAILED: Model checker failed")
-            dumpFailedNode(deadlock, rootNode, outDir)
-            return
+        nodes, _, _ := modelchecker.GetAllNodes(rootNode)
+        if stateConfig.GetLiveness() == modelchecker.LivenessOnTheFly {
+            // The exploration stops at the first liveness failure, so it is reported
+            // before the deadlocks, which cannot be found in the unexplored states.
+            failurePath, failedInvariant = p1.GetLivenessFailure()
+        }
+        if failedInvariant == nil && stateConfig.GetDeadlockDetection() {
+            printDeadlocks(p1.GetDeadlocks(nodes))
+            deadlockFailures := p1.GetDeadlockFailures(nodes)
+            if len(deadlockFailures) > 0 && stateConfig.GetReportAllFailures() {
+                dumpFailures(deadlockFailures, rootNode, outDir)
+                return
+            }
+            if len(deadlockFailures) > 0 {
+                deadlock := deadlockFailures[0].Deadlock
+                fmt.Printf("DEADLOCK detected: %s\n", deadlock.Kind)
+                fmt.Printf("Disabled actions: %v\n", deadlock.DisabledActions)
+                fmt.Println("FAILED: Model checker failed")
+                dumpFailedNode(deadlock.Node, rootNode, outDir)
+                return
+            }
         }
         if stateConfig.GetLiveness() == "strict" || stateConfig.GetLiveness() == "strict/bfs" {
             failurePath, failedInvariant = modelchecker.CheckStrictLiveness(rootNode)
@@ -131,8 +173,15 @@ func main() {
             failurePath, failedInvariant = modelchecker.CheckFastLiveness(nodes)
             fmt.Printf("IsLive: %t\n", failedInvariant == nil)
             fmt.Printf("Time taken to check liveness: %v\n", time.Now().Sub(endTime))
+        } else if stateConfig.GetLiveness() == modelchecker.LivenessOnTheFly {
+            fmt.Printf("IsLive: %t\n", failedInvariant == nil)
+            fmt.Printf("Time taken to check liveness: %v\n", time.Now().Sub(endTime))
         }
 
+        if failedInvariant == nil && stateConfig.GetFailOnBound() && p1.GetBoundaryNodesCount() > 0 {
+            fmt.Printf("FAILED: Exploration bound reached, bounded: %d states cut off\n", p1.GetBoundaryNodesCount())
+            return
+        }
         if failedInvariant == nil {
             fmt.Println("PASSED: Model checker completed successfully")
             //nodes, _, _ := modelchecker.GetAllNodes(rootNode)
@@ -144,6 +193,9 @@ func main() {
                 }
                 fmt.Printf("Writen %d node files and %d link files to dir %s\n", len(nodeFiles), len(linkFileNames), outDir)
             }
+            if perfModel != nil {
+                runPerformanceAnalysis(rootNode, f, perfModel, outDir)
+            }
         } else {
             fmt.Println("FAILED: Liveness check failed")
             if failedInvariant.FileIndex > 0 {
@@ -156,12 +208,233 @@ func main() {
 
         return
     }
+    if stateConfig.GetReportAllFailures() {
+        failures := p1.GetFailures()
+        if stateConfig.GetDeadlockDetection() {
+            nodes, _, _ := modelchecker.GetAllNodes(rootNode)
+            failures = append(failures, p1.GetDeadlockFailures(nodes)...)
+        }
+        dumpFailures(failures, rootNode, outDir)
+        return
+    }
     fmt.Println("FAILED: Model checker failed")
+    if failedNode.Process.FailedAssertion != nil {
+        fmt.Println(failedNode.Process.FailedAssertion.SprintStackTrace())
+    }
 
     dumpFailedNode(failedNode, rootNode, outDir)
 }
 
+// runPerformanceAnalysis prints the performance metrics of the state graph, and
+// writes them as json to perf_results.json in the outDir.
+func runPerformanceAnalysis(rootNode *modelchecker.Node, f *ast.File, perfModel *ast.PerformanceModel, outDir string) {
+    startTime := time.Now()
+    report, err := modelchecker.AnalyzePerformance(rootNode, []*ast.File{f}, perfModel)
+    if err != nil {
+        fmt.Println("Error in the performance model:", err)
+        return
+    }
+    fmt.Printf("Time taken for performance analysis: %v\n", time.Now().Sub(startTime))
+
+    fmt.Println("Performance analysis:")
+    fmt.Println("Steady state:")
+    printCounterMetrics(report.SteadyState)
+    fmt.Printf("Steady state probabilities of %d states:\n", len(report.States))
+    for i, state := range report.States {
+        if i == maxPrintedStates {
+            fmt.Printf("  ... %d more\n", len(report.States)-maxPrintedStates)
+            break
+        }
+        fmt.Printf("  %4d: %.8f state: %s", state.Node, state.Probability, state.State)
+        if state.Returns != "" {
+            fmt.Printf(" / returns: %s", state.Returns)
+        }
+        fmt.Println()
+    }
+    for _, invariant := range report.Invariants {
+        fmt.Printf("Invariant %d %s: probability %.8f", invariant.InvariantIndex, invariant.Name, invariant.Probability)
+        if invariant.ExpectedSteps != nil {
+            fmt.Printf(", expected steps %g\n", *invariant.ExpectedSteps)
+        } else {
+            fmt.Println(", expected steps unbounded")
+        }
+        if invariant.NotAbsorbed > 0 {
+            fmt.Printf("  Never reached with probability %.8f\n", invariant.NotAbsorbed)
+        }
+        if !invariant.Converged {
+            fmt.Printf("  Solver did not converge, residual %g\n", invariant.Residual)
+        }
+        printCounterMetrics(invariant.PerformanceMetrics)
+    }
+    if report.ContinuousTime != nil {
+        printContinuousTime(report.ContinuousTime)
+    }
+
+    resultsFileName := filepath.Join(outDir, "perf_results.json")
+    if err := report.WriteJson(resultsFileName); err != nil {
+        fmt.Println("Error writing performance results:", err)
+        return
+    }
+    fmt.Printf("Writen performance results: %s\n", resultsFileName)
+}
+
+// printCounterMetrics prints the mean, the percentiles and the cumulative distribution
+// at every 10% of the probability, for each counter.
+func printCounterMetrics(metrics *modelchecker.PerformanceMetrics) {
+    counters := make([]string, 0, len(metrics.Counters))
+    for counter := range metrics.Counters {
+        counters = append(counters, counter)
+    }
+    slices.Sort(counters)
+    for _, counter := range counters {
+        counterMetrics := metrics.Counters[counter]
+        fmt.Printf("  %s: mean %g", counter, counterMetrics.Mean)
+        percentiles := make([]string, 0, len(counterMetrics.Percentiles))
+        for name := range counterMetrics.Percentiles {
+            percentiles = append(percentiles, name)
+        }
+        slices.Sort(percentiles)
+        for _, name := range percentiles {
+            fmt.Printf(", %s %g", name, counterMetrics.Percentiles[name])
+        }
+        fmt.Println()
+        next := 0.1
+        for _, point := range counterMetrics.Histogram {
+            if point.Probability < next {
+                continue
+            }
+            fmt.Printf("    P(%s <= %g) = %.4f\n", counter, point.Value, point.Probability)
+            for next <= point.Probability {
+                next += 0.1
+            }
+        }
+    }
+    printSampledCosts(metrics.Sampled)
+}
+
+// printContinuousTime prints the expected times, the availability and the transient
+// probabilities of the continuous time analysis.
+func printContinuousTime(report *modelchecker.ContinuousTimeReport) {
+    fmt.Println("Continuous time:")
+    if report.ExpectedTime != nil {
+        fmt.Printf("  Expected time to a final state: %g\n", *report.ExpectedTime)
+    } else {
+        fmt.Println("  Expected time to a final state: unbounded")
+    }
+    for _, invariant := range report.Invariants {
+        fmt.Printf("  Invariant %d %s: probability %.8f", invariant.InvariantIndex, invariant.Name, invariant.Probability)
+        if invariant.ExpectedTime != nil {
+            fmt.Printf(", expected time %g\n", *invariant.ExpectedTime)
+        } else {
+            fmt.Println(", expected time unbounded")
+        }
+    }
+    predicates := make([]string, 0, len(report.Availability))
+    for name := range report.Availability {
+        predicates = append(predicates, name)
+    }
+    slices.Sort(predicates)
+    if len(predicates) > 0 && !report.Converged {
+        fmt.Println("  Warning: the long run distribution did not converge, the availability is approximate")
+    }
+    for _, name := range predicates {
+        fmt.Printf("  Availability of %s: %.8f\n", name, report.Availability[name])
+    }
+    for _, transient := range report.Transient {
+        fmt.Printf("  At time %g:", transient.Time)
+        for _, name := range predicates {
+            fmt.Printf(" %s %.8f", name, transient.Probabilities[name])
+        }
+        fmt.Println()
+    }
+}
+
+// printSampledCosts prints the statistics of the counters on the sampled paths.
+func printSampledCosts(sampled *modelchecker.SampledCosts) {
+    if sampled == nil {
+        return
+    }
+    fmt.Printf("  Sampled %d paths", sampled.Samples)
+    if sampled.Truncated > 0 {
+        fmt.Printf(", %d truncated", sampled.Truncated)
+    }
+    if sampled.NotAbsorbed > 0 {
+        fmt.Printf(", %d never absorbed", sampled.NotAbsorbed)
+    }
+    fmt.Println(":")
+    counters := make([]string, 0, len(sampled.Counters))
+    for counter := range sampled.Counters {
+        counters = append(counters, counter)
+    }
+    slices.Sort(counters)
+    for _, counter := range counters {
+        counterSamples := sampled.Counters[counter]
+        fmt.Printf("  %s: mean %g, stddev %g", counter, counterSamples.Mean, counterSamples.StdDev)
+        percentiles := make([]string, 0, len(counterSamples.Percentiles))
+        for name := range counterSamples.Percentiles {
+            percentiles = append(percentiles, name)
+        }
+        slices.Sort(percentiles)
+        for _, name := range percentiles {
+            fmt.Printf(", %s %g", name, counterSamples.Percentiles[name])
+        }
+        fmt.Println()
+    }
+}
+
+// dumpFailures prints a summary of all the failures, and writes the trace, json and
+// dot files for each of them to the outDir.
+func dumpFailures(failures []*modelchecker.Failure, rootNode *modelchecker.Node, outDir string) {
+    traceLengths := make([]int, len(failures))
+    for i, failure := range failures {
+        failurePath := pathToFailedNode(failure.Node, rootNode)
+        traceLengths[i] = len(failurePath)
+        filePrefix := fmt.Sprintf("violation-%d", i+1)
+        trace := sprintFailurePath(failurePath)
+        if failure.Node.Process.FailedAssertion != nil {
+            trace = trace + failure.Node.Process.FailedAssertion.SprintStackTrace()
+        }
+        if failure.Deadlock != nil {
+            trace = trace + fmt.Sprintf("Disabled actions: %v\n", failure.Deadlock.DisabledActions)
+        }
+        traceFileName := filepath.Join(outDir, filePrefix+".txt")
+        err := os.WriteFile(traceFileName, []byte(trace), 0644)
+        if err != nil {
+            fmt.Println("Error writing to file:", err)
+            return
+        }
+        writeFailurePathFiles(failurePath, failure.Invariant, outDir, filePrefix)
+    }
+    fmt.Printf("FAILED: Model checker found %d violations\n", len(failures))
+    for i, failure := range failures {
+        fmt.Printf("%d. %s, trace length: %d, files: violation-%d.*\n", i+1, failure.Name, traceLengths[i], i+1)
+    }
+}
+
+// printDeadlocks prints the number of deadlocked states of each kind.
+func printDeadlocks(deadlocks []*modelchecker.Deadlock) {
+    counts := make(map[modelchecker.DeadlockKind]int)
+    for _, deadlock := range deadlocks {
+        counts[deadlock.Kind]++
+    }
+    kinds := []modelchecker.DeadlockKind{
+        modelchecker.DeadlockThreadsBlocked,
+        modelchecker.DeadlockNoActionEnabled,
+        modelchecker.DeadlockBoundReached,
+    }
+    for _, kind := range kinds {
+        if counts[kind] > 0 {
+            fmt.Printf("Deadlock states, %s: %d\n", kind, counts[kind])
+        }
+    }
+}
+
 func dumpFailedNode(failedNode *modelchecker.Node, rootNode *modelchecker.Node, outDir string) {
+    failurePath := pathToFailedNode(failedNode, rootNode)
+    GenerateFailurePath(failurePath, nil, outDir)
+}
+
+func pathToFailedNode(failedNode *modelchecker.Node, rootNode *modelchecker.Node) []*modelchecker.Link {
     failurePath := make([]*modelchecker.Link, 0)
     node := failedNode
     for node != nil {
@@ -177,24 +450,38 @@ func dumpFailedNode(failedNode *modelchecker.Node, rootNode *modelchecker.Node,
         node = node.Inbound[0].Node
     }
     slices.Reverse(failurePath)
-    GenerateFailurePath(failurePath, nil, outDir)
+    return failurePath
 }
 
 func GenerateFailurePath(failurePath []*modelchecker.Link, invariant *modelchecker.InvariantPosition, outDir string) {
+    fmt.Print(sprintFailurePath(failurePath))
+    writeFailurePathFiles(failurePath, invariant, outDir, "error-graph")
+}
+
+func sprintFailurePath(failurePath []*modelchecker.Link) string {
+    builder := strings.Builder{}
     for _, link := range failurePath {
         node := link.Node
         stepName := link.Name
 
-        fmt.Printf("------\n%s\n", stepName)
+        builder.WriteString(fmt.Sprintf("------\n%s\n", stepName))
+        for _, line := range link.Logs {
+            builder.WriteString(fmt.Sprintf("print: %s\n", line))
+        }
 
-        fmt.Printf("--\nstate: %s\n", node.Heap.ToJson())
+        builder.WriteString(fmt.Sprintf("--\nstate: %s\n", node.Heap.ToJson()))
         if len(node.Returns) > 0 {
-            fmt.Printf("returns: %s\n", node.Returns.String())
+            builder.WriteString(fmt.Sprintf("returns: %s\n", node.Returns.String()))
         }
     }
-    fmt.Println("------")
+    builder.WriteString("------\n")
+    return builder.String()
+}
+
+// writeFailurePathFiles writes the failure path as json and dot files to the outDir.
+func writeFailurePathFiles(failurePath []*modelchecker.Link, invariant *modelchecker.InvariantPosition, outDir string, filePrefix string) {
     if !isPlayground {
-        errJsonFileName := filepath.Join(outDir, "error-graph.json")
+        errJsonFileName := filepath.Join(outDir, filePrefix+".json")
         bytes, err := json.MarshalIndent(failurePath, "", "  ")
         if err != nil {
             fmt.Println("Error creating json:", err)
@@ -209,7 +496,7 @@ func GenerateFailurePath(failurePath []*modelchecker.Link, invariant *modelcheck
 
     dotStr := modelchecker.GenerateFailurePath(failurePath, invariant)
     //fmt.Println(dotStr)
-    dotFileName := filepath.Join(outDir, "error-graph.dot")
+    dotFileName := filepath.Join(outDir, filePrefix+".dot")
     // Write the content to the file
     err := os.WriteFile(dotFileName, []byte(dotStr), 0644)
     if err != nil {
@@ -218,7 +505,7 @@ func GenerateFailurePath(failurePath []*modelchecker.Link, invariant *modelcheck
     }
     if !isPlayground {
         fmt.Printf("Writen graph dotfile: %s\nTo generate png, run: \n"+
-            "dot -Tpng %s -o error-graph.png && open error-graph.png\n", dotFileName, dotFileName)
+            "dot -Tpng %s -o %s.png && open %s.png\n", dotFileName, dotFileName, filePrefix, filePrefix)
     }
 }
 
@@ -0,0 +1,198 @@
+package modelchecker
+
+import (
+	"fizz/proto"
+	"fmt"
+	"math"
+)
+
+const (
+	// absorbingSolverTolerance is the maximum residual of the linear system for
+	// the absorbing chain solver to consider the solution converged.
+	absorbingSolverTolerance = 1e-12
+	// absorbingSolverMaxIterations is the maximum number of Gauss-Seidel sweeps.
+	absorbingSolverMaxIterations = 100000
+)
+
+// AbsorptionResult has the fundamental matrix quantities of an absorbing Markov
+// chain, for a given initial distribution. They are linear in the initial
+// distribution, so they are probabilities and expectations if it sums to 1.
+type AbsorptionResult struct {
+	// Absorption is the probability of being absorbed in each node, zero for the
+	// transient nodes.
+	Absorption []float64
+	// NotAbsorbed is the probability of never being absorbed, that is, of reaching
+	// the nodes that cannot reach any absorbing node. The absorption probabilities
+	// and NotAbsorbed sum to the total of the initial distribution.
+	NotAbsorbed float64
+	// Visits is the expected number of visits to each transient node before absorption.
+	Visits []float64
+	// ExpectedSteps is the expected number of transitions until absorption, or
+	// +Inf if the chain might never be absorbed.
+	ExpectedSteps float64
+	// Counters is the expected value of each counter accumulated on the transitions
+	// from the transient nodes, until absorption.
+	Counters map[string]float64
+
+	// Iterations is the number of Gauss-Seidel sweeps.
+	Iterations int
+	// Residual is the maximum absolute residual of the visits equations.
+	Residual float64
+	// Converged is true if the residual is within the tolerance.
+	Converged bool
+}
+
+func (r *AbsorptionResult) String() string {
+	status := "converged"
+	if !r.Converged {
+		status = "NOT converged"
+	}
+	return fmt.Sprintf("expected steps: %g, not absorbed: %g, counters: %v (%s in %d iterations, residual %g)",
+		r.ExpectedSteps, r.NotAbsorbed, r.Counters, status, r.Iterations, r.Residual)
+}
+
+// SolveAbsorptionCosts computes the probabilities of reaching the nodes where the
+// invariant holds, and the expected costs to reach them, with the same
+// transition matrix and initial distribution as FindAbsorptionCosts. Unlike
+// FindAbsorptionCosts, it solves the linear equations of the absorbing chain
+// instead of running the chain until the distribution stops changing.
+func SolveAbsorptionCosts(root *Node, perfModel *proto.PerformanceModel, fileId int, invariantId int) *AbsorptionResult {
+	nodes, _, yields := getAllNodes(root)
+	transitionMatrix := createAbsorptionTransitionMatrix(nodes, fileId, invariantId)
+	initialDistribution := absorptionInitialDistribution(nodes, yields)
+	return solveAbsorbingChain(transitionMatrix, genCounterMatrices(nodes, perfModel), initialDistribution)
+}
+
+// solveAbsorbingChain solves the absorbing chain with Gauss-Seidel iterations.
+// The nodes with only a self loop are absorbing. The expected visits v to the
+// transient nodes that can reach an absorbing node satisfy v = π + Qᵀv, where
+// π is the initial distribution and Q is the transitions between them.
+func solveAbsorbingChain(transitionMatrix *SparseMatrix, counterMatrices map[string]*SparseMatrix, initialDistribution []float64) *AbsorptionResult {
+	n := transitionMatrix.N()
+	// The rows of the transpose are the inbound transitions of each node.
+	inbound := transitionMatrix.Transpose()
+	absorbing, escaping := absorbingReachability(transitionMatrix, inbound)
+
+	result := &AbsorptionResult{
+		Absorption: make([]float64, n),
+		Visits:     make([]float64, n),
+		Counters:   make(map[string]float64),
+	}
+	visits := result.Visits
+	for result.Iterations < absorbingSolverMaxIterations {
+		result.Iterations++
+		for i := 0; i < n; i++ {
+			if !escaping[i] {
+				continue
+			}
+			sum := initialDistribution[i]
+			diagonal := 0.0
+			from, probs := inbound.Row(i)
+			for k, j := range from {
+				if j == i {
+					diagonal = probs[k]
+				} else if escaping[j] {
+					sum += probs[k] * visits[j]
+				}
+			}
+			visits[i] = sum / (1 - diagonal)
+		}
+		result.Residual = visitsResidual(inbound, escaping, initialDistribution, visits)
+		if result.Residual <= absorbingSolverTolerance {
+			result.Converged = true
+			break
+		}
+	}
+	if !result.Converged {
+		fmt.Printf("Warning: the absorbing chain solver did not converge in %d iterations, the residual is %g\n",
+			result.Iterations, result.Residual)
+	}
+
+	totalAbsorption := 0.0
+	for i := 0; i < n; i++ {
+		if !absorbing[i] {
+			continue
+		}
+		result.Absorption[i] = initialDistribution[i]
+		from, probs := inbound.Row(i)
+		for k, j := range from {
+			if escaping[j] {
+				result.Absorption[i] += probs[k] * visits[j]
+			}
+		}
+		totalAbsorption += result.Absorption[i]
+	}
+	result.NotAbsorbed = math.Max(0, sum(initialDistribution)-totalAbsorption)
+
+	if result.NotAbsorbed > absorbingSolverTolerance*float64(n) {
+		result.ExpectedSteps = math.Inf(1)
+	} else {
+		result.ExpectedSteps = sum(visits)
+	}
+	for name, counterMatrix := range counterMatrices {
+		// The expected value of the counter on a transition from each node.
+		stepCost := counterMatrix.Hadamard(transitionMatrix).MulVec(ones(n))
+		expected := 0.0
+		for i, v := range visits {
+			expected += v * stepCost[i]
+		}
+		result.Counters[name] = expected
+	}
+	return result
+}
+
+// absorbingReachability returns the absorbing nodes, the nodes with only a self loop,
+// and the transient nodes that can reach an absorbing node. The other transient nodes
+// are in cycles the chain never leaves, so the expected visits to them are infinite.
+// inbound is the transpose of the transition matrix.
+func absorbingReachability(transitionMatrix *SparseMatrix, inbound *SparseMatrix) (absorbing []bool, escaping []bool) {
+	n := transitionMatrix.N()
+	absorbing = make([]bool, n)
+	escaping = make([]bool, n)
+	queue := make([]int, 0, n)
+	for i := range absorbing {
+		absorbing[i] = transitionMatrix.Get(i, i) >= 1.0-absorbingSolverTolerance
+		if absorbing[i] {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		from, _ := inbound.Row(i)
+		for _, j := range from {
+			if !absorbing[j] && !escaping[j] {
+				escaping[j] = true
+				queue = append(queue, j)
+			}
+		}
+	}
+	return absorbing, escaping
+}
+
+// visitsResidual returns the maximum absolute residual of the visits equations.
+func visitsResidual(inbound *SparseMatrix, escaping []bool, initialDistribution []float64, visits []float64) float64 {
+	residual := 0.0
+	for i := range visits {
+		if !escaping[i] {
+			continue
+		}
+		r := initialDistribution[i] - visits[i]
+		from, probs := inbound.Row(i)
+		for k, j := range from {
+			if escaping[j] {
+				r += probs[k] * visits[j]
+			}
+		}
+		residual = math.Max(residual, math.Abs(r))
+	}
+	return residual
+}
+
+func ones(n int) []float64 {
+	vector := make([]float64, n)
+	for i := range vector {
+		vector[i] = 1.0
+	}
+	return vector
+}
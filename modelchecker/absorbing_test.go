@@ -0,0 +1,131 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"math"
+	"testing"
+)
+
+// labeledMarkovChainAstJson is a variant of examples/tutorials/43-simple-markov-chain,
+// written by hand from MarkovChain.fizz as the tutorial has no generated ast in the
+// tree. Unlike the tutorial, the state changes of Prepare and Commit are labeled,
+// so the performance model can attach counters to them.
+const labeledMarkovChainAstJson = `
+{
+  "invariants": [
+    {"eventually": true, "nested": {"always": true, "pyExpr": "state == 'committed'"}}
+  ],
+  "actions": [
+    {
+      "name": "Init",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "state = 'in_progress'"}}]}
+    },
+    {
+      "name": "Prepare",
+      "flow": "FLOW_ATOMIC",
+      "fairness": {"level": "FAIRNESS_LEVEL_WEAK"},
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"ifStmt": {"branches": [{
+        "condition": "state == 'in_progress'",
+        "block": {"stmts": [{"label": "prepare", "pyStmt": {"code": "state = 'prepared'"}}]}
+      }]}}]}
+    },
+    {
+      "name": "BackToWork",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"ifStmt": {"branches": [{
+        "condition": "state == 'prepared'",
+        "block": {"stmts": [{"pyStmt": {"code": "state = 'in_progress'"}}]}
+      }]}}]}
+    },
+    {
+      "name": "Commit",
+      "flow": "FLOW_ATOMIC",
+      "fairness": {"level": "FAIRNESS_LEVEL_STRONG"},
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"ifStmt": {"branches": [{
+        "condition": "state == 'prepared'",
+        "block": {"stmts": [{"label": "commit", "pyStmt": {"code": "state = 'committed'"}}]}
+      }]}}]}
+    },
+    {
+      "name": "StayDone",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"ifStmt": {"branches": [{
+        "condition": "state == 'committed'",
+        "block": {"stmts": [{"pyStmt": {"code": "state = state"}}]}
+      }]}}]}
+    }
+  ]
+}
+`
+
+func TestSolveAbsorptionCosts(t *testing.T) {
+	file, err := parseAstFromString(labeledMarkovChainAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		ContinuePathOnInvariantFailures: true,
+		ContinueOnInvariantFailures:     true,
+		Options:                         &ast.Options{MaxActions: 10, MaxConcurrentActions: 1},
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+	perfModel := &ast.PerformanceModel{}
+	require.Nil(t, protojson.Unmarshal([]byte(`{"configs": {
+		"Prepare.prepare": {"counters": {"work": {"numeric": 1}}},
+		"Commit.commit": {"counters": {"work": {"numeric": 10}}}
+	}}`), perfModel))
+
+	// The chain starts at each of the 3 states with probability 1/4. From in_progress,
+	// it takes 4 steps on average to commit, preparing twice, and from prepared 3 steps.
+	result := SolveAbsorptionCosts(root, perfModel, 0, 0)
+	assert.True(t, result.Converged)
+	assert.LessOrEqual(t, result.Residual, absorbingSolverTolerance)
+	assert.InDelta(t, 0, result.NotAbsorbed, 1e-9)
+	assert.InDelta(t, (4+3)/4.0, result.ExpectedSteps, 1e-9)
+	assert.InDelta(t, (12+11)/4.0, result.Counters["work"], 1e-9)
+
+	// Cross-check with the results of running the chain.
+	distribution, histogram := FindAbsorptionCosts(root, perfModel, 0, 0)
+	for i, p := range result.Absorption {
+		assert.InDelta(t, distribution[i], p, 1e-6)
+	}
+	assert.InDelta(t, histogram.GetMean("work"), result.Counters["work"], 1e-5)
+
+	nodes, _, yields := getAllNodes(root)
+	transitions := createAbsorptionTransitionMatrix(nodes, 0, 0).Transpose()
+	current := absorptionInitialDistribution(nodes, yields)
+	steps := 0.0
+	for i := 0; i < 100000; i++ {
+		transient := 0.0
+		for j, p := range current {
+			if result.Absorption[j] == 0 && transitions.Get(j, j) != 1.0 {
+				transient += p
+			}
+		}
+		if transient < 1e-12 {
+			break
+		}
+		steps += transient
+		current = transitions.MulVec(current)
+	}
+	assert.InDelta(t, steps, result.ExpectedSteps, 1e-6)
+}
+
+func TestSolveAbsorbingChain_NotAbsorbed(t *testing.T) {
+	// 0 -> 1 or 2 with the same probability, 1 is absorbing, and 2 and 3 loop forever.
+	b := newSparseBuilder(4)
+	b.Add(0, 1, 0.5)
+	b.Add(0, 2, 0.5)
+	b.Add(1, 1, 1)
+	b.Add(2, 3, 1)
+	b.Add(3, 2, 1)
+	result := solveAbsorbingChain(b.Build(), nil, []float64{1, 0, 0, 0})
+	assert.True(t, result.Converged)
+	assert.Equal(t, []float64{0, 0.5, 0, 0}, result.Absorption)
+	assert.InDelta(t, 0.5, result.NotAbsorbed, 1e-12)
+	assert.True(t, math.IsInf(result.ExpectedSteps, 1))
+}
@@ -0,0 +1,307 @@
+package modelchecker
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"sort"
+	"strings"
+)
+
+// Bag is a multiset, an unordered collection that can hold the same element more than
+// once, for example, the messages in flight in an unordered network.
+// Two bags are equal if they have the same elements with the same counts.
+type Bag struct {
+	// counts maps each element to the number of times it is in the bag.
+	counts *starlark.Dict
+	size   int
+	frozen bool
+}
+
+var (
+	_ starlark.Iterable   = (*Bag)(nil)
+	_ starlark.Sequence   = (*Bag)(nil)
+	_ starlark.Comparable = (*Bag)(nil)
+	_ starlark.HasAttrs   = (*Bag)(nil)
+	_ starlark.HasBinary  = (*Bag)(nil)
+)
+
+func NewBag() *Bag {
+	return &Bag{counts: starlark.NewDict(0)}
+}
+
+// NewBagFromValues returns a bag with the elements of the iterable.
+func NewBagFromValues(iterable starlark.Iterable) (*Bag, error) {
+	b := NewBag()
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for iter.Next(&x) {
+		if err := b.Add(x, 1); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Add adds n occurrences of the element to the bag.
+func (b *Bag) Add(x starlark.Value, n int) error {
+	if b.frozen {
+		return fmt.Errorf("cannot insert into frozen bag")
+	}
+	count := b.Count(x)
+	if err := b.counts.SetKey(x, starlark.MakeInt(count+n)); err != nil {
+		return err
+	}
+	b.size += n
+	return nil
+}
+
+// Remove removes one occurrence of the element from the bag.
+func (b *Bag) Remove(x starlark.Value) error {
+	if b.frozen {
+		return fmt.Errorf("cannot remove from frozen bag")
+	}
+	count := b.Count(x)
+	if count == 0 {
+		return fmt.Errorf("bag.remove(%s): element not found", x)
+	}
+	var err error
+	if count == 1 {
+		_, _, err = b.counts.Delete(x)
+	} else {
+		err = b.counts.SetKey(x, starlark.MakeInt(count-1))
+	}
+	b.size--
+	return err
+}
+
+// Count returns the number of occurrences of the element in the bag.
+func (b *Bag) Count(x starlark.Value) int {
+	v, found, _ := b.counts.Get(x)
+	if !found {
+		return 0
+	}
+	count, _ := starlark.AsInt32(v)
+	return count
+}
+
+// Items returns the distinct elements with their counts, in the order they were added.
+func (b *Bag) Items() []starlark.Tuple {
+	return b.counts.Items()
+}
+
+func (b *Bag) String() string {
+	buf := &strings.Builder{}
+	buf.WriteString("bag([")
+	iter := b.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for i := 0; iter.Next(&x); i++ {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(x.String())
+	}
+	buf.WriteString("])")
+	return buf.String()
+}
+
+func (b *Bag) Type() string { return "bag" }
+
+func (b *Bag) Freeze() {
+	if !b.frozen {
+		b.frozen = true
+		b.counts.Freeze()
+	}
+}
+
+func (b *Bag) Truth() starlark.Bool { return b.size > 0 }
+
+func (b *Bag) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: bag") }
+
+func (b *Bag) Len() int { return b.size }
+
+// Iterate returns each element as many times as it is in the bag.
+func (b *Bag) Iterate() starlark.Iterator {
+	return &bagIterator{items: b.counts.Items()}
+}
+
+type bagIterator struct {
+	items []starlark.Tuple
+	// repeat is the number of times the current element was returned.
+	repeat int
+}
+
+func (it *bagIterator) Next(p *starlark.Value) bool {
+	for len(it.items) > 0 {
+		count, _ := starlark.AsInt32(it.items[0][1])
+		if it.repeat < count {
+			*p = it.items[0][0]
+			it.repeat++
+			return true
+		}
+		it.items = it.items[1:]
+		it.repeat = 0
+	}
+	return false
+}
+
+func (it *bagIterator) Done() {}
+
+func (b *Bag) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	other := y.(*Bag)
+	switch op {
+	case syntax.EQL:
+		return b.equals(other), nil
+	case syntax.NEQ:
+		return !b.equals(other), nil
+	default:
+		return false, fmt.Errorf("%s %s %s not implemented", b.Type(), op, other.Type())
+	}
+}
+
+func (b *Bag) equals(other *Bag) bool {
+	if b.size != other.size || b.counts.Len() != other.counts.Len() {
+		return false
+	}
+	for _, item := range b.counts.Items() {
+		count, _ := starlark.AsInt32(item[1])
+		if other.Count(item[0]) != count {
+			return false
+		}
+	}
+	return true
+}
+
+// Binary implements `x in bag`, and the sum (+) and the difference (-) of two bags.
+func (b *Bag) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	if op == syntax.IN && side == starlark.Right {
+		return starlark.Bool(b.Count(y) > 0), nil
+	}
+	other, ok := y.(*Bag)
+	if !ok {
+		return nil, nil
+	}
+	x, y2 := b, other
+	if side == starlark.Right {
+		x, y2 = other, b
+	}
+	result := x.Clone()
+	switch op {
+	case syntax.PLUS:
+		for _, item := range y2.counts.Items() {
+			count, _ := starlark.AsInt32(item[1])
+			if err := result.Add(item[0], count); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case syntax.MINUS:
+		for _, item := range y2.counts.Items() {
+			count, _ := starlark.AsInt32(item[1])
+			for i := 0; i < count && result.Count(item[0]) > 0; i++ {
+				if err := result.Remove(item[0]); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return result, nil
+	}
+	return nil, nil
+}
+
+// Clone returns a copy of the bag that shares the elements.
+func (b *Bag) Clone() *Bag {
+	result := NewBag()
+	for _, item := range b.counts.Items() {
+		count, _ := starlark.AsInt32(item[1])
+		PanicOnError(result.Add(item[0], count))
+	}
+	return result
+}
+
+var bagMethods = map[string]*starlark.Builtin{
+	"add":      starlark.NewBuiltin("add", bagAdd),
+	"remove":   starlark.NewBuiltin("remove", bagRemove),
+	"discard":  starlark.NewBuiltin("discard", bagDiscard),
+	"count":    starlark.NewBuiltin("count", bagCount),
+	"distinct": starlark.NewBuiltin("distinct", bagDistinct),
+}
+
+func (b *Bag) Attr(name string) (starlark.Value, error) {
+	method, ok := bagMethods[name]
+	if !ok {
+		return nil, nil
+	}
+	return method.BindReceiver(b), nil
+}
+
+func (b *Bag) AttrNames() []string {
+	names := make([]string, 0, len(bagMethods))
+	for name := range bagMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bag.add(x) adds an occurrence of x to the bag.
+func bagAdd(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	return starlark.None, fn.Receiver().(*Bag).Add(x, 1)
+}
+
+// bag.remove(x) removes an occurrence of x from the bag, and fails if there is none.
+func bagRemove(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	return starlark.None, fn.Receiver().(*Bag).Remove(x)
+}
+
+// bag.discard(x) removes an occurrence of x from the bag, if there is any.
+func bagDiscard(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	b := fn.Receiver().(*Bag)
+	if b.Count(x) == 0 {
+		return starlark.None, nil
+	}
+	return starlark.None, b.Remove(x)
+}
+
+// bag.count(x) returns the number of occurrences of x in the bag.
+func bagCount(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &x); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(fn.Receiver().(*Bag).Count(x)), nil
+}
+
+// bag.distinct() returns the list of the distinct elements in the bag.
+func bagDistinct(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	return starlark.NewList(fn.Receiver().(*Bag).counts.Keys()), nil
+}
+
+// bag(iterable=[]) returns a new bag with the elements of the iterable.
+func makeBag(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 0, &iterable); err != nil {
+		return nil, err
+	}
+	if iterable == nil {
+		return NewBag(), nil
+	}
+	return NewBagFromValues(iterable)
+}
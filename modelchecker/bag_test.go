@@ -0,0 +1,137 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"testing"
+)
+
+func TestBag(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{name: "empty", expr: "bag()", expected: "bag([])"},
+		{name: "duplicates", expr: "bag([1, 2, 1])", expected: "bag([1, 1, 2])"},
+		{name: "len", expr: "len(bag([1, 2, 1]))", expected: "3"},
+		{name: "count", expr: "bag([1, 2, 1]).count(1)", expected: "2"},
+		{name: "in", expr: "2 in bag([1, 2])", expected: "True"},
+		{name: "notIn", expr: "3 in bag([1, 2])", expected: "False"},
+		{name: "orderIgnored", expr: "bag([1, 2, 1]) == bag([2, 1, 1])", expected: "True"},
+		{name: "countsCompared", expr: "bag([1, 2]) == bag([1, 2, 2])", expected: "False"},
+		{name: "sum", expr: "bag([1]) + bag([1, 2])", expected: "bag([1, 1, 2])"},
+		{name: "difference", expr: "bag([1, 1, 2]) - bag([1, 3])", expected: "bag([1, 2])"},
+		{name: "distinct", expr: "sorted(bag([2, 1, 2]).distinct())", expected: "[1, 2]"},
+		{name: "record", expr: "record(seq=1, type='ack')", expected: `record(seq = 1, type = "ack")`},
+		{name: "recordUpdate", expr: "(record(seq=1, type='ack') + record(seq=2)).seq", expected: "2"},
+		{name: "recordEqual", expr: "record(a=1, b=2) == record(b=2, a=1)", expected: "True"},
+		{name: "recordInBag", expr: "bag([record(a=1), record(a=1)]).count(record(a=1))", expected: "2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, evalValue(t, test.expr).String())
+		})
+	}
+}
+
+func TestBuiltins_NotInUniverse(t *testing.T) {
+	// The builtins are predeclared by each Evaluator, so they do not leak into the
+	// other users of starlark in the same process.
+	evalValue(t, "record(a=bag([1]))")
+	for _, name := range []string{"record", "bag", "std"} {
+		assert.NotContains(t, starlark.Universe, name)
+	}
+	globals, err := NewModelChecker("test").ExecInit(&ast.StateVars{Code: "r = record(a=1)\nb = bag()"})
+	require.Nil(t, err)
+	assert.ElementsMatch(t, []string{"r", "b"}, globals.Keys())
+}
+
+func TestBag_Methods(t *testing.T) {
+	globals := starlark.StringDict{}
+	mc := NewModelChecker("test")
+	_, err := mc.ExecPyStmt("test.fizz", &ast.PyStmt{Code: "b = bag([1, 2, 1])\nb.add(3)\nb.remove(1)\nb.discard(5)"}, globals)
+	require.Nil(t, err)
+	assert.Equal(t, "bag([1, 2, 3])", globals["b"].String())
+
+	_, err = mc.ExecPyStmt("test.fizz", &ast.PyStmt{Code: "b.remove(5)"}, globals)
+	assert.NotNil(t, err)
+	_, err = mc.ExecPyStmt("test.fizz", &ast.PyStmt{Code: "r = record(1)"}, globals)
+	assert.NotNil(t, err)
+}
+
+func TestBag_Clone(t *testing.T) {
+	value := evalValue(t, "bag([record(a=1), record(a=1), 2])")
+	cloned, err := deepCloneStarlarkValue(value)
+	require.Nil(t, err)
+	assert.Equal(t, CanonicalBytes(value), CanonicalBytes(cloned))
+	require.Nil(t, cloned.(*Bag).Remove(starlark.MakeInt(2)))
+	assert.Equal(t, 3, value.(*Bag).Len())
+
+	orig := evalValue(t, "record(items=[1, 2])")
+	cloned, err = deepCloneStarlarkValue(orig)
+	require.Nil(t, err)
+	items, err := cloned.(starlark.HasAttrs).Attr("items")
+	require.Nil(t, err)
+	require.Nil(t, items.(*starlark.List).Append(starlark.MakeInt(3)))
+	assert.Equal(t, `record(items = [1, 2])`, orig.String())
+	assert.Equal(t, `record(items = [1, 2, 3])`, cloned.String())
+}
+
+// Two actions adding different messages to a bag of messages in flight.
+const bagAstJson = `
+{
+  "states": {
+    "code": "msgs = bag()"
+  },
+  "actions": [
+    {
+      "name": "SendA",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {"pyStmt": {"code": "msgs.add(record(type='a'))"}}
+        ]
+      }
+    },
+    {
+      "name": "SendB",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {"pyStmt": {"code": "msgs.add(record(type='b'))"}}
+        ]
+      }
+    }
+  ]
+}
+`
+
+func TestProcessor_Bag(t *testing.T) {
+	file, err := parseAstFromString(bagAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           2,
+			MaxConcurrentActions: 1,
+		},
+	})
+	require.Nil(t, err)
+	_, _, err = p1.Start()
+	require.Nil(t, err)
+	nodes, _, _ := GetAllNodes(p1.Init)
+	// The order the messages were sent in does not matter, so SendA then SendB
+	// reaches the same node as SendB then SendA.
+	both := 0
+	for _, node := range nodes {
+		msgs := node.Heap.globals["msgs"].(*Bag)
+		if msgs.Len() == 2 && msgs.Count(evalValue(t, "record(type='a')")) == 1 {
+			both++
+		}
+	}
+	assert.Equal(t, 1, both)
+}
@@ -0,0 +1,32 @@
+package modelchecker
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// recordConstructor is the constructor of the structs created with record(),
+// so they print as record(...) and only compare equal to other records.
+var recordConstructor = starlark.String("record")
+
+// newBuiltins returns the fizz specific types and the std module, predeclared in
+// every statement and expression of an Evaluator, so they are available to every
+// spec without being part of the state.
+func newBuiltins() starlark.StringDict {
+	return starlark.StringDict{
+		"record": starlark.NewBuiltin("record", makeRecord),
+		"bag":    starlark.NewBuiltin("bag", makeBag),
+		"std":    stdModule,
+	}
+}
+
+// record(**kwargs) returns an immutable record with the given fields. For example,
+// `msg = record(type="ack", seq=1)` and then `msg.seq`. Use `msg + record(seq=2)` to get
+// a copy with some of the fields updated.
+func makeRecord(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if len(args) > 0 {
+		return nil, fmt.Errorf("%s: unexpected positional arguments", fn.Name())
+	}
+	return starlarkstruct.FromKeywords(recordConstructor, kwargs), nil
+}
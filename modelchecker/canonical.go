@@ -0,0 +1,265 @@
+package modelchecker
+
+import (
+	"bytes"
+	"encoding/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// The type tags of the canonical byte form. Each value is encoded as the tag
+// followed by its contents, so values of different types never encode the same.
+const (
+	canonicalNone   = 'N'
+	canonicalTrue   = 'T'
+	canonicalFalse  = 'F'
+	canonicalInt    = 'i'
+	canonicalFloat  = 'f'
+	canonicalString = 's'
+	canonicalBytes  = 'b'
+	canonicalList   = 'l'
+	canonicalTuple  = 't'
+	canonicalSet    = 'S'
+	canonicalDict   = 'd'
+	canonicalStruct = 'r'
+	canonicalBag    = 'B'
+	canonicalOther  = '?'
+)
+
+// CanonicalBytes returns a stable byte form of the value for hashing. Equal values
+// encode the same irrespective of the insertion order of the sets and the dicts,
+// at any level of nesting.
+func CanonicalBytes(v starlark.Value) []byte {
+	return appendCanonical(nil, v)
+}
+
+// StringDictCanonicalBytes returns the stable byte form of the variables, sorted by name.
+func StringDictCanonicalBytes(dict starlark.StringDict) []byte {
+	var buf []byte
+	for _, name := range dict.Keys() {
+		buf = appendCanonicalString(buf, canonicalString, name)
+		buf = appendCanonical(buf, dict[name])
+	}
+	return buf
+}
+
+func appendCanonical(buf []byte, v starlark.Value) []byte {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return append(buf, canonicalNone)
+	case starlark.Bool:
+		if v {
+			return append(buf, canonicalTrue)
+		}
+		return append(buf, canonicalFalse)
+	case starlark.Int:
+		buf = append(buf, canonicalInt)
+		buf = append(buf, v.String()...)
+		return append(buf, ';')
+	case starlark.Float:
+		buf = append(buf, canonicalFloat)
+		buf = strconv.AppendFloat(buf, float64(v), 'g', -1, 64)
+		return append(buf, ';')
+	case starlark.String:
+		return appendCanonicalString(buf, canonicalString, string(v))
+	case starlark.Bytes:
+		return appendCanonicalString(buf, canonicalBytes, string(v))
+	case *starlark.List:
+		return appendCanonicalSequence(buf, canonicalList, v)
+	case starlark.Tuple:
+		return appendCanonicalSequence(buf, canonicalTuple, v)
+	case *starlark.Set:
+		elems := make([][]byte, 0, v.Len())
+		iter := v.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			elems = append(elems, CanonicalBytes(x))
+		}
+		return appendSorted(appendLength(append(buf, canonicalSet), len(elems)), elems)
+	case *starlark.Dict:
+		entries := make([][]byte, 0, v.Len())
+		for _, item := range v.Items() {
+			entries = append(entries, appendCanonical(CanonicalBytes(item[0]), item[1]))
+		}
+		return appendSorted(appendLength(append(buf, canonicalDict), len(entries)), entries)
+	case *starlarkstruct.Struct:
+		buf = append(buf, canonicalStruct)
+		buf = appendCanonical(buf, v.Constructor())
+		names := v.AttrNames()
+		buf = appendLength(buf, len(names))
+		for _, name := range names {
+			field, err := v.Attr(name)
+			PanicOnError(err)
+			buf = appendCanonicalString(buf, canonicalString, name)
+			buf = appendCanonical(buf, field)
+		}
+		return buf
+	case *Bag:
+		entries := make([][]byte, 0, len(v.Items()))
+		for _, item := range v.Items() {
+			entries = append(entries, appendCanonical(CanonicalBytes(item[0]), item[1]))
+		}
+		return appendSorted(appendLength(append(buf, canonicalBag), len(entries)), entries)
+	}
+	buf = appendCanonicalString(buf, canonicalOther, v.Type())
+	return appendCanonicalString(buf, canonicalOther, v.String())
+}
+
+func appendCanonicalString(buf []byte, tag byte, s string) []byte {
+	buf = appendLength(append(buf, tag), len(s))
+	return append(buf, s...)
+}
+
+func appendCanonicalSequence(buf []byte, tag byte, seq starlark.Indexable) []byte {
+	buf = appendLength(append(buf, tag), seq.Len())
+	for i := 0; i < seq.Len(); i++ {
+		buf = appendCanonical(buf, seq.Index(i))
+	}
+	return buf
+}
+
+func appendLength(buf []byte, n int) []byte {
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, ':')
+}
+
+func appendSorted(buf []byte, elems [][]byte) []byte {
+	sort.Slice(elems, func(i, j int) bool {
+		return bytes.Compare(elems[i], elems[j]) < 0
+	})
+	for _, elem := range elems {
+		buf = append(buf, elem...)
+	}
+	return buf
+}
+
+// ToJsonValue converts the value to a value encoding/json marshals as typed JSON:
+// numbers, booleans, strings and null for the scalars, arrays for the lists, tuples
+// sets and bags, and objects for the structs and the dicts with string keys. A dict
+// with any other key is an array of [key, value] pairs, so the keys 1 and "1" do not
+// collide. The sets and the pairs are sorted, and json sorts the object keys, so equal
+// values marshal the same.
+func ToJsonValue(v starlark.Value) interface{} {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.Bool:
+		return bool(v)
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i
+		}
+		return json.Number(v.String())
+	case starlark.Float:
+		if math.IsInf(float64(v), 0) || math.IsNaN(float64(v)) {
+			return v.String()
+		}
+		return float64(v)
+	case starlark.String:
+		return string(v)
+	case starlark.Bytes:
+		return string(v)
+	case *starlark.List:
+		return sequenceToJsonValue(v)
+	case starlark.Tuple:
+		return sequenceToJsonValue(v)
+	case *starlark.Set:
+		elems := make([]starlark.Value, 0, v.Len())
+		iter := v.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			elems = append(elems, x)
+		}
+		sortCanonical(elems)
+		list := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			list[i] = ToJsonValue(elem)
+		}
+		return list
+	case *starlark.Dict:
+		if !hasOnlyStringKeys(v) {
+			return dictToJsonPairs(v)
+		}
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			m[string(item[0].(starlark.String))] = ToJsonValue(item[1])
+		}
+		return m
+	case *starlarkstruct.Struct:
+		names := v.AttrNames()
+		m := make(map[string]interface{}, len(names))
+		for _, name := range names {
+			field, err := v.Attr(name)
+			PanicOnError(err)
+			m[name] = ToJsonValue(field)
+		}
+		return m
+	case *Bag:
+		elems := make([]starlark.Value, 0, v.Len())
+		iter := v.Iterate()
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			elems = append(elems, x)
+		}
+		sortCanonical(elems)
+		return sequenceToJsonValue(starlark.Tuple(elems))
+	}
+	return v.String()
+}
+
+func hasOnlyStringKeys(dict *starlark.Dict) bool {
+	for _, key := range dict.Keys() {
+		if _, ok := key.(starlark.String); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// dictToJsonPairs returns the [key, value] pairs of the dict, sorted by the key.
+func dictToJsonPairs(dict *starlark.Dict) []interface{} {
+	keys := dict.Keys()
+	sortCanonical(keys)
+	pairs := make([]interface{}, len(keys))
+	for i, key := range keys {
+		value, _, err := dict.Get(key)
+		PanicOnError(err)
+		pairs[i] = []interface{}{ToJsonValue(key), ToJsonValue(value)}
+	}
+	return pairs
+}
+
+func sequenceToJsonValue(seq starlark.Indexable) []interface{} {
+	list := make([]interface{}, seq.Len())
+	for i := range list {
+		list[i] = ToJsonValue(seq.Index(i))
+	}
+	return list
+}
+
+// sortCanonical sorts the values by their canonical byte form.
+func sortCanonical(values []starlark.Value) {
+	keys := make([][]byte, len(values))
+	for i, v := range values {
+		keys[i] = CanonicalBytes(v)
+	}
+	sort.Sort(canonicalSorter{values: values, keys: keys})
+}
+
+type canonicalSorter struct {
+	values []starlark.Value
+	keys   [][]byte
+}
+
+func (s canonicalSorter) Len() int           { return len(s.values) }
+func (s canonicalSorter) Less(i, j int) bool { return bytes.Compare(s.keys[i], s.keys[j]) < 0 }
+func (s canonicalSorter) Swap(i, j int) {
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+}
@@ -0,0 +1,79 @@
+package modelchecker
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"testing"
+)
+
+func evalValue(t *testing.T, expr string) starlark.Value {
+	value, err := NewModelChecker("test").EvalPyExpr("test.fizz", expr, starlark.StringDict{})
+	require.Nil(t, err)
+	return value
+}
+
+func TestCanonicalBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     string
+		b     string
+		equal bool
+	}{
+		{name: "setOrder", a: "set([1, 2, 3])", b: "set([3, 1, 2])", equal: true},
+		{name: "dictOrder", a: "{'a': 1, 'b': 2}", b: "{'b': 2, 'a': 1}", equal: true},
+		{name: "nestedSetInDict", a: "{'x': set([1, 2])}", b: "{'x': set([2, 1])}", equal: true},
+		{name: "nestedSetInList", a: "[set(['a', 'b']), 1]", b: "[set(['b', 'a']), 1]", equal: true},
+		{name: "nestedDictInDict", a: "{1: {'a': 1, 'b': 2}}", b: "{1: {'b': 2, 'a': 1}}", equal: true},
+		{name: "listOrder", a: "[1, 2]", b: "[2, 1]", equal: false},
+		{name: "listAndTuple", a: "[1, 2]", b: "(1, 2)", equal: false},
+		{name: "intAndString", a: "1", b: "'1'", equal: false},
+		{name: "stringBoundaries", a: "['ab', 'c']", b: "['a', 'bc']", equal: false},
+		{name: "dictValues", a: "{'a': 1}", b: "{'a': 2}", equal: false},
+		{name: "noneAndString", a: "None", b: "'None'", equal: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := CanonicalBytes(evalValue(t, test.a))
+			b := CanonicalBytes(evalValue(t, test.b))
+			assert.Equal(t, test.equal, string(a) == string(b))
+		})
+	}
+	t.Run("struct", func(t *testing.T) {
+		s1 := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"a": starlark.MakeInt(1), "b": evalValue(t, "set([1, 2])"),
+		})
+		s2 := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"b": evalValue(t, "set([2, 1])"), "a": starlark.MakeInt(1),
+		})
+		assert.Equal(t, CanonicalBytes(s1), CanonicalBytes(s2))
+	})
+}
+
+func TestStringDictToJson(t *testing.T) {
+	dict := starlark.StringDict{
+		"count":    starlark.MakeInt(3),
+		"ratio":    starlark.Float(0.5),
+		"done":     starlark.False,
+		"name":     starlark.String("a"),
+		"nothing":  starlark.None,
+		"elements": evalValue(t, "set(['b', 'a'])"),
+		"nested":   evalValue(t, "{'z': [1, (2, 3)], 'a': {1: set([2, 1])}}"),
+	}
+	assert.Equal(t,
+		`{"count":3,"done":false,"elements":["a","b"],"name":"a",`+
+			`"nested":{"a":[[1,[1,2]]],"z":[1,[2,3]]},"nothing":null,"ratio":0.5}`,
+		StringDictToJsonString(dict))
+
+	// The keys 1 and "1" are different keys, so they must not collide.
+	mixed := starlark.StringDict{"d": evalValue(t, "{'1': 'b', 1: 'a'}")}
+	assert.Equal(t, `{"d":[[1,"a"],["1","b"]]}`, StringDictToJsonString(mixed))
+}
+
+func TestHeap_HashCode(t *testing.T) {
+	h1 := &Heap{globals: starlark.StringDict{"x": evalValue(t, "{'a': set([1, 2]), 'b': []}")}}
+	h2 := &Heap{globals: starlark.StringDict{"x": evalValue(t, "{'b': [], 'a': set([2, 1])}")}}
+	assert.Equal(t, h1.HashCode(), h2.HashCode())
+	assert.Equal(t, h1.ToJson(), h2.ToJson())
+}
@@ -6,18 +6,33 @@ import (
 	"github.com/golang/glog"
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
+	"io"
 	"log"
 )
 
 type Evaluator struct {
 	options *syntax.FileOptions
 	thread  *starlark.Thread
+	// builtins are the fizz specific types and modules, see newBuiltins.
+	builtins starlark.StringDict
+	// helpers are the pure helper functions visible to every statement and expression.
+	helpers starlark.StringDict
+	// prints collects the output of print(), or nil to discard it.
+	prints *[]string
+	// echo writes the output of print() as soon as it is printed, or nil to not echo it.
+	echo io.Writer
+	// silenced drops the output of print(), neither kept nor echoed, see silencePrints.
+	silenced bool
+	// cache holds the compiled expressions and the parsed statements.
+	cache *compileCache
 }
 
 func NewEvaluator(options *syntax.FileOptions, thread *starlark.Thread) *Evaluator {
 	return &Evaluator{
-		options: options,
-		thread:  thread,
+		options:  options,
+		thread:   thread,
+		builtins: newBuiltins(),
+		cache:    newCompileCache(),
 	}
 }
 
@@ -139,7 +154,9 @@ func (e *Evaluator) ExecInit(variables *ast.StateVars) (starlark.StringDict, err
 		return nil, err
 	}
 
+	added := e.addHelpers(predeclared)
 	err = starlark.ExecREPLChunk(f, e.thread, predeclared)
+	e.removeHelpers(predeclared, added)
 	return predeclared, err
 
 	//glog.Info("Running Init")
@@ -167,10 +184,47 @@ func (e *Evaluator) ExecInitOld(variables *ast.StateVars) (starlark.StringDict,
 func NewModelChecker(name string) *Evaluator {
 	thread := &starlark.Thread{
 		Name:  name,
-		Print: func(_ *starlark.Thread, msg string) { fmt.Println(msg) },
 	}
 	options := &syntax.FileOptions{Set: true, GlobalReassign: true, TopLevelControl: true}
 
 	mc := NewEvaluator(options, thread)
+	// The states are explored in no particular order, so printing to the console would
+	// interleave the output of unrelated steps. Instead, the output is kept with the step.
+	thread.Print = func(_ *starlark.Thread, msg string) {
+		if mc.silenced {
+			return
+		}
+		if mc.prints != nil {
+			*mc.prints = append(*mc.prints, msg)
+		}
+		if mc.echo != nil {
+			fmt.Fprintln(mc.echo, msg)
+		}
+	}
 	return mc
 }
+
+// EchoPrints writes the output of print() to w as soon as it is printed,
+// in addition to keeping it with the step. A nil w stops echoing.
+func (e *Evaluator) EchoPrints(w io.Writer) {
+	e.echo = w
+}
+
+// silencePrints drops the output of print() until the returned function is called,
+// for the statements run on a copy of a process that is not added to the graph.
+func (e *Evaluator) silencePrints() func() {
+	prev := e.silenced
+	e.silenced = true
+	return func() {
+		e.silenced = prev
+	}
+}
+
+// capturePrints appends the output of print() to logs until the returned function is called.
+func (e *Evaluator) capturePrints(logs *[]string) func() {
+	prev := e.prints
+	e.prints = logs
+	return func() {
+		e.prints = prev
+	}
+}
@@ -1,6 +1,7 @@
 package modelchecker
 
 import (
+	"bytes"
 	ast "fizz/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -100,3 +101,32 @@ func TestExecBlock(t *testing.T) {
 	})
 
 }
+
+func TestEvaluator_EchoPrints(t *testing.T) {
+	checker := NewModelChecker("test")
+	var echoed bytes.Buffer
+	checker.EchoPrints(&echoed)
+
+	var logs []string
+	stopCapture := checker.capturePrints(&logs)
+	_, err := checker.ExecPyStmt("test.star", &ast.PyStmt{Code: "print('in step')"}, starlark.StringDict{})
+	stopCapture()
+	require.Nil(t, err)
+
+	// Prints outside a step, like the ones in invariants, are only echoed.
+	_, err = checker.EvalPyExpr("test.star", "print('in invariant') == None", starlark.StringDict{})
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{"in step"}, logs)
+	assert.Equal(t, "in step\nin invariant\n", echoed.String())
+
+	// Silenced prints are neither kept nor echoed, even inside a step.
+	unsilence := checker.silencePrints()
+	stopCapture = checker.capturePrints(&logs)
+	_, err = checker.ExecPyStmt("test.star", &ast.PyStmt{Code: "print('in trial')"}, starlark.StringDict{})
+	stopCapture()
+	unsilence()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"in step"}, logs)
+	assert.Equal(t, "in step\nin invariant\n", echoed.String())
+}
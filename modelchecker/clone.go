@@ -3,6 +3,7 @@ package modelchecker
 import (
     "fmt"
     "go.starlark.net/starlark"
+    "go.starlark.net/starlarkstruct"
 )
 
 func deepCloneStarlarkValue(value starlark.Value) (starlark.Value, error) {
@@ -69,6 +70,34 @@ func deepCloneStarlarkValue(value starlark.Value) (starlark.Value, error) {
         }
         return newDict, nil
 
+    case "struct":
+        v := value.(*starlarkstruct.Struct)
+        fields := starlark.StringDict{}
+        v.ToStringDict(fields)
+        for name, field := range fields {
+            clonedField, err := deepCloneStarlarkValue(field)
+            if err != nil {
+                return nil, err
+            }
+            fields[name] = clonedField
+        }
+        return starlarkstruct.FromStringDict(v.Constructor(), fields), nil
+    case "bag":
+        v := value.(*Bag)
+        newBag := NewBag()
+        for _, item := range v.Items() {
+            clonedElem, err := deepCloneStarlarkValue(item[0])
+            if err != nil {
+                return nil, err
+            }
+            count, _ := starlark.AsInt32(item[1])
+            err = newBag.Add(clonedElem, count)
+            if err != nil {
+                return nil, err
+            }
+        }
+        return newBag, nil
+
     default:
         return nil, fmt.Errorf("unsupported type: %T, %s", value, value.Type())
     }
@@ -77,6 +106,7 @@ func deepCloneStarlarkValue(value starlark.Value) (starlark.Value, error) {
 func deepCloneIterableToList(iterable starlark.Iterable) ([]starlark.Value, error) {
     var newList []starlark.Value
     iter := iterable.Iterate()
+    defer iter.Done()
     var x starlark.Value
     for iter.Next(&x) {
         clonedElem, err := deepCloneStarlarkValue(x)
@@ -0,0 +1,187 @@
+package modelchecker
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// retvalName is the global the compiled expressions assign their value to.
+// It is not a valid identifier, so it never clashes with a name in the source.
+const retvalName = "$retval"
+
+// compileCache holds the programs compiled from the expressions and the statements,
+// so the same source is parsed, resolved and compiled once, not for every state.
+type compileCache struct {
+	exprs map[string]*cachedProgram
+	stmts map[string]*cachedProgram
+}
+
+// cachedProgram is a source compiled once for each combination of its identifiers
+// that are defined. An identifier resolves to the variable if it is defined, and to
+// the builtin or to an undefined name error otherwise, so two states defining the
+// same identifiers resolve the source the same.
+type cachedProgram struct {
+	// names are the identifiers in the source.
+	names    []string
+	variants map[string]*starlark.Program
+}
+
+func newCompileCache() *compileCache {
+	return &compileCache{
+		exprs: make(map[string]*cachedProgram),
+		stmts: make(map[string]*cachedProgram),
+	}
+}
+
+func cacheKey(filename string, src string) string {
+	return filename + "\x00" + src
+}
+
+// exprCacheKey returns the cache key of the expression source, a string or a portion
+// of a file, whose position is part of the key as it shows in the errors.
+func exprCacheKey(filename string, src interface{}) (string, error) {
+	switch src := src.(type) {
+	case string:
+		return cacheKey(filename, src), nil
+	case syntax.FilePortion:
+		return cacheKey(fmt.Sprintf("%s:%d:%d", filename, src.FirstLine, src.FirstCol), string(src.Content)), nil
+	}
+	return "", fmt.Errorf("unsupported expression source %T", src)
+}
+
+// evalExpr evaluates the expression with the variables looked up by lookup.
+// The defined identifiers are predeclared names of the compiled program.
+func (c *compileCache) evalExpr(options *syntax.FileOptions, thread *starlark.Thread, filename string, src interface{},
+	lookup func(name string) (starlark.Value, bool)) (starlark.Value, error) {
+
+	key, err := exprCacheKey(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	cached := c.exprs[key]
+	if cached == nil {
+		parsed, err := options.ParseExpr(filename, src, 0)
+		if err != nil {
+			return nil, err
+		}
+		cached = &cachedProgram{names: identNames(parsed), variants: make(map[string]*starlark.Program)}
+		c.exprs[key] = cached
+	}
+
+	env, mask := cached.env(lookup, "")
+	program, err := cached.variant(mask, env, func() (*syntax.File, error) {
+		// The resolver annotates the tree, so each variant is compiled from its own.
+		expr, err := options.ParseExpr(filename, src, 0)
+		if err != nil {
+			return nil, err
+		}
+		pos := syntax.Start(expr)
+		assign := &syntax.AssignStmt{OpPos: pos, Op: syntax.EQ, LHS: &syntax.Ident{NamePos: pos, Name: retvalName}, RHS: expr}
+		return &syntax.File{Path: filename, Stmts: []syntax.Stmt{assign}, Options: options}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	globals, err := program.Init(thread, env)
+	if err != nil {
+		return nil, err
+	}
+	return globals[retvalName], nil
+}
+
+// execStmt executes the statements with the variables looked up by lookup, and
+// returns the globals after the execution, even if it failed.
+//
+// Like starlark.ExecREPLChunk, each defined identifier is a global of the module,
+// bound to its value before the statements run. The program starts by assigning
+// the predeclared $name to name for each of them, as the globals of a compiled
+// program cannot be set from outside.
+func (c *compileCache) execStmt(options *syntax.FileOptions, thread *starlark.Thread, filename string, code string,
+	lookup func(name string) (starlark.Value, bool)) (starlark.StringDict, error) {
+
+	key := cacheKey(filename, code)
+	cached := c.stmts[key]
+	if cached == nil {
+		parsed, err := options.Parse(filename, code, 0)
+		if err != nil {
+			return nil, err
+		}
+		cached = &cachedProgram{names: identNames(parsed), variants: make(map[string]*starlark.Program)}
+		c.stmts[key] = cached
+	}
+
+	env, mask := cached.env(lookup, "$")
+	program, err := cached.variant(mask, env, func() (*syntax.File, error) {
+		f, err := options.Parse(filename, code, 0)
+		if err != nil {
+			return nil, err
+		}
+		var pos syntax.Position
+		if len(f.Stmts) > 0 {
+			pos = syntax.Start(f.Stmts[0])
+		}
+		stmts := make([]syntax.Stmt, 0, len(env)+len(f.Stmts))
+		for i, name := range cached.names {
+			if mask[i] == '1' {
+				stmts = append(stmts, &syntax.AssignStmt{OpPos: pos, Op: syntax.EQ,
+					LHS: &syntax.Ident{NamePos: pos, Name: name}, RHS: &syntax.Ident{NamePos: pos, Name: "$" + name}})
+			}
+		}
+		f.Stmts = append(stmts, f.Stmts...)
+		return f, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return program.Init(thread, env)
+}
+
+// env returns a new dict with the values of the defined identifiers, each under its
+// name with the prefix, and the mask of the identifiers that are defined.
+// Each call has its own dict, as the functions created by the program look up the
+// predeclared names in it even after the call.
+func (c *cachedProgram) env(lookup func(name string) (starlark.Value, bool), prefix string) (starlark.StringDict, string) {
+	env := make(starlark.StringDict, len(c.names))
+	mask := make([]byte, len(c.names))
+	for i, name := range c.names {
+		mask[i] = '0'
+		if v, ok := lookup(name); ok {
+			env[prefix+name] = v
+			mask[i] = '1'
+		}
+	}
+	return env, string(mask)
+}
+
+// variant returns the program compiled for the mask of the defined identifiers,
+// compiling the file returned by parse with the names in env predeclared.
+func (c *cachedProgram) variant(mask string, env starlark.StringDict, parse func() (*syntax.File, error)) (*starlark.Program, error) {
+	if program, ok := c.variants[mask]; ok {
+		return program, nil
+	}
+	f, err := parse()
+	if err != nil {
+		return nil, err
+	}
+	program, err := starlark.FileProgram(f, env.Has)
+	if err != nil {
+		return nil, err
+	}
+	c.variants[mask] = program
+	return program, nil
+}
+
+// identNames returns the distinct identifiers in the syntax tree.
+func identNames(node syntax.Node) []string {
+	var names []string
+	seen := make(map[string]bool)
+	syntax.Walk(node, func(n syntax.Node) bool {
+		if id, ok := n.(*syntax.Ident); ok && !seen[id.Name] {
+			seen[id.Name] = true
+			names = append(names, id.Name)
+		}
+		return true
+	})
+	return names
+}
@@ -0,0 +1,419 @@
+package modelchecker
+
+import (
+	"fizz/proto"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// ctmcTolerance is the convergence tolerance of the continuous time analysis.
+	ctmcTolerance = 1e-10
+	// ctmcMaxIterations is the maximum number of iterations of the continuous time analysis.
+	ctmcMaxIterations = 100000
+	// uniformizationFactor scales the maximum exit rate to the rate of the uniformized
+	// chain, so it has a self loop in every state and is aperiodic.
+	uniformizationFactor = 1.02
+)
+
+// ContinuousTimeChain is the continuous time Markov chain of the state graph.
+// The links with a rate take an exponentially distributed time, and the links
+// without a rate take no time. The nodes where all the outbound links have a rate,
+// or that have no outbound links, are timed. The nodes where none of the links have
+// a rate are instantaneous, unless they only have self loops, and are eliminated like
+// the vanishing markings of a stochastic Petri net, so the chain moves between the
+// timed nodes only.
+type ContinuousTimeChain struct {
+	nodes []*Node
+	timed []bool
+	// instantaneous has the probabilities of the links, used to eliminate the
+	// instantaneous nodes, and linkRates has the rates of the links of the timed nodes.
+	instantaneous *SparseMatrix
+	linkRates     [][]float64
+	// rates has the rates between the timed nodes, without the self loops.
+	rates *SparseMatrix
+	// exitRates is the total rate of leaving each node.
+	exitRates []float64
+	// initial is the initial distribution over the timed nodes.
+	initial []float64
+}
+
+// NewContinuousTimeChain builds the continuous time chain with the rates of the
+// performance model, starting at the root node. The probabilities of the instantaneous
+// links are the same as in the discrete time analysis.
+func NewContinuousTimeChain(nodes []*Node, model *proto.PerformanceModel) (*ContinuousTimeChain, error) {
+	n := len(nodes)
+	defaultRate := model.GetContinuousTime().GetDefaultRate()
+
+	timed := make([]bool, n)
+	linkRates := make([][]float64, n)
+	var mixed []*Node
+	for i, node := range nodes {
+		if len(node.Outbound) == 0 {
+			timed[i] = true
+			continue
+		}
+		rates := make([]float64, len(node.Outbound))
+		rated := 0
+		selfLoops := 0
+		for k, link := range node.Outbound {
+			if link.Node == node {
+				selfLoops++
+			}
+			rate, ok := linkRate(link, model)
+			if !ok {
+				rates[k] = -1
+				continue
+			}
+			rates[k] = rate
+			rated++
+		}
+		if rated == 0 {
+			// A node with only self loops never changes, like a node without links.
+			timed[i] = selfLoops == len(node.Outbound)
+			continue
+		}
+		if rated < len(node.Outbound) {
+			if defaultRate <= 0 {
+				mixed = append(mixed, node)
+				continue
+			}
+			for k := range rates {
+				if rates[k] < 0 {
+					rates[k] = defaultRate
+				}
+			}
+		}
+		timed[i] = true
+		linkRates[i] = rates
+	}
+	if len(mixed) > 0 {
+		return nil, statesError(fmt.Sprintf("the links from %d states mix transitions with and without a rate, "+
+			"set the missing rates or the default_rate", len(mixed)), mixed)
+	}
+
+	instantaneous := genTransitionMatrix(nodes, model)
+	reach, err := instantaneousReach(instantaneous, timed)
+	if err != nil {
+		return nil, err
+	}
+	var timeLocked []*Node
+	for i, node := range nodes {
+		if !timed[i] && sum(mapValues(reach[i])) < 1-probabilityTolerance*float64(n) {
+			timeLocked = append(timeLocked, node)
+		}
+	}
+	if len(timeLocked) > 0 {
+		return nil, statesError(fmt.Sprintf("the transitions without a rate from %d states might loop forever "+
+			"without reaching a state with a rate, set the rates of the transitions", len(timeLocked)), timeLocked)
+	}
+
+	chain := &ContinuousTimeChain{
+		nodes:         nodes,
+		timed:         timed,
+		instantaneous: instantaneous,
+		linkRates:     linkRates,
+	}
+	chain.rates, chain.exitRates, chain.initial = chain.eliminate(timed, reach)
+	return chain, nil
+}
+
+// eliminate returns the rates between the target nodes, the exit rates and the
+// initial distribution, with the instantaneous nodes that are not targets replaced by
+// the targets they reach. The targets are the timed nodes, and possibly some of the
+// instantaneous nodes, which then have no outbound rates.
+func (c *ContinuousTimeChain) eliminate(targets []bool, reach []map[int]float64) (*SparseMatrix, []float64, []float64) {
+	n := len(c.nodes)
+	indexMap := make(map[*Node]int)
+	for i, node := range c.nodes {
+		indexMap[node] = i
+	}
+	exitRates := make([]float64, n)
+	initial := make([]float64, n)
+	builder := newSparseBuilder(n)
+	addRate := func(i, j int, rate float64) {
+		// The self loops do not change the state, so they do not count.
+		if i == j || rate == 0 {
+			return
+		}
+		builder.Add(i, j, rate)
+		exitRates[i] += rate
+	}
+	for i, node := range c.nodes {
+		if c.linkRates[i] == nil {
+			continue
+		}
+		for k, link := range node.Outbound {
+			j := indexMap[link.Node]
+			if targets[j] {
+				addRate(i, j, c.linkRates[i][k])
+				continue
+			}
+			for _, t := range sortedKeys(reach[j]) {
+				addRate(i, t, c.linkRates[i][k]*reach[j][t])
+			}
+		}
+	}
+
+	if targets[0] {
+		initial[0] = 1.0
+	} else {
+		for t, p := range reach[0] {
+			initial[t] = p
+		}
+	}
+	return builder.Build(), exitRates, initial
+}
+
+// linkRate returns the rate of the link from the configs of its labels, or if none
+// of them has a rate, from the config of its name.
+func linkRate(link *Link, model *proto.PerformanceModel) (float64, bool) {
+	rate, rated := 0.0, false
+	for _, label := range link.Labels {
+		if r, ok := configRate(model.GetConfigs()[label]); ok {
+			rate += r
+			rated = true
+		}
+	}
+	if rated {
+		return rate, true
+	}
+	return configRate(model.GetConfigs()[link.Name])
+}
+
+func configRate(config *proto.TransitionConfig) (float64, bool) {
+	if config.GetRate() > 0 {
+		return config.GetRate(), true
+	}
+	if config.GetMeanLatency() > 0 {
+		return 1 / config.GetMeanLatency(), true
+	}
+	return 0, false
+}
+
+// statesError returns an error with the message, followed by the states and the
+// names of their outbound links.
+func statesError(message string, nodes []*Node) error {
+	builder := strings.Builder{}
+	builder.WriteString(message)
+	for i, node := range nodes {
+		if i == maxReportedProbabilityRows {
+			builder.WriteString(fmt.Sprintf("\n  ... %d more", len(nodes)-maxReportedProbabilityRows))
+			break
+		}
+		names := make([]string, 0, len(node.Outbound))
+		for _, link := range node.Outbound {
+			if len(link.Labels) > 0 {
+				names = append(names, strings.Join(link.Labels, ","))
+			} else {
+				names = append(names, link.Name)
+			}
+		}
+		builder.WriteString(fmt.Sprintf("\n  state: %s, links: %v", node.Heap.ToJson(), names))
+	}
+	return fmt.Errorf("%s", builder.String())
+}
+
+// instantaneousReach returns the probabilities of reaching each target node from each
+// other node, following the transition probabilities. The targets are the timed nodes,
+// and possibly some of the instantaneous nodes. The probability of looping forever
+// between instantaneous nodes is lost.
+func instantaneousReach(transitionMatrix *SparseMatrix, targets []bool) ([]map[int]float64, error) {
+	n := transitionMatrix.N()
+	reach := make([]map[int]float64, n)
+	for i := range reach {
+		if !targets[i] {
+			reach[i] = make(map[int]float64)
+		}
+	}
+	// The nodes are mostly in the order they are explored, so a sweep from the
+	// last one resolves the acyclic paths at once.
+	for iteration := 0; iteration < ctmcMaxIterations; iteration++ {
+		change := 0.0
+		for i := n - 1; i >= 0; i-- {
+			if targets[i] {
+				continue
+			}
+			next := make(map[int]float64)
+			cols, probs := transitionMatrix.Row(i)
+			for k, j := range cols {
+				if targets[j] {
+					next[j] += probs[k]
+					continue
+				}
+				for t, p := range reach[j] {
+					next[t] += probs[k] * p
+				}
+			}
+			for t, p := range next {
+				change = math.Max(change, math.Abs(p-reach[i][t]))
+			}
+			reach[i] = next
+		}
+		if change <= ctmcTolerance {
+			return reach, nil
+		}
+	}
+	return nil, fmt.Errorf("the probabilities of the instantaneous transitions did not converge in %d iterations",
+		ctmcMaxIterations)
+}
+
+func mapValues(m map[int]float64) []float64 {
+	values := make([]float64, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
+
+func sortedKeys(m map[int]float64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// ExpectedTimeToAbsorption solves the time until the chain reaches an absorbing node,
+// or a node it cannot leave. The expected time is Counters["time"] of the result,
+// and the expected number of timed transitions is ExpectedSteps.
+// The instantaneous nodes that are absorbing are kept in the chain as targets, so
+// passing through one of them absorbs the chain, even if it takes no time.
+func (c *ContinuousTimeChain) ExpectedTimeToAbsorption(absorbing func(node *Node) bool) *AbsorptionResult {
+	n := len(c.nodes)
+	absorbed := make([]bool, n)
+	targets := make([]bool, n)
+	instantTargets := false
+	for i, node := range c.nodes {
+		absorbed[i] = absorbing(node)
+		targets[i] = c.timed[i] || absorbed[i]
+		instantTargets = instantTargets || (!c.timed[i] && absorbed[i])
+	}
+	rates, exitRates, initial := c.rates, c.exitRates, c.initial
+	if instantTargets {
+		reach, err := instantaneousReach(c.instantaneous, targets)
+		// The instantaneous transitions converged to the timed nodes, so they
+		// converge to a superset of them.
+		PanicOnError(err)
+		rates, exitRates, initial = c.eliminate(targets, reach)
+	}
+
+	// The embedded discrete time chain, where each visit to a node takes the
+	// mean holding time of the node.
+	transitions := newSparseBuilder(n)
+	holdingTimes := newSparseBuilder(n)
+	for i := range c.nodes {
+		if !targets[i] || exitRates[i] == 0 || absorbed[i] {
+			transitions.Add(i, i, 1.0)
+			continue
+		}
+		cols, linkRates := rates.Row(i)
+		for k, j := range cols {
+			transitions.Add(i, j, linkRates[k]/exitRates[i])
+			holdingTimes.Add(i, j, 1/exitRates[i])
+		}
+	}
+	counters := map[string]*SparseMatrix{"time": holdingTimes.Build()}
+	return solveAbsorbingChain(transitions.Build(), counters, initial)
+}
+
+// uniformizationRate returns the rate of the uniformized chain.
+func (c *ContinuousTimeChain) uniformizationRate() float64 {
+	maxRate := 0.0
+	for _, rate := range c.exitRates {
+		maxRate = math.Max(maxRate, rate)
+	}
+	if maxRate == 0 {
+		return 1.0
+	}
+	return maxRate * uniformizationFactor
+}
+
+// uniformizedStep returns the distribution after a step of the uniformized chain,
+// I + Q/rate, where Q is the generator. inbound is the transpose of the rates.
+func (c *ContinuousTimeChain) uniformizedStep(inbound *SparseMatrix, rate float64, distribution []float64) []float64 {
+	next := inbound.MulVec(distribution)
+	for j := range next {
+		next[j] = distribution[j] + (next[j]-distribution[j]*c.exitRates[j])/rate
+	}
+	return next
+}
+
+// SteadyState returns the long run fraction of the time spent in each node, and
+// whether it converged.
+func (c *ContinuousTimeChain) SteadyState() ([]float64, bool) {
+	inbound := c.rates.Transpose()
+	rate := c.uniformizationRate()
+	distribution := append([]float64(nil), c.initial...)
+	for i := 0; i < ctmcMaxIterations; i++ {
+		next := c.uniformizedStep(inbound, rate, distribution)
+		difference := vectorNorm(vectorDifference(next, distribution))
+		distribution = next
+		if difference < ctmcTolerance {
+			return distribution, true
+		}
+	}
+	fmt.Printf("Warning: the continuous time steady state did not converge in %d iterations\n", ctmcMaxIterations)
+	return distribution, false
+}
+
+// Transient returns the probability of being in each node at the time t, with the
+// uniformization method. The number of steps of the uniformized chain until t is
+// Poisson distributed, so the distribution at t is the average of the distributions
+// after k steps, weighted by the Poisson probabilities of k.
+func (c *ContinuousTimeChain) Transient(t float64) []float64 {
+	distribution := append([]float64(nil), c.initial...)
+	if t <= 0 {
+		return distribution
+	}
+	inbound := c.rates.Transpose()
+	rate := c.uniformizationRate()
+	mean := rate * t
+	maxSteps := int(mean + 10*math.Sqrt(mean) + 20)
+
+	result := make([]float64, len(distribution))
+	totalWeight := 0.0
+	for k := 0; k <= maxSteps; k++ {
+		logFactorial, _ := math.Lgamma(float64(k + 1))
+		weight := math.Exp(-mean + float64(k)*math.Log(mean) - logFactorial)
+		for j, p := range distribution {
+			result[j] += weight * p
+		}
+		totalWeight += weight
+		if totalWeight >= 1-ctmcTolerance {
+			break
+		}
+		next := c.uniformizedStep(inbound, rate, distribution)
+		if vectorNorm(vectorDifference(next, distribution)) < ctmcTolerance {
+			// The chain is in its steady state, so the remaining steps do not change it.
+			for j, p := range next {
+				result[j] += (1 - totalWeight) * p
+			}
+			break
+		}
+		distribution = next
+	}
+	return result
+}
+
+// PredicateProbability returns the probability of the timed nodes where the starlark
+// predicate holds.
+func (c *ContinuousTimeChain) PredicateProbability(distribution []float64, predicate string) (float64, error) {
+	probability := 0.0
+	for i, p := range distribution {
+		if p == 0 || !c.timed[i] {
+			continue
+		}
+		holds, err := evalPerfPredicate(c.nodes[i], predicate)
+		if err != nil {
+			return 0, fmt.Errorf("predicate %s in state %s: %w", predicate, c.nodes[i].Heap.ToJson(), err)
+		}
+		if holds {
+			probability += p
+		}
+	}
+	return probability, nil
+}
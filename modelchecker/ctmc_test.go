@@ -0,0 +1,178 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"math"
+	"testing"
+)
+
+// A server that fails and gets repaired.
+const failRepairAstJson = `
+{
+  "actions": [
+    {
+      "name": "Init",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "up = True"}}]}
+    },
+    {
+      "name": "Fail",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"ifStmt": {"branches": [{
+        "condition": "up",
+        "block": {"stmts": [{"pyStmt": {"code": "up = False"}}]}
+      }]}}]}
+    },
+    {
+      "name": "Repair",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"ifStmt": {"branches": [{
+        "condition": "not up",
+        "block": {"stmts": [{"pyStmt": {"code": "up = True"}}]}
+      }]}}]}
+    }
+  ]
+}
+`
+
+func startSpec(t *testing.T, astJson string) []*Node {
+	file, err := parseAstFromString(astJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		ContinuePathOnInvariantFailures: true,
+		ContinueOnInvariantFailures:     true,
+		Options:                         &ast.Options{MaxActions: 10, MaxConcurrentActions: 1},
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+	nodes, _, _ := getAllNodes(root)
+	return nodes
+}
+
+func parsePerfModel(t *testing.T, json string) *ast.PerformanceModel {
+	perfModel := &ast.PerformanceModel{}
+	require.Nil(t, protojson.Unmarshal([]byte(json), perfModel))
+	require.Nil(t, ValidatePerformanceModel(perfModel))
+	return perfModel
+}
+
+func TestContinuousTimeChain_Availability(t *testing.T) {
+	nodes := startSpec(t, failRepairAstJson)
+	perfModel := parsePerfModel(t, `{"configs": {
+		"Fail": {"meanLatency": 1},
+		"Repair": {"rate": 9}
+	}}`)
+	chain, err := NewContinuousTimeChain(nodes, perfModel)
+	require.Nil(t, err)
+
+	// The server is up 9/10 of the time.
+	steadyState, converged := chain.SteadyState()
+	assert.True(t, converged)
+	availability, err := chain.PredicateProbability(steadyState, "up")
+	require.Nil(t, err)
+	assert.InDelta(t, 0.9, availability, 1e-6)
+
+	// Starting up, the probability of being up at t is 0.9 + 0.1 e^(-10t).
+	for _, time := range []float64{0, 0.05, 0.1, 0.5, 2} {
+		up, err := chain.PredicateProbability(chain.Transient(time), "up")
+		require.Nil(t, err)
+		assert.InDelta(t, 0.9+0.1*math.Exp(-10*time), up, 1e-8, "time %g", time)
+	}
+
+	// The server never stops failing and getting repaired.
+	result := chain.ExpectedTimeToAbsorption(func(*Node) bool { return false })
+	assert.Nil(t, absorptionTime(result))
+	// The expected time to the first failure.
+	result = chain.ExpectedTimeToAbsorption(func(node *Node) bool { return node.Heap.ToJson() == `{"up":false}` })
+	require.NotNil(t, absorptionTime(result))
+	assert.InDelta(t, 1.0, *absorptionTime(result), 1e-9)
+}
+
+func TestContinuousTimeChain_InstantaneousTransitions(t *testing.T) {
+	nodes := startSpec(t, boundedQueueAstJson)
+	// Enqueue is started at the rate 2, and succeeds with the probability 0.9, so the
+	// queue grows at the rate 1.8 until it is full.
+	perfModel := parsePerfModel(t, `{
+		"configs": {
+			"Enqueue": {"rate": 2},
+			"Enqueue.ok": {"probability": 0.9},
+			"Enqueue.drop": {"probability": 0.1}
+		},
+		"continuousTime": {"predicates": {"full": "queue == 2"}, "transientTimes": [0, 1, 5]}
+	}`)
+	chain, err := NewContinuousTimeChain(nodes, perfModel)
+	require.Nil(t, err)
+
+	result := chain.ExpectedTimeToAbsorption(func(*Node) bool { return false })
+	require.NotNil(t, absorptionTime(result))
+	assert.InDelta(t, 2/1.8, *absorptionTime(result), 1e-9)
+	assert.InDelta(t, 2, result.ExpectedSteps, 1e-9)
+
+	// The state after Enqueue starts is instantaneous, and still absorbs the chain
+	// at the first Enqueue, at the rate 2.
+	result = chain.ExpectedTimeToAbsorption(func(node *Node) bool { return node.Name == "Enqueue" })
+	require.NotNil(t, absorptionTime(result))
+	assert.InDelta(t, 0.5, *absorptionTime(result), 1e-9)
+	assert.InDelta(t, 1, result.ExpectedSteps, 1e-9)
+	assert.InDelta(t, 0, result.NotAbsorbed, 1e-9)
+
+	report, err := analyzeContinuousTime(nodes, nil, perfModel)
+	require.Nil(t, err)
+	assert.True(t, report.Converged)
+	assert.InDelta(t, 1.0, report.Availability["full"], 1e-6)
+	require.Len(t, report.Transient, 3)
+	for _, transient := range report.Transient {
+		// The time to fill the queue has the Erlang distribution with 2 phases.
+		rt := 1.8 * transient.Time
+		assert.InDelta(t, 1-math.Exp(-rt)*(1+rt), transient.Probabilities["full"], 1e-8, "time %g", transient.Time)
+	}
+}
+
+func TestContinuousTimeChain_InvariantTime(t *testing.T) {
+	file, err := parseAstFromString(labeledMarkovChainAstJson)
+	require.Nil(t, err)
+	nodes := startSpec(t, labeledMarkovChainAstJson)
+	// It takes 1 to prepare, and then the chain commits or goes back to work in 1/2.
+	perfModel := parsePerfModel(t, `{"configs": {
+		"Prepare": {"rate": 1},
+		"BackToWork": {"rate": 1},
+		"Commit": {"meanLatency": 1}
+	}, "continuousTime": {}}`)
+
+	report, err := analyzeContinuousTime(nodes, []*ast.File{file}, perfModel)
+	require.Nil(t, err)
+	// StayDone has no rate, but the chain stays in the committed state.
+	require.NotNil(t, report.ExpectedTime)
+	assert.InDelta(t, 3.0, *report.ExpectedTime, 1e-9)
+	require.Len(t, report.Invariants, 1)
+	assert.InDelta(t, 1.0, report.Invariants[0].Probability, 1e-9)
+	require.NotNil(t, report.Invariants[0].ExpectedTime)
+	assert.InDelta(t, 3.0, *report.Invariants[0].ExpectedTime, 1e-9)
+}
+
+func TestNewContinuousTimeChain_MixedRates(t *testing.T) {
+	nodes := startSpec(t, labeledMarkovChainAstJson)
+	configs := `"configs": {"Prepare": {"rate": 1}, "BackToWork": {"rate": 1}}`
+	_, err := NewContinuousTimeChain(nodes, parsePerfModel(t, `{`+configs+`}`))
+	require.NotNil(t, err)
+	assert.Equal(t, "the links from 1 states mix transitions with and without a rate, set the missing rates "+
+		"or the default_rate\n  state: {\"state\":\"prepared\"}, links: [BackToWork Commit.commit]", err.Error())
+
+	// With the default rate, Commit has the rate 1.
+	chain, err := NewContinuousTimeChain(nodes, parsePerfModel(t, `{`+configs+`, "continuousTime": {"defaultRate": 1}}`))
+	require.Nil(t, err)
+	result := chain.ExpectedTimeToAbsorption(func(*Node) bool { return false })
+	require.NotNil(t, absorptionTime(result))
+	assert.InDelta(t, 3.0, *absorptionTime(result), 1e-9)
+}
+
+func TestNewContinuousTimeChain_NoRates(t *testing.T) {
+	nodes := startSpec(t, failRepairAstJson)
+	_, err := NewContinuousTimeChain(nodes, parsePerfModel(t, `{"configs": {}}`))
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "the transitions without a rate from 2 states might loop forever")
+}
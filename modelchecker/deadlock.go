@@ -0,0 +1,146 @@
+package modelchecker
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type DeadlockKind string
+
+const (
+	// DeadlockThreadsBlocked is when there are threads that have not completed,
+	// but none of them can make progress.
+	DeadlockThreadsBlocked DeadlockKind = "all threads blocked"
+	// DeadlockNoActionEnabled is when all the threads completed, none of the actions
+	// are enabled, and the state is not a valid terminal state.
+	DeadlockNoActionEnabled DeadlockKind = "no action enabled"
+	// DeadlockBoundReached is when the exploration was cut off by max_actions. This is
+	// not a deadlock in the model, only reported to show where the exploration stopped.
+	DeadlockBoundReached DeadlockKind = "bound reached"
+)
+
+// Deadlock is a node without any enabled outbound links.
+type Deadlock struct {
+	Kind DeadlockKind
+	Node *Node
+	// DisabledActions are the actions that were tried at this node, but did not
+	// execute any statement. A blocked thread is reported by its action name.
+	DisabledActions []string
+}
+
+// IsFailure returns true unless the deadlock is caused only by the exploration bound.
+func (d *Deadlock) IsFailure() bool {
+	return d.Kind != DeadlockBoundReached
+}
+
+// GetDeadlocks returns the nodes without any outbound links, in the BFS order,
+// so the first deadlock of each kind has the shortest trace.
+// The nodes must be the ones returned by GetAllNodes. Nodes that failed an invariant are
+// skipped as they are not explored further, and so are the valid terminal states,
+// that is, the states with no threads where the valid_terminal_states expression is true.
+// If the exploration stopped early, at a liveness or an invariant failure, the links
+// to the nodes still in the queue are missing, so no deadlock is returned.
+func (p *Processor) GetDeadlocks(nodes []*Node) []*Deadlock {
+	deadlocks := make([]*Deadlock, 0)
+	if !p.explored {
+		return deadlocks
+	}
+	for _, node := range nodes {
+		if len(node.Outbound) != 0 || node.Process.HasFailedInvariants() {
+			continue
+		}
+		deadlock := &Deadlock{Node: node, DisabledActions: disabledActionNames(node)}
+		if node.boundary {
+			deadlock.Kind = DeadlockBoundReached
+		} else if len(node.Threads) > 0 {
+			deadlock.Kind = DeadlockThreadsBlocked
+		} else if p.isValidTerminalState(node.Process) {
+			continue
+		} else {
+			deadlock.Kind = DeadlockNoActionEnabled
+		}
+		deadlocks = append(deadlocks, deadlock)
+	}
+	return deadlocks
+}
+
+// GetDeadlockFailures returns a failure for each distinct deadlock, except the ones
+// caused only by the exploration bound. Deadlocks of the same kind with the same
+// disabled actions are the same deadlock, and only the one with the shortest trace
+// is returned.
+func (p *Processor) GetDeadlockFailures(nodes []*Node) []*Failure {
+	failures := make([]*Failure, 0)
+	indexes := make(map[string]int)
+	for _, deadlock := range p.GetDeadlocks(nodes) {
+		if !deadlock.IsFailure() {
+			continue
+		}
+		key := fmt.Sprintf("%s:%v", deadlock.Kind, sortedStrings(deadlock.DisabledActions))
+		if i, ok := indexes[key]; ok {
+			if p.traceLength(deadlock.Node) < p.traceLength(failures[i].Node) {
+				failures[i].Deadlock = deadlock
+				failures[i].Node = deadlock.Node
+			}
+			continue
+		}
+		indexes[key] = len(failures)
+		failures = append(failures, &Failure{
+			Name:     fmt.Sprintf("deadlock (%s)", deadlock.Kind),
+			Deadlock: deadlock,
+			Node:     deadlock.Node,
+		})
+	}
+	return failures
+}
+
+// disabledActionNames maps the disabled links of the node to action names.
+// Crashes are not actions, and a thread-N link is named after the action
+// the thread is running.
+func disabledActionNames(node *Node) []string {
+	names := make([]string, 0, len(node.disabledActions))
+	for _, name := range node.disabledActions {
+		if name == "crash" {
+			continue
+		}
+		if index, found := strings.CutPrefix(name, "thread-"); found {
+			i, err := strconv.Atoi(index)
+			if err != nil || i >= len(node.Threads) {
+				continue
+			}
+			name = node.Threads[i].actionName()
+		}
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// traceLength returns the number of links on the path from the root to the node.
+func (p *Processor) traceLength(node *Node) int {
+	length := 0
+	for node != p.Init && len(node.Inbound) > 0 {
+		node = node.Inbound[0].Node
+		length++
+	}
+	return length
+}
+
+func sortedStrings(values []string) []string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return sorted
+}
+
+func (p *Processor) isValidTerminalState(process *Process) bool {
+	expr := p.config.GetValidTerminalStates()
+	if expr == "" {
+		return false
+	}
+	vars := invariantVars(process)
+	value, err := process.Evaluator.EvalPyExpr("filename.fizz", expr, vars)
+	process.PanicOnError(fmt.Sprintf("Error evaluating valid_terminal_states: %s", expr), err)
+	return bool(value.Truth())
+}
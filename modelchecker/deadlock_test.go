@@ -0,0 +1,265 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestProcessor_GetDeadlocks(t *testing.T) {
+	tests := []struct {
+		name                string
+		astJson             string
+		validTerminalStates string
+		maxActions          int64
+		kinds               []DeadlockKind
+		disabledActions     []string
+	}{
+		{
+			name:            "noActionEnabled",
+			astJson:         finishOnceAstJson,
+			maxActions:      5,
+			kinds:           []DeadlockKind{DeadlockNoActionEnabled},
+			disabledActions: []string{"Finish"},
+		},
+		{
+			name:                "validTerminalState",
+			astJson:             finishOnceAstJson,
+			validTerminalStates: "status == 'done'",
+			maxActions:          5,
+			kinds:               []DeadlockKind{},
+		},
+		{
+			name:            "threadsBlocked",
+			astJson:         blockedThreadAstJson,
+			maxActions:      2,
+			kinds:           []DeadlockKind{DeadlockThreadsBlocked},
+			disabledActions: []string{"Block"},
+		},
+		{
+			name:       "boundReached",
+			astJson:    blockedThreadAstJson,
+			maxActions: 1,
+			kinds:      []DeadlockKind{DeadlockBoundReached},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := parseAstFromString(test.astJson)
+			require.Nil(t, err)
+			p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+				Options: &ast.Options{
+					MaxActions:           test.maxActions,
+					MaxConcurrentActions: 1,
+				},
+				DeadlockDetection:   true,
+				ValidTerminalStates: test.validTerminalStates,
+			})
+			require.Nil(t, err)
+			root, _, err := p1.Start()
+			require.Nil(t, err)
+
+			nodes, _, _ := GetAllNodes(root)
+			deadlocks := p1.GetDeadlocks(nodes)
+			kinds := make([]DeadlockKind, 0)
+			for _, deadlock := range deadlocks {
+				kinds = append(kinds, deadlock.Kind)
+			}
+			assert.Equal(t, test.kinds, kinds)
+			if len(test.disabledActions) > 0 {
+				assert.Equal(t, test.disabledActions, deadlocks[0].DisabledActions)
+			}
+		})
+	}
+}
+
+func TestProcessor_GetDeadlockFailures(t *testing.T) {
+	file, err := parseAstFromString(stopAnywhereAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           5,
+			MaxConcurrentActions: 1,
+		},
+		DeadlockDetection: true,
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+
+	nodes, _, _ := GetAllNodes(root)
+	// x = 2, x = 10 and x = 11 are all the same deadlock.
+	require.Len(t, p1.GetDeadlocks(nodes), 3)
+	failures := p1.GetDeadlockFailures(nodes)
+	require.Len(t, failures, 1)
+	assert.Equal(t, DeadlockNoActionEnabled, failures[0].Deadlock.Kind)
+	assert.Equal(t, "10", failures[0].Node.Heap.globals["x"].String())
+}
+
+const finishOnceAstJson = `
+{
+  "states": {
+    "code": "status = 'init'"
+  },
+  "actions": [
+    {
+      "name": "Finish",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {
+            "ifStmt": {
+              "flow": "FLOW_ATOMIC",
+              "branches": [
+                {
+                  "condition": "status == 'init'",
+                  "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "status = 'done'"}}]}
+                }
+              ]
+            }
+          }
+        ]
+      }
+    }
+  ]
+}
+`
+
+const blockedThreadAstJson = `
+{
+  "states": {
+    "code": "a = 0"
+  },
+  "actions": [
+    {
+      "name": "Block",
+      "flow": "FLOW_SERIAL",
+      "block": {
+        "flow": "FLOW_SERIAL",
+        "stmts": [
+          {"pyStmt": {"code": "a = 1"}},
+          {
+            "ifStmt": {
+              "flow": "FLOW_ATOMIC",
+              "branches": [
+                {
+                  "condition": "a == 2",
+                  "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "a = 3"}}]}
+                }
+              ]
+            }
+          }
+        ]
+      }
+    }
+  ]
+}
+`
+
+const stopAnywhereAstJson = `
+{
+  "states": {
+    "code": "x = 0"
+  },
+  "actions": [
+    {
+      "name": "Inc",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {
+            "ifStmt": {
+              "flow": "FLOW_ATOMIC",
+              "branches": [
+                {
+                  "condition": "x < 2",
+                  "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "x = x + 1"}}]}
+                }
+              ]
+            }
+          }
+        ]
+      }
+    },
+    {
+      "name": "Stop",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {
+            "ifStmt": {
+              "flow": "FLOW_ATOMIC",
+              "branches": [
+                {
+                  "condition": "x < 2",
+                  "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "x = x + 10"}}]}
+                }
+              ]
+            }
+          }
+        ]
+      }
+    }
+  ]
+}
+`
+
+// The exploration stops at the first liveness failure, with states still in the queue
+// and their parents missing the links to them, so no deadlock must be reported.
+func TestProcessor_GetDeadlocks_LivenessFailure(t *testing.T) {
+	file, err := parseAstFromString(unfairCounterAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           5000,
+			MaxConcurrentActions: 1,
+		},
+		Liveness:          LivenessOnTheFly,
+		DeadlockDetection: true,
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+
+	failurePath, failedInvariant := p1.GetLivenessFailure()
+	require.NotNil(t, failedInvariant)
+	assert.Len(t, failurePath, 2)
+	nodes, _, _ := GetAllNodes(root)
+	assert.Less(t, len(nodes), 5000)
+	assert.Empty(t, p1.GetDeadlocks(nodes))
+	assert.Empty(t, p1.GetDeadlockFailures(nodes))
+}
+
+const unfairCounterAstJson = `
+{
+  "states": {
+    "code": "x = 0"
+  },
+  "invariants": [
+    {
+      "name": "Negative",
+      "temporalOperators": ["always", "eventually"],
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"returnStmt": {"pyExpr": "x < 0"}}]
+      },
+      "pyCode": "def Negative():\n  return x < 0\n"
+    }
+  ],
+  "actions": [
+    {
+      "name": "Inc",
+      "flow": "FLOW_ATOMIC",
+      "fairness": {"level": "FAIRNESS_LEVEL_UNFAIR"},
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "x = x + 1"}}]
+      }
+    }
+  ]
+}
+`
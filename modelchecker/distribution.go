@@ -0,0 +1,134 @@
+package modelchecker
+
+import (
+	"errors"
+	"fizz/proto"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// counterMean returns the expected value added to the counter on each transition.
+// It is exact for all the distributions, so the expected costs computed with the
+// counter matrices are exact too.
+func counterMean(counter *proto.Counter) float64 {
+	switch d := counter.GetDistribution().(type) {
+	case *proto.Counter_Uniform:
+		return (d.Uniform.GetMin() + d.Uniform.GetMax()) / 2
+	case *proto.Counter_Normal:
+		return d.Normal.GetMean()
+	case *proto.Counter_Exponential:
+		return 1 / d.Exponential.GetRate()
+	case *proto.Counter_LogNormal:
+		sigma := d.LogNormal.GetSigma()
+		return math.Exp(d.LogNormal.GetMu() + sigma*sigma/2)
+	case *proto.Counter_Empirical:
+		totalWeight, sum := 0.0, 0.0
+		for _, bucket := range d.Empirical.GetBuckets() {
+			totalWeight += bucket.GetWeight()
+			sum += bucket.GetWeight() * bucket.GetValue()
+		}
+		return sum / totalWeight
+	default:
+		return counter.GetNumeric()
+	}
+}
+
+// sampleCounter returns a random value added to the counter on a transition.
+func sampleCounter(counter *proto.Counter, rng *rand.Rand) float64 {
+	switch d := counter.GetDistribution().(type) {
+	case *proto.Counter_Uniform:
+		return d.Uniform.GetMin() + rng.Float64()*(d.Uniform.GetMax()-d.Uniform.GetMin())
+	case *proto.Counter_Normal:
+		return d.Normal.GetMean() + rng.NormFloat64()*d.Normal.GetStddev()
+	case *proto.Counter_Exponential:
+		return rng.ExpFloat64() / d.Exponential.GetRate()
+	case *proto.Counter_LogNormal:
+		return math.Exp(d.LogNormal.GetMu() + rng.NormFloat64()*d.LogNormal.GetSigma())
+	case *proto.Counter_Empirical:
+		buckets := d.Empirical.GetBuckets()
+		totalWeight := 0.0
+		for _, bucket := range buckets {
+			totalWeight += bucket.GetWeight()
+		}
+		r := rng.Float64() * totalWeight
+		for _, bucket := range buckets {
+			r -= bucket.GetWeight()
+			if r < 0 {
+				return bucket.GetValue()
+			}
+		}
+		return buckets[len(buckets)-1].GetValue()
+	default:
+		return counter.GetNumeric()
+	}
+}
+
+// ValidatePerformanceModel checks the parameters of the counter distributions, the
+// rates and the continuous time options are valid.
+func ValidatePerformanceModel(model *proto.PerformanceModel) error {
+	var errs []error
+	for label, config := range model.GetConfigs() {
+		if config.GetRate() < 0 || config.GetMeanLatency() < 0 {
+			errs = append(errs, fmt.Errorf("%s: rate and mean_latency cannot be negative", label))
+		}
+		if config.GetRate() > 0 && config.GetMeanLatency() > 0 {
+			errs = append(errs, fmt.Errorf("%s: only one of rate and mean_latency can be set", label))
+		}
+		for name, counter := range config.GetCounters() {
+			if err := validateCounter(counter); err != nil {
+				errs = append(errs, fmt.Errorf("counter %s of %s: %w", name, label, err))
+			}
+		}
+	}
+	if model.GetContinuousTime().GetDefaultRate() < 0 {
+		errs = append(errs, errors.New("continuous_time: default_rate cannot be negative"))
+	}
+	for _, t := range model.GetContinuousTime().GetTransientTimes() {
+		if t < 0 {
+			errs = append(errs, fmt.Errorf("continuous_time: transient time %g cannot be negative", t))
+		}
+	}
+	// Sort for a deterministic message, as the configs are a map.
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+	return errors.Join(errs...)
+}
+
+func validateCounter(counter *proto.Counter) error {
+	if counter.GetNumericExpr() != "" && counter.GetDistribution() != nil {
+		return errors.New("numeric_expr cannot be used with a distribution")
+	}
+	switch d := counter.GetDistribution().(type) {
+	case *proto.Counter_Uniform:
+		if d.Uniform.GetMin() > d.Uniform.GetMax() {
+			return fmt.Errorf("uniform min %g is greater than max %g", d.Uniform.GetMin(), d.Uniform.GetMax())
+		}
+	case *proto.Counter_Normal:
+		if d.Normal.GetStddev() < 0 {
+			return fmt.Errorf("normal stddev %g is negative", d.Normal.GetStddev())
+		}
+	case *proto.Counter_Exponential:
+		if d.Exponential.GetRate() <= 0 {
+			return fmt.Errorf("exponential rate %g must be positive", d.Exponential.GetRate())
+		}
+	case *proto.Counter_LogNormal:
+		if d.LogNormal.GetSigma() < 0 {
+			return fmt.Errorf("log normal sigma %g is negative", d.LogNormal.GetSigma())
+		}
+	case *proto.Counter_Empirical:
+		totalWeight := 0.0
+		for _, bucket := range d.Empirical.GetBuckets() {
+			if bucket.GetWeight() < 0 {
+				return fmt.Errorf("empirical bucket %g has a negative weight %g", bucket.GetValue(), bucket.GetWeight())
+			}
+			totalWeight += bucket.GetWeight()
+		}
+		if totalWeight <= 0 {
+			return errors.New("empirical distribution has no buckets with a positive weight")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCounterMean(t *testing.T) {
+	tests := []struct {
+		name    string
+		counter string
+		mean    float64
+	}{
+		{name: "numeric", counter: `{"numeric": 3}`, mean: 3},
+		{name: "uniform", counter: `{"uniform": {"min": 2, "max": 4}}`, mean: 3},
+		{name: "normal", counter: `{"normal": {"mean": 10, "stddev": 2}}`, mean: 10},
+		{name: "exponential", counter: `{"exponential": {"rate": 0.5}}`, mean: 2},
+		{name: "log normal", counter: `{"logNormal": {"mu": 0, "sigma": 0.5}}`, mean: math.Exp(0.125)},
+		{name: "empirical", counter: `{"empirical": {"buckets": [{"value": 1, "weight": 3}, {"value": 5, "weight": 1}]}}`, mean: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := &ast.Counter{}
+			require.Nil(t, protojson.Unmarshal([]byte(test.counter), counter))
+			assert.InDelta(t, test.mean, counterMean(counter), 1e-12)
+			require.Nil(t, validateCounter(counter))
+
+			rng := rand.New(rand.NewSource(1))
+			samples := make([]float64, 100000)
+			for i := range samples {
+				samples[i] = sampleCounter(counter, rng)
+			}
+			assert.InDelta(t, test.mean, sum(samples)/float64(len(samples)), 0.02*math.Max(1, test.mean))
+		})
+	}
+}
+
+func TestValidatePerformanceModel(t *testing.T) {
+	model := &ast.PerformanceModel{}
+	require.Nil(t, protojson.Unmarshal([]byte(`{"configs": {
+		"Send.send": {"counters": {
+			"latency": {"exponential": {"rate": 0}},
+			"bytes": {"uniform": {"min": 10, "max": 1}}
+		}},
+		"Recv.recv": {"counters": {
+			"latency": {"empirical": {"buckets": []}},
+			"cost": {"numeric": 1}
+		}}
+	}}`), model))
+	err := ValidatePerformanceModel(model)
+	require.NotNil(t, err)
+	assert.Equal(t, "counter bytes of Send.send: uniform min 10 is greater than max 1\n"+
+		"counter latency of Recv.recv: empirical distribution has no buckets with a positive weight\n"+
+		"counter latency of Send.send: exponential rate 0 must be positive", err.Error())
+}
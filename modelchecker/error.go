@@ -33,9 +33,15 @@ func (e *ModelError) SprintStackTrace() string {
         return builder.String()
     }
     thread := e.Process.currentThread()
-    frames := thread.Stack.RawArrayCopy()
+    frames := thread.Stack.Values()
     for i := len(frames) - 1; i >= 0; i-- {
-        builder.WriteString(fmt.Sprintf("     %s\n", frames[i].pc))
+        builder.WriteString(fmt.Sprintf("     %s\n", frames[i].pcPath()))
+        if frames[i].scope != nil {
+            locals := frames[i].scope.GetAllVisibleVariables()
+            if len(locals) > 0 {
+                builder.WriteString(fmt.Sprintf("         locals: %s\n", StringDictToJsonString(locals)))
+            }
+        }
     }
     return builder.String()
 }
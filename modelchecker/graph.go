@@ -219,8 +219,13 @@ func GenerateDotFile(node *Node, visited map[*Node]bool) string {
 		if n.Process != nil && len(n.Threads) == 0 {
 			penwidth = 2
 		}
+		style := "solid"
+		if n.boundary {
+			// The exploration was cut off at this node.
+			style = "dashed"
+		}
 		stateString := re.ReplaceAllString(n.String(), "\\")
-		dotGraph += fmt.Sprintf("  %s [label=\"%s\", color=\"%s\" penwidth=\"%d\" ];\n", nodeID, stateString, color, penwidth)
+		dotGraph += fmt.Sprintf("  %s [label=\"%s\", color=\"%s\" penwidth=\"%d\" style=\"%s\" ];\n", nodeID, stateString, color, penwidth, style)
 
 		// Recursively visit Outbound nodes
 		for _, child := range n.Outbound {
@@ -234,6 +239,7 @@ func GenerateDotFile(node *Node, visited map[*Node]bool) string {
 				label += "[" + strings.Join(child.Labels, ", ") + "]"
 
 			}
+			label += logsLabel(child.Logs)
 			edgewidth := 1
 			edgecolor := "black"
 			if child.Fairness != proto.FairnessLevel_FAIRNESS_LEVEL_UNKNOWN &&
@@ -308,7 +314,7 @@ func GenerateFailurePath(nodes []*Link, invariant *InvariantPosition) string {
 		}
 
 		if parentID != "" {
-			label := link.Name
+			label := link.Name + logsLabel(link.Logs)
 			builder.WriteString(fmt.Sprintf("  %s -> %s [label=\"%s\"];\n", parentID, nodeID, label))
 		}
 		parentID = nodeID
@@ -320,6 +326,17 @@ func GenerateFailurePath(nodes []*Link, invariant *InvariantPosition) string {
 	return builder.String()
 }
 
+// logsLabel returns the lines printed in the transition, to show below the link name
+// in the dot label.
+func logsLabel(logs []string) string {
+	label := ""
+	for _, line := range logs {
+		line = strings.ReplaceAll(line, `\`, `\\`)
+		label += `\n` + strings.ReplaceAll(line, `"`, `\"`)
+	}
+	return label
+}
+
 func ReverseLink(node *Node, link *Link) *Link {
 	// Shallow copy link.
 	// change the link.Node to node
@@ -0,0 +1,88 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"fmt"
+	"go.starlark.net/starlark"
+)
+
+// LoadHelpers compiles the helper functions defined at the top level of the file, and
+// makes them visible to all the statements and expressions evaluated afterwards.
+// The helpers are compiled once, in a module of their own, so they cannot see the
+// state variables. The arguments are frozen on each call, so a helper cannot modify
+// the state passed to it either. Neither can the rest of the statement that called
+// it, the next statement gets a fresh copy of the state.
+func (e *Evaluator) LoadHelpers(filename string, helpers []*ast.PyFunction) error {
+	if len(helpers) == 0 {
+		return nil
+	}
+	code := ""
+	for _, helper := range helpers {
+		code += helper.Code + "\n"
+	}
+	globals, err := starlark.ExecFileOptions(e.options, e.thread, filename, code, e.builtins)
+	if err != nil {
+		return err
+	}
+	globals.Freeze()
+	if e.helpers == nil {
+		e.helpers = starlark.StringDict{}
+	}
+	for _, helper := range helpers {
+		fn, ok := globals[helper.Name].(*starlark.Function)
+		if !ok {
+			return fmt.Errorf("helper %s is not a function", helper.Name)
+		}
+		if _, found := e.helpers[helper.Name]; found {
+			return fmt.Errorf("helper %s is defined more than once", helper.Name)
+		}
+		e.helpers[helper.Name] = starlark.NewBuiltin(helper.Name, pureCall(fn))
+	}
+	return nil
+}
+
+// pureCall returns a builtin that calls the function with the arguments frozen.
+// Unlike a copy, freezing works for any value, including the functions and lambdas.
+func pureCall(fn *starlark.Function) func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+	return func(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		args.Freeze()
+		for _, kwarg := range kwargs {
+			kwarg.Freeze()
+		}
+		return starlark.Call(thread, fn, args, kwargs)
+	}
+}
+
+// predeclared returns the helper or, if there is none, the builtin with the name.
+func (e *Evaluator) predeclared(name string) (starlark.Value, bool) {
+	if v, ok := e.helpers[name]; ok {
+		return v, true
+	}
+	v, ok := e.builtins[name]
+	return v, ok
+}
+
+// addHelpers adds the helpers and the builtins not shadowed by a variable, and returns
+// their names.
+func (e *Evaluator) addHelpers(vars starlark.StringDict) []string {
+	var added []string
+	for _, names := range []starlark.StringDict{e.helpers, e.builtins} {
+		for name := range names {
+			if _, found := vars[name]; !found {
+				vars[name], _ = e.predeclared(name)
+				added = append(added, name)
+			}
+		}
+	}
+	return added
+}
+
+// removeHelpers removes the helpers added by addHelpers, so they do not become part of
+// the state. A name the statement assigned a new value to is a variable and is kept.
+func (e *Evaluator) removeHelpers(vars starlark.StringDict, added []string) {
+	for _, name := range added {
+		if v, _ := e.predeclared(name); vars[name] == v {
+			delete(vars, name)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"testing"
+)
+
+// The helpers are used in the init, an action and a block invariant. The action
+// also passes a lambda to a helper.
+const helpersAstJson = `
+{
+  "states": {
+    "code": "nodes = ['a', 'b', 'c']\nvotes = set()\nquorum = has_quorum(votes, nodes)"
+  },
+  "helpers": [
+    {"name": "has_quorum", "code": "def has_quorum(votes, nodes):\n  return 2 * len(votes) > len(nodes)\n"},
+    {"name": "count_if", "code": "def count_if(values, pred):\n  return len([v for v in values if pred(v)])\n"}
+  ],
+  "invariants": [
+    {
+      "name": "QuorumIsCorrect",
+      "temporalOperators": ["always"],
+      "block": {},
+      "pyCode": "def QuorumIsCorrect():\n  return quorum == has_quorum(votes, nodes)\n"
+    }
+  ],
+  "actions": [
+    {
+      "name": "Vote",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {"anyStmt": {"loopVars": ["n"], "pyExpr": "nodes", "block": {"flow": "FLOW_ATOMIC", "stmts": [
+            {"pyStmt": {"code": "votes.add(n)\ncount = count_if(nodes, lambda v: v in votes)\nquorum = has_quorum(votes, nodes)"}}
+          ]}}}
+        ]
+      }
+    }
+  ]
+}
+`
+
+func TestProcessor_Helpers(t *testing.T) {
+	file, err := parseAstFromString(helpersAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           3,
+			MaxConcurrentActions: 1,
+		},
+	})
+	require.Nil(t, err)
+	_, failedNode, err := p1.Start()
+	require.Nil(t, err)
+	assert.Nil(t, failedNode)
+	nodes, _, _ := GetAllNodes(p1.Init)
+	quorum := false
+	for _, node := range nodes {
+		// The helpers are not part of the state.
+		assert.NotContains(t, node.Heap.globals, "has_quorum")
+		assert.NotContains(t, node.Heap.globals, "count_if")
+		quorum = quorum || node.Heap.globals["quorum"] == starlark.True
+	}
+	assert.True(t, quorum)
+}
+
+func TestEvaluator_LoadHelpers(t *testing.T) {
+	t.Run("argumentsFrozen", func(t *testing.T) {
+		mc := NewModelChecker("test")
+		err := mc.LoadHelpers("test.fizz", []*ast.PyFunction{
+			{Name: "pop", Code: "def pop(l):\n  return l.pop()\n"},
+			{Name: "last", Code: "def last(l):\n  return l[-1]\n"},
+		})
+		require.Nil(t, err)
+		vars := starlark.StringDict{"l": evalValue(t, "[1, 2]")}
+		_, err = mc.ExecPyStmt("test.fizz", &ast.PyStmt{Code: "x = pop(l)"}, vars)
+		assert.NotNil(t, err)
+		assert.Equal(t, "[1, 2]", vars["l"].String())
+		vars = starlark.StringDict{"l": evalValue(t, "[1, 2]")}
+		_, err = mc.ExecPyStmt("test.fizz", &ast.PyStmt{Code: "x = last(l)"}, vars)
+		require.Nil(t, err)
+		assert.Equal(t, "2", vars["x"].String())
+		assert.NotContains(t, vars, "pop")
+	})
+	t.Run("lambdaArgument", func(t *testing.T) {
+		mc := NewModelChecker("test")
+		err := mc.LoadHelpers("test.fizz", []*ast.PyFunction{
+			{Name: "apply", Code: "def apply(f, x):\n  return f(x)\n"},
+		})
+		require.Nil(t, err)
+		value, err := mc.EvalPyExpr("test.fizz", "apply(lambda v: v + y, 1)", starlark.StringDict{"y": starlark.MakeInt(2)})
+		require.Nil(t, err)
+		assert.Equal(t, "3", value.String())
+	})
+	t.Run("noStateAccess", func(t *testing.T) {
+		mc := NewModelChecker("test")
+		err := mc.LoadHelpers("test.fizz", []*ast.PyFunction{
+			{Name: "get", Code: "def get():\n  return x\n"},
+		})
+		assert.NotNil(t, err)
+	})
+	t.Run("notAFunction", func(t *testing.T) {
+		mc := NewModelChecker("test")
+		err := mc.LoadHelpers("test.fizz", []*ast.PyFunction{
+			{Name: "f", Code: "f = 1\n"},
+		})
+		assert.NotNil(t, err)
+	})
+	t.Run("shadowed", func(t *testing.T) {
+		mc := NewModelChecker("test")
+		err := mc.LoadHelpers("test.fizz", []*ast.PyFunction{
+			{Name: "f", Code: "def f():\n  return 1\n"},
+		})
+		require.Nil(t, err)
+		value, err := mc.EvalPyExpr("test.fizz", "f", starlark.StringDict{"f": starlark.MakeInt(2)})
+		require.Nil(t, err)
+		assert.Equal(t, "2", value.String())
+	})
+}
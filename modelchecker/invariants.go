@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/jayaprabhakar/fizzbee/lib"
 	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
 	"maps"
 	"slices"
 )
@@ -62,8 +63,7 @@ func CheckInvariant(process *Process, invariant *ast.Invariant) bool {
 	if eventuallyAlways && invariant.Nested != nil {
 		pyExpr = invariant.Nested.PyExpr
 	}
-	vars := CloneDict(process.Heap.globals)
-	vars["__returns__"] = NewDictFromStringDict(process.Returns)
+	vars := invariantVars(process)
 	cond, err := process.Evaluator.EvalPyExpr("filename.fizz", pyExpr, vars)
 	PanicOnError(err)
 	return bool(cond.Truth())
@@ -74,8 +74,7 @@ func CheckAssertion(process *Process, invariant *ast.Invariant) bool {
 		panic("Invariant checking supported only for always/always-eventually/eventually-always invariants")
 	}
 
-	vars := CloneDict(process.Heap.globals)
-	vars["__returns__"] = NewDictFromStringDict(process.Returns)
+	vars := invariantVars(process)
 	pyStmt := &ast.PyStmt{
 		Code: invariant.PyCode + "\n" + "__retval__ = " + invariant.Name + "()\n",
 	}
@@ -84,6 +83,72 @@ func CheckAssertion(process *Process, invariant *ast.Invariant) bool {
 	return bool(vars["__retval__"].Truth())
 }
 
+// invariantVars returns the variables visible to the invariants. In addition to the
+// state variables, __returns__ has the return values of the completed actions and
+// __threads__ has a read-only view of the threads that are still running.
+func invariantVars(process *Process) starlark.StringDict {
+	vars := CloneDict(process.Heap.globals)
+	vars["__returns__"] = NewDictFromStringDict(process.Returns)
+	vars["__threads__"] = NewThreadsTuple(process)
+	return vars
+}
+
+// NewThreadsTuple returns a tuple with a struct for each thread in the process.
+// Each struct has the fields,
+//   - action: the name of the action the thread is executing
+//   - function: the name of the function at the top of the call stack
+//   - pc: the program counter of the next statement to execute
+//   - label: the label of the next statement to execute, or empty string if it has none
+//   - locals: frozen dict of the local variables visible in the current frame
+func NewThreadsTuple(process *Process) starlark.Tuple {
+	threads := make(starlark.Tuple, 0, len(process.Threads))
+	for _, thread := range process.Threads {
+		frame := thread.currentFrame()
+		label := ""
+		if frame.pc != noPc {
+			if stmt, ok := frame.instruction().Message.(*ast.Statement); ok {
+				label = stmt.Label
+			}
+		}
+		locals := starlark.StringDict{}
+		if frame.scope != nil {
+			locals = frame.scope.GetAllVisibleVariables()
+		}
+		localsDict := NewDictFromStringDict(locals)
+		localsDict.Freeze()
+		threads = append(threads, starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"action":   starlark.String(thread.actionName()),
+			"function": starlark.String(frame.Name),
+			"pc":       starlark.String(frame.pcPath()),
+			"label":    starlark.String(label),
+			"locals":   localsDict,
+		}))
+	}
+	threads.Freeze()
+	return threads
+}
+
+// classifyLiveness returns whether the invariant is an eventually always or
+// an always eventually liveness property.
+func classifyLiveness(invariant *ast.Invariant) (eventuallyAlways bool, alwaysEventually bool) {
+	if invariant.Block == nil {
+		if invariant.Always && invariant.Eventually {
+			alwaysEventually = true
+		} else if invariant.Eventually && invariant.GetNested().GetAlways() {
+			eventuallyAlways = true
+		}
+	} else {
+		if slices.Contains(invariant.TemporalOperators, "eventually") &&
+			invariant.TemporalOperators[0] == "eventually" && invariant.TemporalOperators[1] == "always" {
+			eventuallyAlways = true
+		} else if slices.Contains(invariant.TemporalOperators, "eventually") &&
+			invariant.TemporalOperators[0] == "always" && invariant.TemporalOperators[1] == "eventually" {
+			alwaysEventually = true
+		}
+	}
+	return eventuallyAlways, alwaysEventually
+}
+
 func CheckStrictLiveness(node *Node) ([]*Link, *InvariantPosition) {
 	fmt.Println("Checking strict liveness")
 	process := node.Process
@@ -95,23 +160,7 @@ func CheckStrictLiveness(node *Node) ([]*Link, *InvariantPosition) {
 			predicate := func(n *Node) (bool, bool) {
 				return len(n.Process.Threads) == 0, n.Process.Witness[i][j]
 			}
-			eventuallyAlways := false
-			alwaysEventually := false
-			if invariant.Block == nil {
-				if invariant.Always && invariant.Eventually {
-					alwaysEventually = true
-				} else if invariant.Eventually && invariant.GetNested().GetAlways() {
-					eventuallyAlways = true
-				}
-			} else {
-				if slices.Contains(invariant.TemporalOperators, "eventually") &&
-					invariant.TemporalOperators[0] == "eventually" && invariant.TemporalOperators[1] == "always" {
-					eventuallyAlways = true
-				} else if slices.Contains(invariant.TemporalOperators, "eventually") &&
-					invariant.TemporalOperators[0] == "always" && invariant.TemporalOperators[1] == "eventually" {
-					alwaysEventually = true
-				}
-			}
+			eventuallyAlways, alwaysEventually := classifyLiveness(invariant)
 			if eventuallyAlways {
 				fmt.Println("Checking eventually always", invariant.Name)
 				failurePath, isLive := EventuallyAlwaysFinal(node, predicate)
@@ -144,23 +193,7 @@ func CheckFastLiveness(allNodes []*Node) ([]*Link, *InvariantPosition) {
 			predicate := func(n *Node) (bool, bool) {
 				return len(n.Process.Threads) == 0, n.Process.Witness[i][j]
 			}
-			eventuallyAlways := false
-			alwaysEventually := false
-			if invariant.Block == nil {
-				if invariant.Always && invariant.Eventually {
-					alwaysEventually = true
-				} else if invariant.Eventually && invariant.GetNested().GetAlways() {
-					eventuallyAlways = true
-				}
-			} else {
-				if slices.Contains(invariant.TemporalOperators, "eventually") &&
-					invariant.TemporalOperators[0] == "eventually" && invariant.TemporalOperators[1] == "always" {
-					eventuallyAlways = true
-				} else if slices.Contains(invariant.TemporalOperators, "eventually") &&
-					invariant.TemporalOperators[0] == "always" && invariant.TemporalOperators[1] == "eventually" {
-					alwaysEventually = true
-				}
-			}
+			eventuallyAlways, alwaysEventually := classifyLiveness(invariant)
 			if eventuallyAlways {
 				fmt.Println("Checking eventually always", invariant.Name)
 				failurePath, isLive := EventuallyAlwaysFast(allNodes, predicate)
@@ -190,6 +223,11 @@ func AlwaysEventuallyFast(nodes []*Node, predicate Predicate) ([]*Link, bool) {
 	visited := make(map[*Node]bool)
 	queue := lib.NewQueue[*Node]()
 	for _, node := range nodes {
+		if node.boundary {
+			// The exploration was cut off at this node, so assume it can reach a live node.
+			queue.Enqueue(node)
+			continue
+		}
 		if len(node.Outbound) == 0 {
 			fmt.Println("Deadlock detected, at node: ", node.String())
 			panic("Deadlock detected, at node: " + node.String())
@@ -267,6 +305,7 @@ func InitNodeToLink(node *Node) *Link {
 		Node:     node,
 		Name:     "Init",
 		Labels:   node.Labels,
+		Logs:     node.Logs,
 		Fairness: node.Fairness,
 	}
 }
@@ -331,6 +370,10 @@ func EventuallyAlwaysFast(nodes []*Node, predicate Predicate) ([]*Link, bool) {
 	visited := make(map[*Node]bool)
 	queue := lib.NewQueue[*Node]()
 	for _, node := range nodes {
+		if node.boundary {
+			// The exploration was cut off at this node, nothing is known about its future.
+			continue
+		}
 		if len(node.Outbound) == 0 {
 			fmt.Println("Deadlock detected, at node: ", node.String())
 			panic("Deadlock detected, at node: " + node.String())
@@ -451,6 +494,14 @@ func isFairCycle(path []*Link) bool {
 	weakFairLinksInChain := map[string]bool{}
 	weakFairLinksOutOfChain := map[string]bool{}
 
+	for _, link := range path {
+		if link.Node.boundary {
+			// Some outbound links of the node were not explored, so it is not known
+			// whether there is a fair exit from the cycle. Ignore these cycles, instead
+			// of reporting a failure caused only by the exploration bound.
+			return false
+		}
+	}
 	chainLen := len(path)
 	for i, link := range path {
 		node := link.Node
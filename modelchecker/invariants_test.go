@@ -61,5 +61,45 @@ func TestCheckInvariants(t *testing.T) {
 		assert.Len(t, failed[0], 1)
 		assert.Equal(t, 0, failed[0][0])
 	})
+	t.Run("threads", func(t *testing.T) {
+		file0 := &ast.File{
+			Invariants: []*ast.Invariant{
+				&ast.Invariant{Always: true, PyExpr: "len([t for t in __threads__ if t.label == 'critical']) <= 1"},
+				&ast.Invariant{Always: true, PyExpr: "[t.locals['i'] for t in __threads__] == [1, 2]"},
+				&ast.Invariant{
+					Name:              "NoCritical",
+					TemporalOperators: []string{"always"},
+					Block:             &ast.Block{},
+					PyCode:            "def NoCritical():\n  return not any([t.label == 'critical' and t.action == 'Enter' for t in __threads__])\n",
+				},
+			},
+			Actions: []*ast.Action{
+				&ast.Action{
+					Name: "Enter",
+					Block: &ast.Block{
+						Stmts: []*ast.Statement{
+							&ast.Statement{PyStmt: &ast.PyStmt{Code: "x = 1"}},
+							&ast.Statement{Label: "critical", PyStmt: &ast.PyStmt{Code: "x = 0"}},
+						},
+					},
+				},
+			},
+		}
+
+		process := NewProcess("example", []*ast.File{file0}, nil)
+		for i := 1; i <= 2; i++ {
+			thread := process.NewThread()
+			frame := thread.currentFrame()
+			frame.Name = "Enter"
+			jumpTo(thread, "Actions[0].Block.Stmts[1]")
+			frame.scope = &Scope{vars: starlark.StringDict{"i": starlark.MakeInt(i)}}
+		}
+		failed := CheckInvariants(process)
+		assert.Equal(t, []int{0, 2}, failed[0])
+
+		jumpTo(process.Threads[1], "Actions[0].Block.Stmts[0]")
+		failed = CheckInvariants(process)
+		assert.Equal(t, []int{2}, failed[0])
+	})
 
 }
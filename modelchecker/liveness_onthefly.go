@@ -0,0 +1,551 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"fmt"
+	"slices"
+)
+
+// LivenessOnTheFly is the value of the liveness option to check the liveness
+// properties while the state space is being explored.
+const LivenessOnTheFly = "onthefly"
+
+// onTheFlyCheckInterval is the number of visited nodes between two liveness checks.
+// The fair components are searched over all the settled nodes, not only the new ones,
+// so that interval doubles after each search to keep the total work linear.
+const onTheFlyCheckInterval = 1000
+
+type livenessInvariant struct {
+	position         *InvariantPosition
+	name             string
+	eventuallyAlways bool
+	alwaysEventually bool
+}
+
+type cycleCandidate struct {
+	// cycle is the list of links forming the cycle in the same form the strict
+	// liveness checker uses. The first and the last link point to the same node.
+	cycle []*Link
+}
+
+// OnTheFlyLiveness checks for fair non-progress cycles as the graph is built by the
+// Processor. The cycles closed by a link to an ancestor in the BFS tree are checked
+// at every interval, as they can be found by walking the Inbound[0] links without
+// storing any per node history. The other cycles are found by searching the strongly
+// connected components of the settled part of the graph, at doubling intervals and
+// once more when the exploration is complete, so no cycle is missed.
+// A cycle is checked only after all the nodes in it are settled, that is, all their
+// outbound links are known, otherwise an outbound fair link that is not yet explored
+// could make an unfair cycle look fair.
+type OnTheFlyLiveness struct {
+	invariants []*livenessInvariant
+
+	candidates    []*cycleCandidate
+	settledNodes  []*Node
+	lastCheckedAt int
+	// nextSearchAt is the number of visited nodes at which the components are searched next.
+	nextSearchAt int
+
+	FailurePath     []*Link
+	FailedInvariant *InvariantPosition
+}
+
+func NewOnTheFlyLiveness(files []*ast.File) *OnTheFlyLiveness {
+	l := &OnTheFlyLiveness{nextSearchAt: onTheFlyCheckInterval}
+	for i, file := range files {
+		for j, invariant := range file.Invariants {
+			eventuallyAlways, alwaysEventually := classifyLiveness(invariant)
+			if !eventuallyAlways && !alwaysEventually {
+				continue
+			}
+			l.invariants = append(l.invariants, &livenessInvariant{
+				position:         NewInvariantPosition(i, j),
+				name:             invariant.Name,
+				eventuallyAlways: eventuallyAlways,
+				alwaysEventually: alwaysEventually,
+			})
+		}
+	}
+	return l
+}
+
+// ChildAdded must be called when a node is added to the queue.
+func (l *OnTheFlyLiveness) ChildAdded(child *Node) {
+	if len(child.Inbound) > 0 {
+		child.Inbound[0].Node.pendingChildren++
+	}
+}
+
+// NodeDone must be called when a node is processed or dropped from the queue.
+func (l *OnTheFlyLiveness) NodeDone(node *Node) {
+	node.processed = true
+	if node.pendingChildren == 0 && !node.detached {
+		l.settledNodes = append(l.settledNodes, node)
+	}
+	if node.yielded || node.pendingChildren == 0 {
+		l.determined(node)
+	}
+}
+
+// determined is called when the set of statements executed by the node is known,
+// so its parent knows whether the link to this node is enabled.
+func (l *OnTheFlyLiveness) determined(node *Node) {
+	// A node explored again with fewer preemptions is already determined.
+	for len(node.Inbound) > 0 && !node.determined {
+		node.determined = true
+		parent := node.Inbound[0].Node
+		parent.pendingChildren--
+		if parent.pendingChildren != 0 || !parent.processed {
+			return
+		}
+		l.settledNodes = append(l.settledNodes, parent)
+		if parent.yielded {
+			// Already determined when it was processed.
+			return
+		}
+		node = parent
+	}
+}
+
+// DuplicateFound is called when the link from parent to the already visited
+// node other is added to the graph. If other is an ancestor of parent, the link
+// closes a cycle.
+func (l *OnTheFlyLiveness) DuplicateFound(parent *Node, other *Node) {
+	if len(l.invariants) == 0 {
+		return
+	}
+	var closingLink *Link
+	for _, link := range parent.Outbound {
+		if link.Node == other {
+			closingLink = link
+		}
+	}
+	if closingLink == nil {
+		return
+	}
+	// Walk up the BFS tree from parent looking for other.
+	treePath := make([]*Link, 0)
+	node := parent
+	for node != other {
+		if len(node.Inbound) == 0 {
+			// Reached the root, other is not an ancestor
+			return
+		}
+		treePath = append(treePath, ReverseLink(node, node.Inbound[0]))
+		node = node.Inbound[0].Node
+	}
+	slices.Reverse(treePath)
+
+	cycle := make([]*Link, 0, len(treePath)+2)
+	cycle = append(cycle, InitNodeToLink(other))
+	cycle = append(cycle, treePath...)
+	cycle = append(cycle, closingLink)
+	l.candidates = append(l.candidates, &cycleCandidate{cycle: cycle})
+}
+
+// ShouldCheck returns true if enough nodes were visited since the last check.
+func (l *OnTheFlyLiveness) ShouldCheck(visitedCount int) bool {
+	return visitedCount-l.lastCheckedAt >= onTheFlyCheckInterval
+}
+
+// Check evaluates the cycles and the stuttering nodes that are settled, and the fair
+// components reachable from the root if it is time to search them.
+// Returns true if a liveness failure was found.
+func (l *OnTheFlyLiveness) Check(root *Node, visitedCount int) bool {
+	l.lastCheckedAt = visitedCount
+	search := visitedCount >= l.nextSearchAt
+	if search {
+		l.nextSearchAt = 2 * visitedCount
+	}
+	return l.check(root, search)
+}
+
+// Finish runs the last check once the exploration is complete. All the nodes are
+// settled by then, so every fair cycle in the graph is checked.
+func (l *OnTheFlyLiveness) Finish(root *Node) bool {
+	return l.check(root, true)
+}
+
+func (l *OnTheFlyLiveness) check(root *Node, search bool) bool {
+	if l.FailedInvariant != nil || len(l.invariants) == 0 {
+		l.settledNodes = l.settledNodes[:0]
+		return l.FailedInvariant != nil
+	}
+	for _, node := range l.settledNodes {
+		if l.checkStutter(node) {
+			return true
+		}
+	}
+	l.settledNodes = l.settledNodes[:0]
+
+	pending := l.candidates[:0]
+	for _, candidate := range l.candidates {
+		if !allSettled(candidate.cycle) {
+			pending = append(pending, candidate)
+			continue
+		}
+		if l.checkCycle(candidate.cycle) {
+			return true
+		}
+	}
+	clear(l.candidates[len(pending):])
+	l.candidates = pending
+	if search {
+		return l.searchComponents(root)
+	}
+	return false
+}
+
+// searchComponents looks for a fair cycle violating an invariant in the strongly
+// connected components of the settled nodes reachable from the root.
+func (l *OnTheFlyLiveness) searchComponents(root *Node) bool {
+	nodes := settledNodesFrom(root)
+	for _, inv := range l.invariants {
+		in := make(map[*Node]bool, len(nodes))
+		for _, node := range nodes {
+			relevant, value := l.witness(node, inv)
+			// A violation of always eventually is a fair cycle without a live node, and of
+			// eventually always, a fair cycle with a dead node.
+			if inv.eventuallyAlways || !(relevant && value) {
+				in[node] = true
+			}
+		}
+		bad := func(node *Node) bool {
+			relevant, value := l.witness(node, inv)
+			return inv.alwaysEventually || (relevant && !value)
+		}
+		for _, component := range components(nodes, in) {
+			cycle := fairCycle(component, bad)
+			if cycle == nil {
+				continue
+			}
+			path := pathToInit([]*Node{nil}, cycle[0].Node)
+			path = append(path, cycle[1:]...)
+			l.fail(path, inv)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *OnTheFlyLiveness) checkStutter(node *Node) bool {
+	if node.Process == nil || !node.Enabled || node.boundary {
+		return false
+	}
+	for _, link := range node.Outbound {
+		if link.Node.Enabled && (link.Fairness == ast.FairnessLevel_FAIRNESS_LEVEL_STRONG ||
+			link.Fairness == ast.FairnessLevel_FAIRNESS_LEVEL_WEAK) {
+			return false
+		}
+	}
+	for _, inv := range l.invariants {
+		relevant, value := l.witness(node, inv)
+		if (inv.alwaysEventually && !(relevant && value)) ||
+			(inv.eventuallyAlways && relevant && !value) {
+			path := pathToInit([]*Node{nil}, node)
+			path = append(path, &Link{Node: node, Name: "stutter"})
+			l.fail(path, inv)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *OnTheFlyLiveness) checkCycle(cycle []*Link) bool {
+	if !isFairCycle(enabledLinks(cycle)) {
+		return false
+	}
+	for _, inv := range l.invariants {
+		liveNodeFound := false
+		deadNodeFound := false
+		for _, link := range cycle {
+			relevant, value := l.witness(link.Node, inv)
+			liveNodeFound = liveNodeFound || (relevant && value)
+			deadNodeFound = deadNodeFound || (relevant && !value)
+		}
+		if (inv.alwaysEventually && !liveNodeFound) || (inv.eventuallyAlways && deadNodeFound) {
+			path := pathToInit([]*Node{nil}, cycle[0].Node)
+			path = append(path, cycle[1:]...)
+			l.fail(path, inv)
+			return true
+		}
+	}
+	return false
+}
+
+func (l *OnTheFlyLiveness) witness(node *Node, inv *livenessInvariant) (bool, bool) {
+	return len(node.Process.Threads) == 0, node.Process.Witness[inv.position.FileIndex][inv.position.InvariantIndex]
+}
+
+func (l *OnTheFlyLiveness) fail(path []*Link, inv *livenessInvariant) {
+	fmt.Println("Liveness failure found on the fly for invariant", inv.name)
+	l.FailurePath = path
+	l.FailedInvariant = inv.position
+	l.candidates = nil
+}
+
+func allSettled(cycle []*Link) bool {
+	for _, link := range cycle {
+		if !link.Node.processed || link.Node.pendingChildren != 0 || !link.Node.Enabled {
+			return false
+		}
+	}
+	return true
+}
+
+// enabledLinks returns a copy of the cycle whose nodes only have the outbound links
+// to enabled nodes, the same view the strict liveness checker gets after getAllNodes.
+func enabledLinks(cycle []*Link) []*Link {
+	result := make([]*Link, len(cycle))
+	copies := make(map[*Node]*Node)
+	for i, link := range cycle {
+		node, ok := copies[link.Node]
+		if !ok {
+			node = &Node{Process: link.Node.Process, boundary: link.Node.boundary}
+			for _, outLink := range link.Node.Outbound {
+				if outLink.Node.Enabled {
+					node.Outbound = append(node.Outbound, outLink)
+				}
+			}
+			copies[link.Node] = node
+		}
+		result[i] = ReverseLink(node, link)
+	}
+	// isFairCycle compares the outbound link targets with the next node in the cycle,
+	// so the targets within the cycle must point to the copies as well.
+	for _, node := range copies {
+		for i, outLink := range node.Outbound {
+			if c, ok := copies[outLink.Node]; ok {
+				node.Outbound[i] = ReverseLink(c, outLink)
+			}
+		}
+	}
+	return result
+}
+
+// settledNodesFrom returns the nodes reachable from the root through settled nodes
+// that can be part of a cycle, that is, the enabled nodes within the exploration bound
+// whose outbound links all lead to nodes whose enabled state is known.
+func settledNodesFrom(root *Node) []*Node {
+	var result []*Node
+	visited := map[*Node]bool{root: true}
+	queue := []*Node{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		if !isSettled(node) {
+			continue
+		}
+		if node.Enabled && !node.boundary && linksDetermined(node) {
+			result = append(result, node)
+		}
+		for _, link := range node.Outbound {
+			if link.Node.Enabled && !visited[link.Node] {
+				visited[link.Node] = true
+				queue = append(queue, link.Node)
+			}
+		}
+	}
+	return result
+}
+
+func isSettled(node *Node) bool {
+	return node.processed && node.pendingChildren == 0 && !node.detached
+}
+
+// linksDetermined returns true if the targets of the outbound links will not be
+// enabled later, by a statement executed in one of their descendants.
+func linksDetermined(node *Node) bool {
+	for _, link := range node.Outbound {
+		target := link.Node
+		if !target.processed || (!target.yielded && target.pendingChildren != 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// components returns the strongly connected components of the nodes in the set,
+// following the links to the enabled nodes, with at least one link within the component.
+func components(nodes []*Node, in map[*Node]bool) [][]*Node {
+	index := make(map[*Node]int)
+	lowLink := make(map[*Node]int)
+	onStack := make(map[*Node]bool)
+	var stack []*Node
+	var result [][]*Node
+
+	var connect func(node *Node)
+	connect = func(node *Node) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		selfLoop := false
+		for _, link := range node.Outbound {
+			next := link.Node
+			if !in[next] || !next.Enabled {
+				continue
+			}
+			selfLoop = selfLoop || next == node
+			if _, ok := index[next]; !ok {
+				connect(next)
+				lowLink[node] = min(lowLink[node], lowLink[next])
+			} else if onStack[next] {
+				lowLink[node] = min(lowLink[node], index[next])
+			}
+		}
+		if lowLink[node] != index[node] {
+			return
+		}
+		i := len(stack) - 1
+		for stack[i] != node {
+			i--
+		}
+		component := slices.Clone(stack[i:])
+		for _, n := range component {
+			onStack[n] = false
+		}
+		stack = stack[:i]
+		if len(component) > 1 || selfLoop {
+			result = append(result, component)
+		}
+	}
+	for _, node := range nodes {
+		if _, ok := index[node]; !ok && in[node] {
+			connect(node)
+		}
+	}
+	return result
+}
+
+// fairCycle returns a fair cycle through a bad node of the component, or nil if there
+// is none. A cycle through all the nodes and links of a component is the fairest one,
+// as it takes every action taken in the component. It is still unfair if a weakly fair
+// action is enabled at every node but never taken, and no smaller cycle can fix that.
+// If a strongly fair action is enabled at some nodes but never taken, those nodes are
+// left out and the rest of the component is searched again.
+func fairCycle(component []*Node, bad func(*Node) bool) []*Link {
+	in := make(map[*Node]bool, len(component))
+	for _, node := range component {
+		in[node] = true
+	}
+	taken := make(map[string]bool)
+	for _, node := range component {
+		for _, link := range node.Outbound {
+			if in[link.Node] && link.Node.Enabled {
+				taken[link.Name] = true
+			}
+		}
+	}
+	remaining := make([]*Node, 0, len(component))
+	weakCounts := make(map[string]int)
+	for _, node := range component {
+		unfair := false
+		weak := make(map[string]bool)
+		for _, link := range node.Outbound {
+			if !link.Node.Enabled || taken[link.Name] {
+				continue
+			}
+			if link.Fairness == ast.FairnessLevel_FAIRNESS_LEVEL_STRONG {
+				unfair = true
+			} else if link.Fairness == ast.FairnessLevel_FAIRNESS_LEVEL_WEAK {
+				weak[link.Name] = true
+			}
+		}
+		if unfair {
+			delete(in, node)
+			continue
+		}
+		remaining = append(remaining, node)
+		for name := range weak {
+			weakCounts[name]++
+		}
+	}
+	if len(remaining) < len(component) {
+		for _, c := range components(remaining, in) {
+			if cycle := fairCycle(c, bad); cycle != nil {
+				return cycle
+			}
+		}
+		return nil
+	}
+	for _, count := range weakCounts {
+		if count == len(component) {
+			return nil
+		}
+	}
+	for _, node := range component {
+		if bad(node) {
+			return cycleThrough(node, component, in)
+		}
+	}
+	return nil
+}
+
+// cycleThrough returns a cycle starting and ending at the start node that visits every
+// node of the component, and takes a link of every fair action taken in the component.
+// The first link is the start node itself, in the form the strict liveness checker uses.
+func cycleThrough(start *Node, component []*Node, in map[*Node]bool) []*Link {
+	cycle := []*Link{InitNodeToLink(start)}
+	current := start
+	takenNames := make(map[string]bool)
+	for _, node := range component {
+		cycle = append(cycle, shortestPath(current, node, in)...)
+		current = node
+		for _, link := range node.Outbound {
+			if !in[link.Node] || !link.Node.Enabled || takenNames[link.Name] ||
+				(link.Fairness != ast.FairnessLevel_FAIRNESS_LEVEL_STRONG &&
+					link.Fairness != ast.FairnessLevel_FAIRNESS_LEVEL_WEAK) {
+				continue
+			}
+			takenNames[link.Name] = true
+			cycle = append(cycle, shortestPath(current, node, in)...)
+			cycle = append(cycle, link)
+			current = link.Node
+		}
+	}
+	cycle = append(cycle, shortestPath(current, start, in)...)
+	if len(cycle) == 1 {
+		// A single node component with a self loop, and no fair action.
+		for _, link := range start.Outbound {
+			if link.Node == start {
+				return append(cycle, link)
+			}
+		}
+	}
+	return cycle
+}
+
+// shortestPath returns the links of a shortest path from one node to another, within the set.
+func shortestPath(from *Node, to *Node, in map[*Node]bool) []*Link {
+	type step struct {
+		source *Node
+		link   *Link
+	}
+	if from == to {
+		return nil
+	}
+	steps := map[*Node]step{from: {}}
+	queue := []*Node{from}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, link := range node.Outbound {
+			if _, ok := steps[link.Node]; ok || !in[link.Node] || !link.Node.Enabled {
+				continue
+			}
+			steps[link.Node] = step{source: node, link: link}
+			if link.Node != to {
+				queue = append(queue, link.Node)
+				continue
+			}
+			var path []*Link
+			for n := to; n != from; n = steps[n].source {
+				path = append(path, steps[n].link)
+			}
+			slices.Reverse(path)
+			return path
+		}
+	}
+	panic("no path within the component")
+}
@@ -0,0 +1,176 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"testing"
+)
+
+const hourClockAstJson = `
+{
+  "states": {
+    "code": "hour = 1\n"
+  },
+  "invariants": [
+    {
+      "name": "Liveness",
+      "temporalOperators": ["always", "eventually"],
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"returnStmt": {"pyExpr": "%[1]s"}}]
+      },
+      "pyCode": "def Liveness():\n  return %[1]s\n"
+    }
+  ],
+  "actions": [
+    {
+      "name": "Tick",
+      "flow": "FLOW_ATOMIC",
+      "fairness": {"level": "%[2]s"},
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "hour = hour%%12 + 1"}}]
+      }
+    }
+  ]
+}
+`
+
+func TestOnTheFlyLiveness(t *testing.T) {
+	tests := []struct {
+		name       string
+		expr       string
+		fairness   string
+		live       bool
+		pathLength int
+	}{
+		{
+			name:     "fairClockLive",
+			expr:     "hour in [6]",
+			fairness: "FAIRNESS_LEVEL_WEAK",
+			live:     true,
+		},
+		{
+			name:       "fairClockNotLive",
+			expr:       "hour == 13",
+			fairness:   "FAIRNESS_LEVEL_WEAK",
+			live:       false,
+			pathLength: 13,
+		},
+		{
+			name:       "unfairClockStutters",
+			expr:       "hour in [6]",
+			fairness:   "FAIRNESS_LEVEL_UNFAIR",
+			live:       false,
+			pathLength: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := &ast.File{}
+			err := protojson.Unmarshal([]byte(fmt.Sprintf(hourClockAstJson, test.expr, test.fairness)), f)
+			require.Nil(t, err)
+			stateConfig := &ast.StateSpaceOptions{
+				Options: &ast.Options{
+					MaxActions:           100,
+					MaxConcurrentActions: 1,
+				},
+				Liveness: LivenessOnTheFly,
+			}
+			p1, err := NewProcessor([]*ast.File{f}, stateConfig)
+			require.Nil(t, err)
+			root, _, err := p1.Start()
+			require.Nil(t, err)
+			require.NotNil(t, root)
+
+			failurePath, failedInvariant := p1.GetLivenessFailure()
+			if test.live {
+				assert.Nil(t, failedInvariant)
+				assert.Equal(t, 12, p1.GetVisitedNodesCount())
+			} else {
+				require.NotNil(t, failedInvariant)
+				assert.Equal(t, 0, failedInvariant.InvariantIndex)
+				assert.Len(t, failurePath, test.pathLength)
+			}
+
+			// The strict checker must agree on the result.
+			_, strictFailedInvariant := CheckStrictLiveness(root)
+			assert.Equal(t, test.live, strictFailedInvariant == nil)
+		})
+	}
+}
+
+// The states x = 1 and x = 2 form a cycle, but neither is an ancestor of the other in
+// the BFS tree, as both are reached directly from the initial state.
+const crossCycleAstJson = `
+{
+  "states": {
+    "code": "x = 0\n"
+  },
+  "invariants": [
+    {
+      "name": "BackToZero",
+      "temporalOperators": ["always", "eventually"],
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"returnStmt": {"pyExpr": "x == 0"}}]
+      },
+      "pyCode": "def BackToZero():\n  return x == 0\n"
+    }
+  ],
+  "actions": [
+    {
+      "name": "SetOne",
+      "flow": "FLOW_ATOMIC",
+      "fairness": {"level": "FAIRNESS_LEVEL_WEAK"},
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "x = 1"}}]
+      }
+    },
+    {
+      "name": "SetTwo",
+      "flow": "FLOW_ATOMIC",
+      "fairness": {"level": "FAIRNESS_LEVEL_WEAK"},
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "x = 2"}}]
+      }
+    }
+  ]
+}
+`
+
+func TestOnTheFlyLiveness_CycleBetweenSiblings(t *testing.T) {
+	f, err := parseAstFromString(crossCycleAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{f}, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           100,
+			MaxConcurrentActions: 1,
+		},
+		Liveness: LivenessOnTheFly,
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+
+	failurePath, failedInvariant := p1.GetLivenessFailure()
+	require.NotNil(t, failedInvariant)
+	assert.Equal(t, 0, failedInvariant.InvariantIndex)
+	// The path goes to one of the states, then around the cycle through the other one.
+	values := make([]string, 0, len(failurePath))
+	for _, link := range failurePath {
+		values = append(values, link.Node.Heap.globals["x"].String())
+	}
+	assert.Equal(t, "0", values[0])
+	assert.Contains(t, values, "1")
+	assert.Contains(t, values, "2")
+	assert.Equal(t, failurePath[len(failurePath)-1].Node, failurePath[1].Node)
+
+	_, strictFailedInvariant := CheckStrictLiveness(root)
+	assert.NotNil(t, strictFailedInvariant)
+}
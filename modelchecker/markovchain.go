@@ -7,43 +7,6 @@ import (
 	"math"
 )
 
-func matrixVectorProduct(matrix [][]float64, vector []float64) []float64 {
-	result := make([]float64, len(vector))
-
-	for i := range matrix {
-		for j := range matrix[i] {
-			result[i] += matrix[i][j] * vector[j]
-		}
-	}
-	//fmt.Printf("Matrix Vector Product:%v\n", result)
-	return result
-}
-
-func multiplyMatrices(a, b [][]float64) [][]float64 {
-	rows := len(a)
-	cols := len(a[0])
-
-	// Check if matrices have the same dimensions
-	if len(b) != rows || len(b[0]) != cols {
-		panic("Matrices must have the same dimensions for element-wise multiplication")
-	}
-
-	// Initialize the result matrix
-	result := make([][]float64, rows)
-	for i := range result {
-		result[i] = make([]float64, cols)
-	}
-
-	// Perform element-wise multiplication
-	for i := 0; i < rows; i++ {
-		for j := 0; j < cols; j++ {
-			result[i][j] = a[i][j] * b[i][j]
-		}
-	}
-
-	return result
-}
-
 func vectorNorm(vector []float64) float64 {
 	sum := 0.0
 	for _, v := range vector {
@@ -60,17 +23,6 @@ func normalizeVector(vector []float64) {
 		vector[i] /= norm
 	}
 }
-func printMatrix(matrix [][]float64) {
-	fmt.Println("[")
-	for _, row := range matrix {
-		fmt.Print("[")
-		for _, v := range row {
-			fmt.Printf("%f,", v)
-		}
-		fmt.Println("],")
-	}
-	fmt.Println("]")
-}
 
 type Histogram struct {
 	entries []HistogramEntry
@@ -90,6 +42,18 @@ func (h *Histogram) GetMean(counter string) float64 {
 	return h.mean[counter]
 }
 
+// GetPercentile returns the value of the counter when the probability of having
+// terminated first reaches p, or false if the chain never terminates with that
+// probability.
+func (h *Histogram) GetPercentile(counter string, p float64) (float64, bool) {
+	for _, entry := range h.entries {
+		if entry.percentile >= p {
+			return entry.counters[counter], true
+		}
+	}
+	return 0, false
+}
+
 type HistogramEntry struct {
 	percentile float64
 	counters  map[string]float64
@@ -128,25 +92,21 @@ func steadyStateDistribution(root *Node, perfModel *proto.PerformanceModel) ([]f
 	return markovChainAnalysis(nodes, perfModel, transitionMatrix, initialDistribution)
 }
 
-func markovChainAnalysis(nodes []*Node, perfModel *proto.PerformanceModel, transitionMatrix [][]float64, initialDistribution []float64) ([]float64, *Histogram) {
+func markovChainAnalysis(nodes []*Node, perfModel *proto.PerformanceModel, transitionMatrix *SparseMatrix, initialDistribution []float64) ([]float64, *Histogram) {
 	matrices := genCounterMatrices(nodes, perfModel)
 	histogram := newHistogram()
 	//fmt.Printf("\ninitial distribution:\n%v\n", initialDistribution)
 	//fmt.Printf("\nTransition Matrix:\n%v\n", transitionMatrix)
-	transitionMatrix = transpose(transitionMatrix)
-	//printMatrix(transitionMatrix)
-	expectedCounterMatrices := make(map[string][][]float64)
-	//rawCounterMatrices := make(map[string][][]float64)
+	transitionMatrix = transitionMatrix.Transpose()
+	expectedCounterMatrices := make(map[string]*SparseMatrix)
 	mean := make(map[string]float64)
 	rawCounters := make(map[string]float64)
 	for counterName, matrix := range matrices {
-		m := transpose(matrix)
-		expectedCounterMatrices[counterName] = multiplyMatrices(m, transitionMatrix)
-		//rawCounterMatrices[counterName] = m
+		m := matrix.Transpose()
+		expectedCounterMatrices[counterName] = m.Hadamard(transitionMatrix)
 		mean[counterName] = 0.0
 		rawCounters[counterName] = 0.0
 		//fmt.Println(counterName)
-		//printMatrix(expectedCounterMatrices[counterName])
 	}
 
 	// Compute the matrix power (raise the matrix to a sufficiently large power)
@@ -158,24 +118,32 @@ func markovChainAnalysis(nodes []*Node, perfModel *proto.PerformanceModel, trans
 	altCurrentDistribution := make([]float64, len(nodes))
 	copy(altCurrentDistribution, currentDistribution)
 	prevTerminationProbability := 0.0
+	converged := false
+	difference := 0.0
+	// The absorbing states are the ones with a self loop only, and the terminal
+	// states that satisfy the first invariant.
+	absorbing := make([]bool, len(nodes))
+	for j := range absorbing {
+		absorbing[j] = transitionMatrix.Get(j, j) == 1.0 || (nodes[j].Process != nil &&
+			len(nodes[j].Process.Threads) == 0 && len(nodes[j].Process.Witness) > 0 && len(nodes[j].Process.Witness[0]) > 0 &&
+			nodes[j].Process.Witness[0][0])
+	}
 	for i := 0; i < iterations; i++ { // Max iterations to avoid infinite loop
 		terminationProbability := 0.0
 		for counter, counterMatrix := range expectedCounterMatrices {
-			mean[counter] += sum(matrixVectorProduct(counterMatrix, currentDistribution))
-			rawCounters[counter] += sum(matrixVectorProduct(counterMatrix, altCurrentDistribution))
+			mean[counter] += sum(counterMatrix.MulVec(currentDistribution))
+			rawCounters[counter] += sum(counterMatrix.MulVec(altCurrentDistribution))
 		}
 
-		nextDistribution := matrixVectorProduct(transitionMatrix, currentDistribution)
-		altCurrentDistribution = matrixVectorProduct(transitionMatrix, altCurrentDistribution)
+		nextDistribution := transitionMatrix.MulVec(currentDistribution)
+		altCurrentDistribution = transitionMatrix.MulVec(altCurrentDistribution)
 
 		//fmt.Println(i+1, nextDistribution)
 
 
 		totalProb := 0.0
 		for j, _ := range altCurrentDistribution {
-			if transitionMatrix[j][j] == 1.0 || (nodes[j].Process != nil &&
-				len(nodes[j].Process.Threads) == 0 && len(nodes[j].Process.Witness) > 0 && len(nodes[j].Process.Witness[0]) > 0 &&
-				nodes[j].Process.Witness[0][0]) {
+			if absorbing[j] {
 				altCurrentDistribution[j] = 0.0
 				terminationProbability += nextDistribution[j]
 			}
@@ -196,12 +164,17 @@ func markovChainAnalysis(nodes []*Node, perfModel *proto.PerformanceModel, trans
 		}
 		//fmt.Println(i+1, terminationProbability)
 		// Check for convergence (you may define a suitable threshold)
-		if vectorNorm(vectorDifference(nextDistribution, currentDistribution)) < 1e-7 {
+		difference = vectorNorm(vectorDifference(nextDistribution, currentDistribution))
+		if difference < 1e-7 {
+			converged = true
 			break
 		}
 
 		currentDistribution = nextDistribution
 	}
+	if !converged {
+		fmt.Printf("Warning: the distribution did not converge in %d iterations, the last change is %g\n", iterations, difference)
+	}
 	//fmt.Println(mean)
 	//fmt.Println(rawCounters)
 	histogram.mean = mean
@@ -212,16 +185,9 @@ func FindAbsorptionCosts(root *Node, perfModel *proto.PerformanceModel, fileId i
 	// Create the transition matrix
 	nodes, _, yields := getAllNodes(root)
 	//fmt.Println("Yields", yields)
-	yields += 1 // Add the root node
 
 	transitionMatrix := createAbsorptionTransitionMatrix(nodes, fileId, invariantId)
-	//printMatrix(transitionMatrix)
-	initialDistribution := make([]float64, len(nodes))
-	for i, _ := range initialDistribution {
-		if nodes[i].Name == "init" || nodes[i].Name == "yield" {
-			initialDistribution[i] = 1.0 / float64(yields) // Set every node to 1.0/n
-		}
-	}
+	initialDistribution := absorptionInitialDistribution(nodes, yields)
 	steadstate, histogram := markovChainAnalysis(nodes, perfModel, transitionMatrix, initialDistribution)
 	//fmt.Println("liveness ", steadstate)
 	fmt.Println("liveness mean counts", histogram.GetMeanCounts())
@@ -229,47 +195,34 @@ func FindAbsorptionCosts(root *Node, perfModel *proto.PerformanceModel, fileId i
 	return steadstate, histogram
 }
 
-func createAbsorptionTransitionMatrix(nodes []*Node, fileId int, invariantId int) [][]float64 {
-	transitionMatrix := createTransitionMatrix(nodes)
-	//fmt.Printf("\nTransition Matrix:\n%v\n", transitionMatrix)
-
-	//printMatrix(transitionMatrix)
-	for i, matrix := range transitionMatrix {
-		if nodes[i].Process == nil {
-			continue
-		}
-		if nodes[i].Witness[fileId][invariantId] {
-			for j := range matrix {
-				if i == j {
-					matrix[j] = 1.0
-				} else {
-					matrix[j] = 0.0
-				}
-			}
+// absorptionInitialDistribution returns the distribution that starts at the init
+// node or any of the yield nodes with the same probability.
+func absorptionInitialDistribution(nodes []*Node, yields int) []float64 {
+	yields += 1 // Add the root node
+	initialDistribution := make([]float64, len(nodes))
+	for i, _ := range initialDistribution {
+		if nodes[i].Name == "init" || nodes[i].Name == "yield" {
+			initialDistribution[i] = 1.0 / float64(yields) // Set every node to 1.0/n
 		}
 	}
-	//transitionMatrix = transpose(transitionMatrix)
-	//printMatrix(transitionMatrix)
-	//transitionMatrix = normalizeColumns(transitionMatrix)
-	transitionMatrix = normalizeRows(transitionMatrix)
-	return transitionMatrix
+	return initialDistribution
 }
 
+func createAbsorptionTransitionMatrix(nodes []*Node, fileId int, invariantId int) *SparseMatrix {
+	// The nodes where the invariant holds are absorbing, they only have a self loop.
+	absorbing := func(node *Node) bool {
+		return node.Process != nil && node.Witness[fileId][invariantId]
+	}
+	return buildTransitionMatrix(nodes, absorbing).NormalizeRows()
+}
 
 func checkLivenessAndCost(root *Node, perfModel *proto.PerformanceModel, fileId int, invariantId int) ([]float64, *Histogram) {
 	// Create the transition matrix
 	nodes, _, yields := getAllNodes(root)
 	fmt.Println("Yields", yields)
-	yields += 1 // Add the root node
 
 	transitionMatrix := createAbsorptionTransitionMatrix(nodes, fileId, invariantId)
-	//printMatrix(transitionMatrix)
-	initialDistribution := make([]float64, len(nodes))
-	for i, _ := range initialDistribution {
-		if nodes[i].Name == "init" || nodes[i].Name == "yield" {
-			initialDistribution[i] = 1.0 / float64(yields) // Set every node to 1.0/n
-		}
-	}
+	initialDistribution := absorptionInitialDistribution(nodes, yields)
 	steadstate, histogram := markovChainAnalysis(nodes, perfModel, transitionMatrix, initialDistribution)
 	//fmt.Println("liveness ", steadstate)
 	fmt.Println("liveness mean counts", histogram.GetMeanCounts())
@@ -281,29 +234,9 @@ func checkLiveness(root *Node, fileId int, invariantId int) []float64 {
 	// Create the transition matrix
 	nodes, _, _ := getAllNodes(root)
 
-	transitionMatrix := createTransitionMatrix(nodes)
-	//fmt.Printf("\nTransition Matrix:\n%v\n", transitionMatrix)
-
-
-	//printMatrix(transitionMatrix)
-	for i, matrix := range transitionMatrix {
-		if nodes[i].Process == nil {
-			continue
-		}
-		if nodes[i].Witness[fileId][invariantId] {
-			for j := range matrix {
-				if i == j {
-					matrix[j] = 1.0
-				} else {
-					matrix[j] = 0.0
-				}
-			}
-		}
-	}
-	transitionMatrix = transpose(transitionMatrix)
-	//printMatrix(transitionMatrix)
-	transitionMatrix = normalizeColumns(transitionMatrix)
-	//printMatrix(transitionMatrix)
+	// Normalizing the rows and transposing is the same as normalizing the columns
+	// of the transpose.
+	transitionMatrix := createAbsorptionTransitionMatrix(nodes, fileId, invariantId).Transpose()
 
 	// Compute the matrix power (raise the matrix to a sufficiently large power)
 	iterations := 2000
@@ -317,7 +250,7 @@ func checkLiveness(root *Node, fileId int, invariantId int) []float64 {
 	currentDistribution := initialDistribution
 	//fmt.Println(currentDistribution)
 	for i := 0; i < iterations; i++ { // Max iterations to avoid infinite loop
-		nextDistribution := matrixVectorProduct(transitionMatrix, currentDistribution)
+		nextDistribution := transitionMatrix.MulVec(currentDistribution)
 		//fmt.Println(i, nextDistribution)
 		// Check for convergence (you may define a suitable threshold)
 		if vectorNorm(vectorDifference(nextDistribution, currentDistribution)) < 1e-7 {
@@ -329,48 +262,6 @@ func checkLiveness(root *Node, fileId int, invariantId int) []float64 {
 
 	return currentDistribution
 }
-func normalizeRows(matrix [][]float64) [][]float64 {
-
-	// Iterate over each column
-	for _, row := range matrix {
-		// Calculate the sum of values in the column
-		rowSum := 0.0
-		for _, val := range row {
-			rowSum += val
-		}
-
-		// Normalize the values in the column
-		if rowSum != 0 {
-			for columnIndex := range row {
-				row[columnIndex] /= rowSum
-			}
-		}
-	}
-
-	return matrix
-}
-func normalizeColumns(matrix [][]float64) [][]float64 {
-	// Get the number of columns
-	numColumns := len(matrix[0])
-
-	// Iterate over each column
-	for col := 0; col < numColumns; col++ {
-		// Calculate the sum of values in the column
-		columnSum := 0.0
-		for _, row := range matrix {
-			columnSum += row[col]
-		}
-
-		// Normalize the values in the column
-		if columnSum != 0 {
-			for row := range matrix {
-				matrix[row][col] /= columnSum
-			}
-		}
-	}
-
-	return matrix
-}
 
 func sum(distribution []float64) float64 {
 	sum := 0.0
@@ -387,12 +278,15 @@ func vectorDifference(a, b []float64) []float64 {
 	return result
 }
 
-func createTransitionMatrix(nodes []*Node) [][]float64 {
-	n := len(nodes)
-	matrix := make([][]float64, n)
-	for i := range matrix {
-		matrix[i] = make([]float64, n)
-	}
+func createTransitionMatrix(nodes []*Node) *SparseMatrix {
+	return buildTransitionMatrix(nodes, func(*Node) bool { return false })
+}
+
+// buildTransitionMatrix returns the transition matrix where each link is taken with
+// a probability proportional to the weight of its action. The nodes without links,
+// and the absorbing nodes, transition to themselves.
+func buildTransitionMatrix(nodes []*Node, absorbing func(node *Node) bool) *SparseMatrix {
+	matrix := newSparseBuilder(len(nodes))
 
 	indexMap := make(map[*Node]int)
 	for i, node := range nodes {
@@ -400,33 +294,21 @@ func createTransitionMatrix(nodes []*Node) [][]float64 {
 	}
 
 	for _, node := range nodes {
-		if len(node.Outbound) == 0 {
-			matrix[indexMap[node]][indexMap[node]] = 1.0
+		if len(node.Outbound) == 0 || absorbing(node) {
+			matrix.Add(indexMap[node], indexMap[node], 1.0)
+			continue
 		}
-		for _, outboundNode := range node.Outbound {
-			matrix[indexMap[node]][indexMap[outboundNode.Node]] += 1.0 / float64(len(node.Outbound))
+		totalWeight := 0.0
+		for _, outboundLink := range node.Outbound {
+			totalWeight += outboundLink.weight()
 		}
-
-	}
-
-	return matrix
-}
-func transpose(matrix [][]float64) [][]float64 {
-	rows := len(matrix)
-	cols := len(matrix[0])
-
-	result := make([][]float64, cols)
-	for i := range result {
-		result[i] = make([]float64, rows)
-	}
-
-	for i := 0; i < rows; i++ {
-		for j := 0; j < cols; j++ {
-			result[j][i] = matrix[i][j]
+		for _, outboundLink := range node.Outbound {
+			matrix.Add(indexMap[node], indexMap[outboundLink.Node], outboundLink.weight()/totalWeight)
 		}
+
 	}
 
-	return result
+	return matrix.Build()
 }
 
 func GetAllNodes(root *Node) ([]*Node, *Node, int) {
@@ -516,8 +398,16 @@ func traverseBFS(rootNode *Node) ([]*Node, *Node, int) {
 			}
 			enabledLinks = append(enabledLinks, link)
 		}
+		if len(enabledLinks) == 0 {
+			// Remember what was tried at the dead end, as the links are dropped below.
+			for _, link := range node.Outbound {
+				node.addDisabledAction(link.Name)
+			}
+		} else {
+			node.disabledActions = nil
+		}
 		node.Outbound = enabledLinks
-		if len(enabledLinks) == 0 && deadlock == nil {
+		if len(enabledLinks) == 0 && deadlock == nil && !node.boundary {
 			deadlock = node
 		}
 
@@ -143,7 +143,8 @@ func TestSteadyStateDistribution(t *testing.T) {
 					},
 				}
 			}
-			p1 := NewProcessor(files, stateCfg)
+			p1, err := NewProcessor(files, stateCfg)
+			require.Nil(t, err)
 			root, _, _ := p1.Start()
 			//RemoveMergeNodes(root)
 
@@ -2,6 +2,8 @@ package modelchecker
 
 import (
 	"fizz/proto"
+	"fmt"
+	protobuf "github.com/golang/protobuf/proto"
 	"github.com/jayaprabhakar/fizzbee/lib"
 )
 
@@ -22,3 +24,71 @@ func ReadOptionsFromYaml(filename string) (*proto.StateSpaceOptions, error) {
 	}
 	return msg, err
 }
+
+var actionFairnessLevels = map[string]proto.FairnessLevel{
+	"unfair": proto.FairnessLevel_FAIRNESS_LEVEL_UNFAIR,
+	"weak":   proto.FairnessLevel_FAIRNESS_LEVEL_WEAK,
+	"strong": proto.FairnessLevel_FAIRNESS_LEVEL_STRONG,
+}
+
+// ValidateOptions checks the action_options refer to the actions defined in the files,
+// and their values are valid, and that none of the limits is negative.
+func ValidateOptions(files []*proto.File, options *proto.StateSpaceOptions) error {
+	if !schedulers[options.GetScheduler()] {
+		return fmt.Errorf("invalid scheduler %s, must be one of %s, %s or %s", options.GetScheduler(),
+			SchedulerNondeterministic, SchedulerRoundRobin, SchedulerPriority)
+	}
+	if options.GetMaxPreemptions() < 0 {
+		return fmt.Errorf("invalid max_preemptions %d, must not be negative", options.GetMaxPreemptions())
+	}
+	if options.GetOptions().GetMaxActions() < 0 || options.GetOptions().GetMaxConcurrentActions() < 0 {
+		return fmt.Errorf("options: negative limit")
+	}
+	actions := make(map[string]bool)
+	for _, action := range files[0].Actions {
+		actions[action.Name] = true
+	}
+	for name, actionOptions := range options.GetActionOptions() {
+		if !actions[name] {
+			return fmt.Errorf("action_options: unknown action %s", name)
+		}
+		if actionOptions.GetFairness() != "" {
+			if _, ok := actionFairnessLevels[actionOptions.GetFairness()]; !ok {
+				return fmt.Errorf("action_options: invalid fairness %s for action %s, must be one of unfair, weak or strong",
+					actionOptions.GetFairness(), name)
+			}
+		}
+		if actionOptions.GetMaxActions() < 0 || actionOptions.GetMaxConcurrentActions() < 0 {
+			return fmt.Errorf("action_options: negative limit for action %s", name)
+		}
+		if actionOptions.GetWeight() < 0 {
+			return fmt.Errorf("action_options: negative weight for action %s", name)
+		}
+	}
+	return nil
+}
+
+// applyFairnessOptions returns the files with the fairness of the actions overridden
+// by the action_options. The files of the caller are not changed, the first file is
+// copied if any of its actions has a fairness option.
+func applyFairnessOptions(files []*proto.File, options *proto.StateSpaceOptions) []*proto.File {
+	var file *proto.File
+	for i, action := range files[0].Actions {
+		level, ok := actionFairnessLevels[options.GetActionOptions()[action.Name].GetFairness()]
+		if !ok {
+			continue
+		}
+		if file == nil {
+			file = protobuf.Clone(files[0]).(*proto.File)
+		}
+		action = file.Actions[i]
+		if action.Fairness == nil {
+			action.Fairness = &proto.Fairness{}
+		}
+		action.Fairness.Level = level
+	}
+	if file == nil {
+		return files
+	}
+	return append([]*proto.File{file}, files[1:]...)
+}
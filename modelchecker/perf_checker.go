@@ -1,13 +1,24 @@
 package modelchecker
 
-import proto "fizz/proto"
+import (
+    proto "fizz/proto"
+    "fmt"
+    "go.starlark.net/starlark"
+    "math"
+    "sort"
+    "strings"
+)
 
-func genTransitionMatrix(nodes []*Node, model *proto.PerformanceModel) [][]float64 {
-    n := len(nodes)
-    matrix := make([][]float64, n)
-    for i := range matrix {
-        matrix[i] = make([]float64, n)
-    }
+// probabilityTolerance is the maximum difference from 1 of the sum of the
+// probabilities of the outbound links of a state.
+const probabilityTolerance = 1e-9
+
+// maxReportedProbabilityRows is the number of invalid states included in the
+// message of a TransitionProbabilityError.
+const maxReportedProbabilityRows = 10
+
+func genTransitionMatrix(nodes []*Node, model *proto.PerformanceModel) *SparseMatrix {
+    matrix := newSparseBuilder(len(nodes))
 
     indexMap := make(map[*Node]int)
     for i, node := range nodes {
@@ -16,56 +27,184 @@ func genTransitionMatrix(nodes []*Node, model *proto.PerformanceModel) [][]float
 
     for _, node := range nodes {
         if len(node.Outbound) == 0 {
-            matrix[indexMap[node]][indexMap[node]] = 1.0
-        }
-        totalProb := 0.0
-        missingCount := 0
-        linkProbabilities := make(map[*Link]float64)
-        for _, outboundLink := range node.Outbound {
-            if len(outboundLink.Labels) == 0 {
-                missingCount++
-                continue
-            }
-            linkProb := 0.0
-            for _, label := range outboundLink.Labels {
-                linkProb += model.Configs[label].GetProbability()
-            }
-            totalProb += linkProb
-            linkProbabilities[outboundLink] = linkProb
+            matrix.Add(indexMap[node], indexMap[node], 1.0)
         }
-        if totalProb > 1.0 {
-            panic("Total probability for a node cannot exceed 1")
+        linkProbabilities, totalProb, missingWeight, err := labeledLinkProbabilities(node, model)
+        node.PanicOnError("Error evaluating the probability_expr of the performance model", err)
+        if totalProb > 1.0+probabilityTolerance {
+            panic(fmt.Sprintf("Total probability for a node cannot exceed 1, got %g in state %s", totalProb, node.Heap.ToJson()))
         }
         if totalProb == 0 {
-            missingCount = len(node.Outbound)
+            missingWeight = 0.0
+            for _, outboundLink := range node.Outbound {
+                missingWeight += outboundLink.weight()
+            }
         }
+        // The remaining probability is split among the links without explicit
+        // probabilities, in proportion to the weights of their actions.
         missingProb := 0.0
-        if missingCount > 0 {
-            missingProb = (1.0 - totalProb) / float64(missingCount)
+        if missingWeight > 0 {
+            missingProb = (1.0 - totalProb) / missingWeight
         }
         for _, outboundLink := range node.Outbound {
             prob,found := linkProbabilities[outboundLink]
             if found && totalProb > 0 {
-                matrix[indexMap[node]][indexMap[outboundLink.Node]] += prob
+                matrix.Add(indexMap[node], indexMap[outboundLink.Node], prob)
             } else {
-                matrix[indexMap[node]][indexMap[outboundLink.Node]] += missingProb
+                matrix.Add(indexMap[node], indexMap[outboundLink.Node], missingProb * outboundLink.weight())
+            }
+        }
+
+    }
+
+    return matrix.Build()
+}
+
+// labeledLinkProbabilities returns the probabilities of the outbound links of the node
+// with labels, and their total, and the total weight of the links without labels.
+func labeledLinkProbabilities(node *Node, model *proto.PerformanceModel) (map[*Link]float64, float64, float64, error) {
+    totalProb := 0.0
+    missingWeight := 0.0
+    linkProbabilities := make(map[*Link]float64)
+    for _, outboundLink := range node.Outbound {
+        if len(outboundLink.Labels) == 0 {
+            missingWeight += outboundLink.weight()
+            continue
+        }
+        linkProb := 0.0
+        for _, label := range outboundLink.Labels {
+            prob, err := configProbability(node, model.GetConfigs()[label])
+            if err != nil {
+                return nil, 0, 0, fmt.Errorf("label %s: %w", label, err)
             }
+            linkProb += prob
         }
+        totalProb += linkProb
+        linkProbabilities[outboundLink] = linkProb
+    }
+    return linkProbabilities, totalProb, missingWeight, nil
+}
+
+// configProbability returns the probability of the transition config for a link
+// from the node.
+func configProbability(node *Node, config *proto.TransitionConfig) (float64, error) {
+    if config.GetProbabilityExpr() != "" {
+        return evalPerfExpr(node, config.GetProbabilityExpr())
+    }
+    return config.GetProbability(), nil
+}
 
+// counterValue returns the expected value added to the counter on a link from the node.
+func counterValue(node *Node, counter *proto.Counter) (float64, error) {
+    if counter.GetNumericExpr() != "" {
+        return evalPerfExpr(node, counter.GetNumericExpr())
+    }
+    return counterMean(counter), nil
+}
+
+// evalPerfExpr evaluates the expression of the performance model to a number, with
+// the same variables as the invariants in the state of the node.
+func evalPerfExpr(node *Node, expr string) (float64, error) {
+    value, err := node.Evaluator.EvalPyExpr("perf_model.fizz", expr, invariantVars(node.Process))
+    if err != nil {
+        return 0, err
+    }
+    f, ok := starlark.AsFloat(value)
+    if !ok {
+        return 0, fmt.Errorf("%s evaluated to %s, not a number", expr, value.Type())
     }
+    return f, nil
+}
+
+// evalPerfPredicate evaluates the starlark predicate of the performance model in the
+// state of the node.
+func evalPerfPredicate(node *Node, expr string) (bool, error) {
+    value, err := node.Evaluator.EvalPyExpr("perf_model.fizz", expr, invariantVars(node.Process))
+    if err != nil {
+        return false, err
+    }
+    return bool(value.Truth()), nil
+}
+
+// InvalidProbabilityRow is a state where the probabilities of the outbound links
+// given by the performance model do not sum to 1.
+type InvalidProbabilityRow struct {
+    Node *Node
+    // Sum is the total probability of the links with labels in the performance model.
+    Sum float64
+    // Err is the error evaluating the probabilities, if any.
+    Err error
+}
+
+func (r *InvalidProbabilityRow) String() string {
+    if r.Err != nil {
+        return fmt.Sprintf("state: %s, error: %v", r.Node.Heap.ToJson(), r.Err)
+    }
+    return fmt.Sprintf("state: %s, sum: %g", r.Node.Heap.ToJson(), r.Sum)
+}
 
-    return matrix
+// TransitionProbabilityError is returned when the transition probabilities of some
+// states are invalid.
+type TransitionProbabilityError struct {
+    Rows []*InvalidProbabilityRow
 }
 
-func genCounterMatrices(nodes []*Node, model *proto.PerformanceModel) map[string][][]float64 {
-    matrices := make(map[string][][]float64)
+func (e *TransitionProbabilityError) Error() string {
+    builder := strings.Builder{}
+    builder.WriteString(fmt.Sprintf("the transition probabilities of %d states do not sum to 1", len(e.Rows)))
+    for i, row := range e.Rows {
+        if i == maxReportedProbabilityRows {
+            builder.WriteString(fmt.Sprintf("\n  ... %d more", len(e.Rows)-maxReportedProbabilityRows))
+            break
+        }
+        builder.WriteString("\n  ")
+        builder.WriteString(row.String())
+    }
+    return builder.String()
+}
+
+// CheckTransitionProbabilities checks that the probabilities given by the performance
+// model for the outbound links of each state sum to 1, and that the expressions evaluate
+// to numbers. The remaining probability is split among the links without labels, so
+// only the states where all the links have labels must sum to exactly 1.
+func CheckTransitionProbabilities(nodes []*Node, model *proto.PerformanceModel) error {
+    var rows []*InvalidProbabilityRow
+    for _, node := range nodes {
+        if len(node.Outbound) == 0 {
+            continue
+        }
+        linkProbabilities, totalProb, missingWeight, err := labeledLinkProbabilities(node, model)
+        if err != nil {
+            rows = append(rows, &InvalidProbabilityRow{Node: node, Err: err})
+            continue
+        }
+        negative := false
+        for _, prob := range linkProbabilities {
+            negative = negative || prob < 0
+        }
+        // A total of 0 means none of the probabilities are set, so the links are
+        // taken in proportion to the weights of their actions.
+        incomplete := totalProb != 0 && missingWeight == 0 && math.Abs(totalProb-1.0) > probabilityTolerance
+        if negative || totalProb > 1.0+probabilityTolerance || incomplete {
+            rows = append(rows, &InvalidProbabilityRow{Node: node, Sum: totalProb})
+        }
+    }
+    if len(rows) > 0 {
+        return &TransitionProbabilityError{Rows: rows}
+    }
+    return nil
+}
+
+func genCounterMatrices(nodes []*Node, model *proto.PerformanceModel) map[string]*SparseMatrix {
+    builders := make(map[string]*sparseBuilder)
+    matrices := make(map[string]*SparseMatrix)
     if model == nil {
         return matrices
     }
     for _, config := range model.Configs {
         for name, _ := range config.Counters {
-            if matrices[name] == nil {
-                matrices[name] = create2DMatrix(len(nodes))
+            if builders[name] == nil {
+                builders[name] = newSparseBuilder(len(nodes))
             }
         }
     }
@@ -89,7 +228,9 @@ func genCounterMatrices(nodes []*Node, model *proto.PerformanceModel) map[string
                     continue
                 }
                 for name, counter := range config.Counters {
-                    matrices[name][indexMap[node]][indexMap[outboundLink.Node]] += counter.GetNumeric()
+                    value, err := counterValue(node, counter)
+                    node.PanicOnError(fmt.Sprintf("Error evaluating the numeric_expr of the counter %s", name), err)
+                    builders[name].Add(indexMap[node], indexMap[outboundLink.Node], value)
                 }
             }
 
@@ -97,13 +238,56 @@ func genCounterMatrices(nodes []*Node, model *proto.PerformanceModel) map[string
 
     }
 
+    for name, builder := range builders {
+        matrices[name] = builder.Build()
+    }
     return matrices
 }
 
-func create2DMatrix(n int) [][]float64 {
-    matrix := make([][]float64, n)
-    for i := range matrix {
-        matrix[i] = make([]float64, n)
+// linkCounter is a counter added on the transitions between two nodes.
+type linkCounter struct {
+    name    string
+    counter *proto.Counter
+}
+
+// genLinkCounters returns the counters of the transitions between each pair of nodes,
+// keyed by from*len(nodes)+to. Like the counter matrices, the counters of all the
+// links between the same nodes are added on the transition.
+func genLinkCounters(nodes []*Node, model *proto.PerformanceModel) map[int][]linkCounter {
+    linkCounters := make(map[int][]linkCounter)
+    if model == nil {
+        return linkCounters
+    }
+    indexMap := make(map[*Node]int)
+    for i, node := range nodes {
+        indexMap[node] = i
+    }
+    for _, node := range nodes {
+        for _, outboundLink := range node.Outbound {
+            key := indexMap[node]*len(nodes) + indexMap[outboundLink.Node]
+            for _, label := range outboundLink.Labels {
+                config := model.Configs[label]
+                if config == nil {
+                    continue
+                }
+                // Sorted, so the samples with the same seed are the same.
+                names := make([]string, 0, len(config.Counters))
+                for name := range config.Counters {
+                    names = append(names, name)
+                }
+                sort.Strings(names)
+                for _, name := range names {
+                    counter := config.Counters[name]
+                    if counter.GetNumericExpr() != "" {
+                        // The value of the expression is fixed for the source state.
+                        value, err := counterValue(node, counter)
+                        node.PanicOnError(fmt.Sprintf("Error evaluating the numeric_expr of the counter %s", name), err)
+                        counter = &proto.Counter{Numeric: value}
+                    }
+                    linkCounters[key] = append(linkCounters[key], linkCounter{name: name, counter: counter})
+                }
+            }
+        }
     }
-    return matrix
-}
\ No newline at end of file
+    return linkCounters
+}
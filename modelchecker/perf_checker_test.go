@@ -0,0 +1,148 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"testing"
+)
+
+// A queue of up to 2 elements, where an enqueue either succeeds or the element
+// is dropped.
+const boundedQueueAstJson = `
+{
+  "actions": [
+    {
+      "name": "Init",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "queue = 0"}}]}
+    },
+    {
+      "name": "Enqueue",
+      "flow": "FLOW_ATOMIC",
+      "block": {"flow": "FLOW_ONEOF", "stmts": [
+        {"label": "ok", "pyStmt": {"code": "queue = min(queue + 1, 2)"}},
+        {"label": "drop", "pyStmt": {"code": "queue = queue"}}
+      ]}
+    }
+  ]
+}
+`
+
+func startBoundedQueue(t *testing.T) []*Node {
+	file, err := parseAstFromString(boundedQueueAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		Options: &ast.Options{MaxActions: 10, MaxConcurrentActions: 1},
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+	nodes, _, _ := getAllNodes(root)
+	return nodes
+}
+
+// queueNodeIndex returns the index of the node with the name where the queue has the length.
+func queueNodeIndex(t *testing.T, nodes []*Node, name string, length int) int {
+	for i, node := range nodes {
+		if node.Name == name && node.Heap.ToJson() == fmt.Sprintf(`{"queue":%d}`, length) {
+			return i
+		}
+	}
+	require.Fail(t, "node not found", "%s with queue length %d", name, length)
+	return -1
+}
+
+func TestGenTransitionMatrix_Expressions(t *testing.T) {
+	nodes := startBoundedQueue(t)
+	perfModel := &ast.PerformanceModel{}
+	require.Nil(t, protojson.Unmarshal([]byte(`{"configs": {
+		"Enqueue.ok": {
+			"probabilityExpr": "0.0 if queue >= 2 else 0.9 - 0.4 * queue",
+			"counters": {"latency": {"numericExpr": "1 + queue"}}
+		},
+		"Enqueue.drop": {
+			"probabilityExpr": "1.0 if queue >= 2 else 0.1 + 0.4 * queue",
+			"counters": {"latency": {"numeric": 1}}
+		}
+	}}`), perfModel))
+	require.Nil(t, CheckTransitionProbabilities(nodes, perfModel))
+
+	matrix := genTransitionMatrix(nodes, perfModel)
+	counters := genCounterMatrices(nodes, perfModel)
+	// The labeled links are from the Enqueue node to the next yield node.
+	enqueue := make([]int, 3)
+	yield := make([]int, 3)
+	for length := range enqueue {
+		enqueue[length] = queueNodeIndex(t, nodes, "Enqueue", length)
+		yield[length] = queueNodeIndex(t, nodes, "yield", length)
+	}
+
+	assert.InDelta(t, 0.9, matrix.Get(enqueue[0], yield[1]), 1e-12)
+	assert.InDelta(t, 0.1, matrix.Get(enqueue[0], yield[0]), 1e-12)
+	assert.InDelta(t, 0.5, matrix.Get(enqueue[1], yield[2]), 1e-12)
+	assert.InDelta(t, 0.5, matrix.Get(enqueue[1], yield[1]), 1e-12)
+	assert.InDelta(t, 1.0, matrix.Get(enqueue[2], yield[2]), 1e-12)
+
+	// The counter of each link is evaluated in its source state.
+	assert.Equal(t, 1.0, counters["latency"].Get(enqueue[0], yield[1]))
+	assert.Equal(t, 2.0, counters["latency"].Get(enqueue[1], yield[2]))
+	// Both the links from the full queue lead to the same state.
+	assert.Equal(t, 3.0+1.0, counters["latency"].Get(enqueue[2], yield[2]))
+}
+
+func TestCheckTransitionProbabilities(t *testing.T) {
+	nodes := startBoundedQueue(t)
+	tests := []struct {
+		name    string
+		configs string
+		rows    int
+		message string
+	}{
+		{
+			name: "static probabilities",
+			configs: `{"Enqueue.ok": {"probability": 0.9}, "Enqueue.drop": {"probability": 0.1}}`,
+		},
+		{
+			name:    "does not sum to 1 when full",
+			configs: `{"Enqueue.ok": {"probabilityExpr": "0.0 if queue >= 2 else 0.9"}, "Enqueue.drop": {"probability": 0.1}}`,
+			rows:    1,
+			message: "the transition probabilities of 1 states do not sum to 1\n  state: {\"queue\":2}, sum: 0.1",
+		},
+		{
+			name:    "exceeds 1",
+			configs: `{"Enqueue.ok": {"probabilityExpr": "0.9 + queue"}, "Enqueue.drop": {"probability": 0.1}}`,
+			rows:    2,
+		},
+		{
+			name:    "negative",
+			configs: `{"Enqueue.ok": {"probabilityExpr": "1.0 - queue"}, "Enqueue.drop": {"probabilityExpr": "1.0 * queue"}}`,
+			rows:    1,
+		},
+		{
+			name:    "not a number",
+			configs: `{"Enqueue.ok": {"probabilityExpr": "'high'"}}`,
+			rows:    3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			perfModel := &ast.PerformanceModel{}
+			require.Nil(t, protojson.Unmarshal([]byte(`{"configs": `+test.configs+`}`), perfModel))
+			err := CheckTransitionProbabilities(nodes, perfModel)
+			if test.rows == 0 {
+				require.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			probErr, ok := err.(*TransitionProbabilityError)
+			require.True(t, ok)
+			assert.Len(t, probErr.Rows, test.rows)
+			if test.message != "" {
+				assert.Equal(t, test.message, err.Error())
+			}
+		})
+	}
+}
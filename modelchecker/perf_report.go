@@ -0,0 +1,315 @@
+package modelchecker
+
+import (
+	"encoding/json"
+	"fizz/proto"
+	"github.com/jayaprabhakar/fizzbee/lib"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
+)
+
+// reportPercentiles are the percentiles reported for each counter.
+var reportPercentiles = []struct {
+	name string
+	p    float64
+}{
+	{"p50", 0.50},
+	{"p90", 0.90},
+	{"p95", 0.95},
+	{"p99", 0.99},
+}
+
+// reportMinProbability is the minimum steady state probability of the states
+// included in the report.
+const reportMinProbability = 1e-6
+
+// PerformanceReport is the result of the performance analysis of the state graph
+// with a performance model.
+type PerformanceReport struct {
+	// SteadyState has the counters of the chain starting at the root node.
+	SteadyState *PerformanceMetrics `json:"steady_state"`
+	// States are the states with a non-negligible steady state probability, the
+	// most likely first.
+	States []*StateProbability `json:"states"`
+	// Invariants has the cost to reach the states where each eventually invariant holds.
+	Invariants []*InvariantPerformance `json:"invariants,omitempty"`
+	// ContinuousTime is set if the performance model has the continuous time options.
+	ContinuousTime *ContinuousTimeReport `json:"continuous_time,omitempty"`
+}
+
+// PerformanceMetrics has the metrics of each counter.
+type PerformanceMetrics struct {
+	Counters map[string]*CounterMetrics `json:"counters"`
+	// Sampled has the distributions of the counters estimated by sampling the paths.
+	Sampled *SampledCosts `json:"sampled,omitempty"`
+}
+
+// CounterMetrics has the mean, the percentiles and the cumulative distribution
+// of a counter. For the invariants, only the mean is set, the distributions are
+// in the sampled costs.
+type CounterMetrics struct {
+	Mean float64 `json:"mean"`
+	// Percentiles are only set if the chain terminates with that probability.
+	Percentiles map[string]float64 `json:"percentiles"`
+	Histogram   []*HistogramPoint  `json:"histogram"`
+}
+
+// HistogramPoint is a point of the cumulative distribution. The probability of
+// terminating with the counter at most Value is Probability.
+type HistogramPoint struct {
+	Probability float64 `json:"probability"`
+	Value       float64 `json:"value"`
+}
+
+// StateProbability is the steady state probability of a node.
+type StateProbability struct {
+	Node        int     `json:"node"`
+	Probability float64 `json:"probability"`
+	State       string  `json:"state"`
+	Returns     string  `json:"returns,omitempty"`
+}
+
+// InvariantPerformance has the metrics to reach the states where an eventually
+// invariant holds.
+type InvariantPerformance struct {
+	Name             string `json:"name"`
+	FileIndex        int    `json:"file_index"`
+	InvariantIndex   int    `json:"invariant_index"`
+	EventuallyAlways bool   `json:"eventually_always"`
+	// Probability is the probability of eventually reaching a state where the invariant holds.
+	Probability float64 `json:"probability"`
+	// ExpectedSteps is nil if the states might never be reached, as json has no infinity.
+	// The means of the counters are not set either in that case.
+	ExpectedSteps *float64 `json:"expected_steps"`
+	// NotAbsorbed is the probability of never reaching a state where the invariant holds.
+	NotAbsorbed float64 `json:"not_absorbed"`
+	// Converged is false if the solver of the absorbing chain did not converge, and
+	// Residual is the maximum residual of its equations.
+	Converged bool    `json:"converged"`
+	Residual  float64 `json:"residual"`
+	*PerformanceMetrics
+}
+
+// ContinuousTimeReport is the result of the continuous time analysis. The times
+// are in the unit of the rates of the performance model.
+type ContinuousTimeReport struct {
+	// ExpectedTime is the expected time to reach a state the chain cannot leave, or
+	// nil if it might never reach one.
+	ExpectedTime *float64 `json:"expected_time"`
+	// Invariants has the time to reach the states where each eventually invariant holds.
+	Invariants []*InvariantTime `json:"invariants,omitempty"`
+	// Availability is the long run fraction of the time spent in the states where
+	// each predicate holds.
+	Availability map[string]float64 `json:"availability,omitempty"`
+	// Converged is false if the long run distribution did not converge.
+	Converged bool `json:"converged"`
+	// Transient has the probabilities of the predicates at each of the transient times.
+	Transient []*TransientProbabilities `json:"transient,omitempty"`
+}
+
+// InvariantTime has the time to reach the states where an eventually invariant holds.
+type InvariantTime struct {
+	Name           string `json:"name"`
+	FileIndex      int    `json:"file_index"`
+	InvariantIndex int    `json:"invariant_index"`
+	// Probability is the probability of eventually reaching a state where the invariant holds.
+	Probability float64 `json:"probability"`
+	// ExpectedTime is nil if the states might never be reached.
+	ExpectedTime *float64 `json:"expected_time"`
+}
+
+// TransientProbabilities are the probabilities of the predicates at a time.
+type TransientProbabilities struct {
+	Time          float64            `json:"time"`
+	Probabilities map[string]float64 `json:"probabilities"`
+}
+
+// ReadPerformanceModelFromYaml reads the performance model from the yaml representation
+// of proto/performance_model.proto.
+func ReadPerformanceModelFromYaml(filename string) (*proto.PerformanceModel, error) {
+	msg := &proto.PerformanceModel{}
+	err := lib.ReadProtoFromFile(filename, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidatePerformanceModel(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// AnalyzePerformance computes the steady state distribution from the root node, and
+// for each invariant with eventually, the cost to reach the states where it holds.
+// The distributions of the costs are sampled with a fixed seed, so the report is
+// the same on every run. It returns a TransitionProbabilityError if the transition
+// probabilities of the performance model are invalid in some states.
+func AnalyzePerformance(root *Node, files []*proto.File, perfModel *proto.PerformanceModel) (*PerformanceReport, error) {
+	nodes, _, _ := getAllNodes(root)
+	if err := CheckTransitionProbabilities(nodes, perfModel); err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(costSamplingSeed))
+	steadyState, histogram := steadyStateDistribution(root, perfModel)
+	report := &PerformanceReport{
+		SteadyState: newPerformanceMetrics(histogram),
+		States:      make([]*StateProbability, 0),
+	}
+	report.SteadyState.Sampled = sampleSteadyStateCosts(root, perfModel, rng)
+	for i, prob := range steadyState {
+		if prob <= reportMinProbability || nodes[i].Process == nil {
+			continue
+		}
+		state := &StateProbability{Node: i, Probability: prob, State: nodes[i].Heap.ToJson()}
+		if len(nodes[i].Returns) > 0 {
+			state.Returns = nodes[i].Returns.String()
+		}
+		report.States = append(report.States, state)
+	}
+	sort.SliceStable(report.States, func(i, j int) bool {
+		return report.States[i].Probability > report.States[j].Probability
+	})
+
+	for fileId, file := range files {
+		for invariantId, invariant := range file.Invariants {
+			if !invariant.Eventually && !slices.Contains(invariant.TemporalOperators, "eventually") {
+				continue
+			}
+			result := SolveAbsorptionCosts(root, perfModel, fileId, invariantId)
+			invariantReport := &InvariantPerformance{
+				Name:               invariant.Name,
+				FileIndex:          fileId,
+				InvariantIndex:     invariantId,
+				EventuallyAlways:   isEventuallyAlways(invariant),
+				NotAbsorbed:        result.NotAbsorbed,
+				Converged:          result.Converged,
+				Residual:           result.Residual,
+				PerformanceMetrics: &PerformanceMetrics{Counters: make(map[string]*CounterMetrics)},
+			}
+			// The initial distribution of the absorbing chain might not sum to 1.
+			if absorbed := sum(result.Absorption); absorbed > 0 {
+				invariantReport.Probability = absorbed / (absorbed + result.NotAbsorbed)
+			}
+			invariantReport.Sampled = SampleAbsorptionCosts(root, perfModel, fileId, invariantId, rng)
+			if !math.IsInf(result.ExpectedSteps, 1) {
+				invariantReport.ExpectedSteps = &result.ExpectedSteps
+				for counter, mean := range result.Counters {
+					invariantReport.Counters[counter] = &CounterMetrics{Mean: mean}
+				}
+			}
+			report.Invariants = append(report.Invariants, invariantReport)
+		}
+	}
+	if perfModel.GetContinuousTime() != nil {
+		continuousTime, err := analyzeContinuousTime(nodes, files, perfModel)
+		if err != nil {
+			return nil, err
+		}
+		report.ContinuousTime = continuousTime
+	}
+	return report, nil
+}
+
+// analyzeContinuousTime computes the expected times to absorption, the availability
+// and the transient probabilities of the predicates, of the continuous time chain.
+func analyzeContinuousTime(nodes []*Node, files []*proto.File, perfModel *proto.PerformanceModel) (*ContinuousTimeReport, error) {
+	chain, err := NewContinuousTimeChain(nodes, perfModel)
+	if err != nil {
+		return nil, err
+	}
+	options := perfModel.GetContinuousTime()
+	report := &ContinuousTimeReport{Availability: make(map[string]float64)}
+	result := chain.ExpectedTimeToAbsorption(func(*Node) bool { return false })
+	report.ExpectedTime = absorptionTime(result)
+
+	for fileId, file := range files {
+		for invariantId, invariant := range file.Invariants {
+			if !invariant.Eventually && !slices.Contains(invariant.TemporalOperators, "eventually") {
+				continue
+			}
+			result := chain.ExpectedTimeToAbsorption(func(node *Node) bool {
+				return node.Process != nil && node.Witness[fileId][invariantId]
+			})
+			invariantTime := &InvariantTime{
+				Name:           invariant.Name,
+				FileIndex:      fileId,
+				InvariantIndex: invariantId,
+				ExpectedTime:   absorptionTime(result),
+			}
+			if absorbed := sum(result.Absorption); absorbed > 0 {
+				invariantTime.Probability = absorbed / (absorbed + result.NotAbsorbed)
+			}
+			report.Invariants = append(report.Invariants, invariantTime)
+		}
+	}
+
+	steadyState, converged := chain.SteadyState()
+	report.Converged = converged
+	for name, predicate := range options.GetPredicates() {
+		report.Availability[name], err = chain.PredicateProbability(steadyState, predicate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range options.GetTransientTimes() {
+		transient := chain.Transient(t)
+		probabilities := &TransientProbabilities{Time: t, Probabilities: make(map[string]float64)}
+		for name, predicate := range options.GetPredicates() {
+			probabilities.Probabilities[name], err = chain.PredicateProbability(transient, predicate)
+			if err != nil {
+				return nil, err
+			}
+		}
+		report.Transient = append(report.Transient, probabilities)
+	}
+	return report, nil
+}
+
+// absorptionTime returns the expected time to absorption, or nil if the chain might
+// never be absorbed.
+func absorptionTime(result *AbsorptionResult) *float64 {
+	if math.IsInf(result.ExpectedSteps, 1) {
+		return nil
+	}
+	time := result.Counters["time"]
+	return &time
+}
+
+// WriteJson writes the report as json to the file.
+func (r *PerformanceReport) WriteJson(filename string) error {
+	bytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, bytes, 0644)
+}
+
+func newPerformanceMetrics(histogram *Histogram) *PerformanceMetrics {
+	metrics := &PerformanceMetrics{Counters: make(map[string]*CounterMetrics)}
+	for counter, mean := range histogram.GetMeanCounts() {
+		counterMetrics := &CounterMetrics{
+			Mean:        mean,
+			Percentiles: make(map[string]float64),
+			Histogram:   make([]*HistogramPoint, 0, len(histogram.entries)),
+		}
+		for _, percentile := range reportPercentiles {
+			if value, ok := histogram.GetPercentile(counter, percentile.p); ok {
+				counterMetrics.Percentiles[percentile.name] = value
+			}
+		}
+		for _, entry := range histogram.entries {
+			counterMetrics.Histogram = append(counterMetrics.Histogram,
+				&HistogramPoint{Probability: entry.percentile, Value: entry.counters[counter]})
+		}
+		metrics.Counters[counter] = counterMetrics
+	}
+	return metrics
+}
+
+func isEventuallyAlways(invariant *proto.Invariant) bool {
+	return (invariant.Eventually && invariant.GetNested().GetAlways()) ||
+		(len(invariant.TemporalOperators) == 2 &&
+			invariant.TemporalOperators[0] == "eventually" && invariant.TemporalOperators[1] == "always")
+}
@@ -0,0 +1,70 @@
+package modelchecker
+
+import (
+	"encoding/json"
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyzePerformance(t *testing.T) {
+	file, err := parseAstFromString(labeledMarkovChainAstJson)
+	require.Nil(t, err)
+	files := []*ast.File{file}
+	p1, err := NewProcessor(files, &ast.StateSpaceOptions{
+		ContinuePathOnInvariantFailures: true,
+		ContinueOnInvariantFailures:     true,
+		Options:                         &ast.Options{MaxActions: 10, MaxConcurrentActions: 1},
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+	perfModel := &ast.PerformanceModel{}
+	require.Nil(t, protojson.Unmarshal([]byte(`{"configs": {
+		"Prepare.prepare": {"counters": {"work": {"numeric": 1}}},
+		"Commit.commit": {"counters": {"work": {"numeric": 10}}}
+	}}`), perfModel))
+
+	report, err := AnalyzePerformance(root, files, perfModel)
+	require.Nil(t, err)
+
+	// The chain always ends in the committed state.
+	require.NotEmpty(t, report.States)
+	assert.InDelta(t, 1.0, report.States[0].Probability, 1e-6)
+	assert.Contains(t, report.States[0].State, "committed")
+	// From in_progress, the chain prepares twice on average before committing.
+	work := report.SteadyState.Counters["work"]
+	require.NotNil(t, work)
+	assert.InDelta(t, 12, work.Mean, 1e-3)
+	assert.Contains(t, work.Percentiles, "p50")
+	assert.LessOrEqual(t, work.Percentiles["p50"], work.Percentiles["p90"])
+	assert.LessOrEqual(t, work.Percentiles["p90"], work.Percentiles["p99"])
+	for i := 1; i < len(work.Histogram); i++ {
+		assert.Greater(t, work.Histogram[i].Probability, work.Histogram[i-1].Probability)
+	}
+
+	require.Len(t, report.Invariants, 1)
+	invariant := report.Invariants[0]
+	assert.True(t, invariant.EventuallyAlways)
+	assert.InDelta(t, 1.0, invariant.Probability, 1e-9)
+	require.NotNil(t, invariant.ExpectedSteps)
+	assert.InDelta(t, 1.75, *invariant.ExpectedSteps, 1e-9)
+	assert.InDelta(t, 5.75, invariant.Counters["work"].Mean, 1e-9)
+	assert.True(t, invariant.Converged)
+	assert.LessOrEqual(t, invariant.Residual, absorbingSolverTolerance)
+	assert.InDelta(t, 0, invariant.NotAbsorbed, 1e-9)
+
+	filename := filepath.Join(t.TempDir(), "perf_results.json")
+	require.Nil(t, report.WriteJson(filename))
+	bytes, err := os.ReadFile(filename)
+	require.Nil(t, err)
+	parsed := &PerformanceReport{}
+	require.Nil(t, json.Unmarshal(bytes, parsed))
+	assert.Equal(t, report, parsed)
+	assert.Contains(t, string(bytes), `"not_absorbed"`)
+	assert.Contains(t, string(bytes), `"residual"`)
+}
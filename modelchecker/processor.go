@@ -20,9 +20,9 @@ import (
 	"fmt"
 	"github.com/jayaprabhakar/fizzbee/lib"
 	"go.starlark.net/starlark"
-	"maps"
 	"os"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -41,7 +41,8 @@ type Definition struct {
 	DefType   DefType
 	name      string
 	fileIndex int
-	path      string
+	// index is the position of the function in the file.
+	index     int
 }
 
 type Stats struct {
@@ -78,10 +79,15 @@ type Process struct {
 	Current          int              `json:"current"`
 	Name             string           `json:"name"`
 	Files            []*ast.File      `json:"-"`
+	// programs are the compiled programs of the files, shared by all the processes.
+	programs         []*Program
+	// Parent is the process this one was forked from, until it is no longer needed
+	// to enable the ancestors. See Enable.
 	Parent           *Process         `json:"-"`
 	Evaluator        *Evaluator       `json:"-"`
-	Children         []*Process       `json:"-"`
 	FailedInvariants map[int][]int    `json:"failedInvariants"`
+	// FailedAssertion is set when an assert statement failed in this process.
+	FailedAssertion  *ModelError      `json:"-"`
 	Stats            *Stats           `json:"stats"`
 	// Witness indicates the successful liveness checks
 	// For liveness checks, not all nodes will pass the condition, witness indicates
@@ -90,6 +96,8 @@ type Process struct {
 	Returns     starlark.StringDict    `json:"returns"`
 	SymbolTable map[string]*Definition `json:"-"`
 	Labels 		[]string               `json:"-"`
+	// Logs are the lines printed by the statements executed in this transition.
+	Logs        []string               `json:"-"`
 
 	// Fairness is actually a property of the transition/link. But to determine whether
 	// the link is fair, we need to know if the process stepped through at least one
@@ -102,6 +110,17 @@ type Process struct {
 }
 
 func NewProcess(name string, files []*ast.File, parent *Process) *Process {
+	var programs []*Program
+	if parent == nil {
+		programs = CompilePrograms(files)
+	}
+	return newProcess(name, files, programs, parent)
+}
+
+// newProcess returns a process running the compiled programs of the files.
+// If the parent is set, the programs, the evaluator and the symbol table are
+// shared with it.
+func newProcess(name string, files []*ast.File, programs []*Program, parent *Process) *Process {
 	var mc *Evaluator
 	var symbolTable map[string]*Definition
 
@@ -110,28 +129,30 @@ func NewProcess(name string, files []*ast.File, parent *Process) *Process {
 		symbolTable = make(map[string]*Definition)
 
 		for i, file := range files {
+			PanicOnError(mc.LoadHelpers(fmt.Sprintf("helpers%d.fizz", i), file.Helpers))
 			for j, function := range file.Functions {
 				symbolTable[function.Name] = &Definition{
 					DefType:   Function,
 					name:      function.Name,
 					fileIndex: i,
-					path:      fmt.Sprintf("Functions[%d]", j),
+					index:     j,
 				}
 			}
 		}
 	} else {
 		mc = parent.Evaluator
 		symbolTable = parent.SymbolTable
+		programs = parent.programs
 	}
 	p := &Process{
 		Name:        name,
-		Heap:        &Heap{starlark.StringDict{}},
+		Heap:        &Heap{globals: starlark.StringDict{}},
 		Threads:     []*Thread{},
 		Current:     0,
 		Files:       files,
+		programs:    programs,
 		Parent:      parent,
 		Evaluator:   mc,
-		Children:    []*Process{},
 		Returns:     make(starlark.StringDict),
 		SymbolTable: symbolTable,
 		Labels:      make([]string, 0),
@@ -141,8 +162,6 @@ func NewProcess(name string, files []*ast.File, parent *Process) *Process {
 	for i, file := range files {
 		p.Witness[i] = make([]bool, len(file.Invariants))
 	}
-	p.Children = append(p.Children, p)
-
 	return p
 }
 
@@ -153,14 +172,29 @@ func (p *Process) MarshalJSON() ([]byte, error) {
 		"current":   p.Current,
 		"name":      p.Name,
 		"failedInvariants": p.FailedInvariants,
+		"failedAssertion": p.failedAssertionMsg(),
 		"stats":     p.Stats,
 		"witness":   p.Witness,
-		"returns":   StringDictToJsonString(p.Returns),
+		"returns":   StringDictToMap(p.Returns),
 	})
 }
 
+func (p *Process) failedAssertionMsg() string {
+	if p.FailedAssertion == nil {
+		return ""
+	}
+	return p.FailedAssertion.Msg
+}
+
 func (p *Process) HasFailedInvariants() bool {
-	if p == nil || p.FailedInvariants == nil {
+	if p == nil {
+		return false
+	}
+	if p.FailedAssertion != nil {
+		// A failed assert statement is treated the same as a failed invariant.
+		return true
+	}
+	if p.FailedInvariants == nil {
 		return false
 	}
 	for _, invIndex := range p.FailedInvariants {
@@ -178,8 +212,8 @@ func (p *Process) Fork() *Process {
 		Current:     p.Current,
 		Parent:      p,
 		Evaluator:   p.Evaluator,
-		Children:    []*Process{},
 		Files:       p.Files,
+		programs:    p.programs,
 		Returns:     make(starlark.StringDict),
 		SymbolTable: p.SymbolTable,
 		Labels:      make([]string, 0),
@@ -190,7 +224,6 @@ func (p *Process) Fork() *Process {
 		p2.Witness[i] = make([]bool, len(file.Invariants))
 	}
 
-	p.Children = append(p.Children, p2)
 	clonedThreads := make([]*Thread, len(p.Threads))
 	for i, thread := range p.Threads {
 		clonedThreads[i] = thread.Clone()
@@ -200,20 +233,26 @@ func (p *Process) Fork() *Process {
 	return p2
 }
 
+// Enable marks the process as enabled, along with its ancestors up to the first one
+// that is enabled or has no threads. The walk never goes past such an ancestor, so
+// the parent is released once the process is enabled, and the processes that are
+// not reachable from the graph can be garbage collected.
 func (p *Process) Enable() {
 	if !p.Enabled {
 		parent := p.Parent
 		for parent != nil && len(parent.Threads) != 0 && !parent.Enabled {
 			parent.Enabled = true
-			parent = parent.Parent
-
+			next := parent.Parent
+			parent.Parent = nil
+			parent = next
 		}
 	}
 	p.Enabled = true
+	p.Parent = nil
 }
 
 func (p *Process) NewThread() *Thread {
-	thread := NewThread(p, p.Files, 0, "")
+	thread := NewThread(p, p.Files, 0, noPc)
 	p.Threads = append(p.Threads, thread)
 	return thread
 }
@@ -287,6 +326,27 @@ func (n *Node) GetName() string {
 }
 
 func (p *Process) HashCode() string {
+	return p.hashCode(p.Heap.HashCode(), true)
+}
+
+// ViewHashCode returns the hash code used to detect the duplicate states when the
+// view option is set. The heap is replaced with the value of the view expression
+// evaluated over the globals, and the returns are included only if includeReturns
+// is true. An empty view hashes the whole heap.
+func (p *Process) ViewHashCode(view string, includeReturns bool) string {
+	if view == "" {
+		return p.hashCode(p.Heap.HashCode(), includeReturns)
+	}
+	// The globals are shared with the forks, so the view evaluates over a copy in
+	// case it mutates them.
+	value, err := p.Evaluator.EvalPyExpr("view.fizz", view, CloneDict(p.Heap.globals))
+	p.PanicOnError(fmt.Sprintf("Error evaluating view: %s", view), err)
+	h := sha256.New()
+	h.Write(CanonicalBytes(value))
+	return p.hashCode(fmt.Sprintf("%x", h.Sum(nil)), includeReturns)
+}
+
+func (p *Process) hashCode(heapHash string, includeReturns bool) string {
 	threadHashes := make([]string, len(p.Threads))
 	for i, thread := range p.Threads {
 		threadHashes[i] = thread.HashCode()
@@ -307,10 +367,11 @@ func (p *Process) HashCode() string {
 		h.Write([]byte(hash))
 	}
 
-	h.Write([]byte(StringDictToJsonString(p.Returns)))
+	if includeReturns {
+		h.Write(StringDictCanonicalBytes(p.Returns))
+	}
 
 	// hash the heap variables as well
-	heapHash := p.Heap.HashCode()
 	h.Write([]byte(heapHash))
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
@@ -340,7 +401,7 @@ func (p *Process) GetAllVariables() starlark.StringDict {
 func (p *Process) updateAllVariablesInScope(dict starlark.StringDict) {
 	frame := p.currentThread().currentFrame()
 	for k, v := range dict {
-		if p.updateScopedVariable(frame.scope, k, v) {
+		if p.updateScopedVariable(frame, frame.scope, k, v) {
 			// Check local variables in the scope, starting from
 			// deepest to its parent. If present, update that
 			// and continue
@@ -352,19 +413,19 @@ func (p *Process) updateAllVariablesInScope(dict starlark.StringDict) {
 			continue
 		}
 		// Declare the variable to the Current scope
-		frame.scope.vars[k] = v
+		frame.currentScope().vars[k] = v
 	}
 }
 
-func (p *Process) updateScopedVariable(scope *Scope, key string, val starlark.Value) bool {
+func (p *Process) updateScopedVariable(frame *CallFrame, scope *Scope, key string, val starlark.Value) bool {
 	if scope == nil {
 		return false
 	}
 	if _, ok := scope.vars[key]; ok {
-		scope.vars[key] = val
+		frame.writableScope(scope).vars[key] = val
 		return true
 	}
-	return p.updateScopedVariable(scope.parent, key, val)
+	return p.updateScopedVariable(frame, scope.parent, key, val)
 }
 
 func (p *Process) NewModelError(msg string, nestedError error) *ModelError {
@@ -394,16 +455,63 @@ type Node struct {
 	forkDepth  int
 	stacktrace string
 
-	// ancestors map is used to detect cycles in the graph.
-	// TODO(jp): Should this be an array instead?
-	ancestors map[string]bool
+	// The fields below are used only by the on-the-fly liveness checker to know
+	// when all the outbound links of a node are known.
+	// processed is set once the node is popped from the queue and executed.
+	processed bool
+	// yielded is set when the node stopped at a yield point, so the enabled
+	// state of its children does not depend on their descendants.
+	yielded bool
+	// pendingChildren is the number of children still in the queue.
+	pendingChildren int
+	// determined is set once the parent was told whether the link to this node is enabled.
+	determined bool
+	// visitedKey caches the key of the node in the visited map, see Processor.visitedKey.
+	visitedKey string
+	// detached is set when the node is not added to the graph, either because
+	// it is a duplicate or it was not explored.
+	detached bool
+
+	// boundary is set when some of the successors of this node were not explored
+	// because of the max_actions or max_preemptions limits. The liveness checkers
+	// cannot tell what happens after this node, so it is not treated as a terminal state.
+	boundary bool
+
+	// disabledActions are the names of the outbound links that were explored but
+	// did not execute any statement. Cleared by GetAllNodes for the nodes with enabled links.
+	disabledActions []string
+
+	// preemptions is the fewest context switches along the paths found to this node,
+	// where the thread that yielded could have continued, but another thread or
+	// a new action was scheduled instead.
+	preemptions int
+	// reexplored is set when the node was reached again with fewer preemptions, and
+	// its children are explored again. previousPreemptions is the count they were
+	// explored with before.
+	reexplored          bool
+	previousPreemptions int
+	// linked is set when the link from the parent is already in the graph, because the
+	// parent is explored again with fewer preemptions.
+	linked bool
 }
 
 type Link struct {
 	Node *Node
 	Name string
 	Labels   []string
+	// Logs are the lines printed with print() in the transition.
+	Logs     []string
 	Fairness ast.FairnessLevel
+	// Weight is the relative weight of scheduling the action, set from the action_options.
+	// 0 means the default weight 1.
+	Weight float64
+}
+
+func (l *Link) weight() float64 {
+	if l.Weight == 0 {
+		return 1
+	}
+	return l.Weight
 }
 
 func NewNode(process *Process) *Node {
@@ -414,12 +522,16 @@ func NewNode(process *Process) *Node {
 		actionDepth: 0,
 		forkDepth:   0,
 		stacktrace:  captureStackTrace(),
-		ancestors:   make(map[string]bool),
 	}
 }
 
 func (n *Node) Duplicate(other *Node) {
 	if !n.Enabled {
+		// Remember the disabled action to report it, if the parent turns out to be a deadlock.
+		n.Inbound[0].Node.addDisabledAction(n.Inbound[0].Name)
+		return
+	}
+	if n.linked {
 		return
 	}
 	parent := n.Inbound[0].Node
@@ -428,9 +540,22 @@ func (n *Node) Duplicate(other *Node) {
 		Node:     other,
 		Name:     n.Inbound[0].Name,
 		Labels:   n.Inbound[0].Labels,
+		Logs:     n.Inbound[0].Logs,
 		Fairness: n.Inbound[0].Fairness,
+		Weight:   n.Inbound[0].Weight,
 	})
-	maps.Copy(other.ancestors, n.ancestors)
+}
+
+func (n *Node) addDisabledAction(name string) {
+	if name != "" && !slices.Contains(n.disabledActions, name) {
+		n.disabledActions = append(n.disabledActions, name)
+	}
+}
+
+// IsBoundary returns true if the exploration was cut off at this node
+// because of the max_actions or max_preemptions limits.
+func (n *Node) IsBoundary() bool {
+	return n.boundary
 }
 
 func (n *Node) Stutter() {
@@ -447,7 +572,9 @@ func (n *Node) Attach() {
 		Node:     n,
 		Name:     n.Inbound[0].Name,
 		Labels:   n.Inbound[0].Labels,
+		Logs:     n.Inbound[0].Logs,
 		Fairness: n.Inbound[0].Fairness,
+		Weight:   n.Inbound[0].Weight,
 	})
 }
 
@@ -466,13 +593,12 @@ func (n *Node) ForkForAction(process *Process, action *ast.Action) *Node {
 		Outbound:    make([]*Link, 0, 10),
 		actionDepth: n.actionDepth + 1,
 		forkDepth:   n.forkDepth + 1,
+		preemptions: n.preemptions,
 		stacktrace:  captureStackTrace(),
-		ancestors:   maps.Clone(n.ancestors),
 	}
 	forkNode.Process.Name = action.Name
 	forkNode.Inbound = append(forkNode.Inbound, &Link{Node: n, Name: action.Name})
 	forkNode.Process.Stats.Increment(action.Name)
-	forkNode.ancestors[n.HashCode()] = true
 	return forkNode
 }
 
@@ -484,34 +610,150 @@ func (n *Node) ForkForAlternatePaths(process *Process, name string) *Node {
 		Outbound:    make([]*Link, 0, 10),
 		actionDepth: n.actionDepth,
 		forkDepth:   n.forkDepth + 1,
+		preemptions: n.preemptions,
 		stacktrace:  captureStackTrace(),
-		ancestors:   maps.Clone(n.ancestors),
 	}
 	forkNode.Inbound = append(forkNode.Inbound, &Link{Node: n, Name: name})
-	forkNode.ancestors[n.HashCode()] = true
 	return forkNode
 }
 
+// Failure is a violation found by the model checker, along with the first node
+// found violating it. As the state space is explored in BFS order, the first node
+// is the one with the shortest counterexample.
+type Failure struct {
+	// Name is the name of the invariant, the assertion message, or "deadlock".
+	Name string
+	// Invariant is the position of the failed invariant, nil for other failures.
+	Invariant *InvariantPosition
+	// Deadlock has the details of the deadlock, nil for other failures.
+	Deadlock *Deadlock
+	Node     *Node
+}
+
 type Processor struct {
 	Init    *Node
 	Files   []*ast.File
+	// programs are the compiled programs of the files.
+	programs []*Program
 	queue   *lib.Queue[*Node]
 	visited map[string]*Node
 	config  *ast.StateSpaceOptions
+
+	// liveness is non-nil only when the liveness is checked on the fly.
+	liveness *OnTheFlyLiveness
+
+	// failures has the first failure found for each invariant or assert statement,
+	// in the order they are found. failureKeys is used to identify the duplicates.
+	failures    []*Failure
+	failureKeys map[string]bool
+
+	// boundaryCount is the number of nodes at which the exploration was cut off.
+	boundaryCount int
+	// explored is set when the exploration ended with the queue empty, so every node
+	// in the graph has all its outbound links.
+	explored bool
 }
 
-func NewProcessor(files []*ast.File, options *ast.StateSpaceOptions) *Processor {
-	return &Processor{
+// NewProcessor returns an error if the options are not valid for the files.
+func NewProcessor(files []*ast.File, options *ast.StateSpaceOptions) (*Processor, error) {
+	if err := ValidateOptions(files, options); err != nil {
+		return nil, err
+	}
+	files = applyFairnessOptions(files, options)
+	p := &Processor{
 		Files:   files,
+		programs: CompilePrograms(files),
 		queue:   lib.NewQueue[*Node](),
 		visited: make(map[string]*Node),
 		config:  options,
+		failureKeys: make(map[string]bool),
 	}
+	if options.GetLiveness() == LivenessOnTheFly {
+		p.liveness = NewOnTheFlyLiveness(files)
+	}
+	return p, nil
 }
 
 func (p *Processor) GetVisitedNodesCount() int {
 	return len(p.visited)
 }
+
+// GetBoundaryNodesCount returns the number of nodes at which the exploration
+// was cut off because of the max_actions or max_preemptions limits. If it is 0,
+// the state space was explored exhaustively.
+func (p *Processor) GetBoundaryNodesCount() int {
+	return p.boundaryCount
+}
+
+func (p *Processor) markBoundary(node *Node) {
+	if !node.boundary {
+		node.boundary = true
+		p.boundaryCount++
+	}
+}
+
+// GetLivenessFailure returns the liveness counterexample found during the exploration
+// when the liveness is checked on the fly, or nil if none was found.
+func (p *Processor) GetLivenessFailure() ([]*Link, *InvariantPosition) {
+	if p.liveness == nil {
+		return nil, nil
+	}
+	return p.liveness.FailurePath, p.liveness.FailedInvariant
+}
+
+// GetFailures returns the first failure found for each invariant and assert statement.
+// Unless continue_on_invariant_failures or report_all_failures is set, there will be
+// at most one failure.
+func (p *Processor) GetFailures() []*Failure {
+	return p.failures
+}
+
+// recordFailures adds the invariants and the assertion failed by this node
+// unless they were already found failing in another node.
+func (p *Processor) recordFailures(node *Node) {
+	for i, invariants := range node.Process.FailedInvariants {
+		for _, j := range invariants {
+			key := fmt.Sprintf("invariant:%d:%d", i, j)
+			if p.failureKeys[key] {
+				continue
+			}
+			p.failureKeys[key] = true
+			p.failures = append(p.failures, &Failure{
+				Name:      p.Files[i].Invariants[j].Name,
+				Invariant: NewInvariantPosition(i, j),
+				Node:      node,
+			})
+		}
+	}
+	if node.Process.FailedAssertion != nil {
+		key := "assertion:" + node.Process.currentThread().currentPc()
+		if !p.failureKeys[key] {
+			p.failureKeys[key] = true
+			p.failures = append(p.failures, &Failure{Name: node.Process.FailedAssertion.Msg, Node: node})
+		}
+	}
+}
+
+// enqueue adds the node to the exploration queue.
+func (p *Processor) enqueue(node *Node) {
+	if p.liveness != nil {
+		p.liveness.ChildAdded(node)
+	}
+	_ = p.queue.Push(node)
+}
+
+// nodeDone marks the node as processed, and runs the liveness checks that became possible.
+// Returns true if a liveness failure was found.
+func (p *Processor) nodeDone(node *Node) bool {
+	if p.liveness == nil {
+		return false
+	}
+	p.liveness.NodeDone(node)
+	if p.liveness.ShouldCheck(len(p.visited)) {
+		return p.liveness.Check(p.Init, len(p.visited))
+	}
+	return false
+}
 // Start the model checker
 func (p *Processor) Start() (init *Node, failedNode *Node, err error) {
 	// recover from panic
@@ -528,13 +770,18 @@ func (p *Processor) Start() (init *Node, failedNode *Node, err error) {
 		panic("processor already started")
 	}
 	startTime := time.Now()
-	process := NewProcess("init", p.Files, nil)
+	process := newProcess("init", p.Files, p.programs, nil)
+	if p.config.GetEchoPrints() {
+		process.Evaluator.EchoPrints(os.Stdout)
+	}
 
 	p.Init = NewNode(process)
 	init = p.Init
 
 	if p.Files[0].Actions[0].Name != "Init" {
+		stopCapture := process.Evaluator.capturePrints(&process.Logs)
 		globals, err := process.Evaluator.ExecInit(p.Files[0].States)
+		stopCapture()
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Error in executing init: ", p.Files[0].States, err)
 			panic(err)
@@ -544,6 +791,7 @@ func (p *Processor) Start() (init *Node, failedNode *Node, err error) {
 		failed := CheckInvariants(process)
 		if len(failed[0]) > 0 {
 			p.Init.Process.FailedInvariants = failed
+			p.recordFailures(p.Init)
 			if !p.config.ContinuePathOnInvariantFailures {
 				return p.Init, p.Init, nil
 			}
@@ -554,7 +802,7 @@ func (p *Processor) Start() (init *Node, failedNode *Node, err error) {
 		action := p.Files[0].Actions[0]
 
 		thread := p.Init.Process.NewThread()
-		thread.currentFrame().pc = fmt.Sprintf("Actions[%d]", 0)
+		thread.currentFrame().pc = thread.program().actions[0]
 		thread.currentFrame().Name = action.Name
 		p.Init.Name = action.Name
 	}
@@ -574,6 +822,9 @@ func (p *Processor) Start() (init *Node, failedNode *Node, err error) {
 
 		if node.actionDepth > int(p.config.Options.MaxActions) {
 			// Add a node to indicate why this node was not processed
+			p.markBoundary(node.Inbound[0].Node)
+			node.detached = true
+			p.nodeDone(node)
 			continue
 		}
 		if len(p.visited)%20000 == 0 && len(p.visited) != prevCount {
@@ -582,34 +833,53 @@ func (p *Processor) Start() (init *Node, failedNode *Node, err error) {
 		}
 
 		invariantFailure := p.processNode(node)
-		if p.visited[node.HashCode()] == nil {
-			p.visited[node.HashCode()] = node
+		if len(node.Process.Threads) == 0 {
+			// The process is not executed anymore, and the descendants do not enable
+			// the ancestors past a process with no threads.
+			node.Process.Parent = nil
+		}
+		if key := p.visitedKey(node); p.visited[key] == nil {
+			p.visited[key] = node
+		}
+		if node.Process.HasFailedInvariants() && !node.detached {
+			p.recordFailures(node)
 		}
 
 		if invariantFailure && failedNode == nil {
 			failedNode = node
 		}
-		if invariantFailure && !p.config.ContinueOnInvariantFailures {
+		if invariantFailure && !p.config.ContinueOnInvariantFailures && !p.config.ReportAllFailures {
 			break
 		}
+		if p.nodeDone(node) {
+			fmt.Println("Liveness failure found, stopping the exploration")
+			break
+		}
+	}
+	p.explored = p.queue.Count() == 0
+	if p.liveness != nil && p.explored {
+		p.liveness.Finish(p.Init)
 	}
 	fmt.Printf("Nodes: %d, elapsed: %s\n", len(p.visited), time.Since(startTime))
 	return p.Init, failedNode, err
 }
 
 func (p *Processor) processNode(node *Node) bool {
-	if node.Process.currentThread().currentPc() == "" && node.Name == "init" {
+	if node.Process.currentThread().currentFrame().pc == noPc && node.Name == "init" {
 		if node.Process.Files[0].Actions[0].Name != "Init" {
 			return p.processInit(node)
 		}
 
 	}
+	threadCount := len(node.Process.Threads)
+	current := node.Process.Current
 	forks, yield := node.currentThread().Execute()
 	// Add the labels from the process to the inbound links
 	// This must be done even for duplicate nodes
 	// The labels for the outbound links are added when the node is merged/attached
 	if len(node.Inbound) > 0 {
 		node.Inbound[0].Labels = append(node.Inbound[0].Labels, node.Process.Labels...)
+		node.Inbound[0].Logs = append(node.Inbound[0].Logs, node.Process.Logs...)
 		node.Inbound[0].Fairness = node.Process.Fairness
 	}
 
@@ -620,19 +890,26 @@ func (p *Processor) processNode(node *Node) bool {
 	// So, we might miss some invariants. However, since the yield points are
 	// determined by the statement, and we include program counter in the hash code,
 	// this may not be an issue.
-	if other, ok := p.visited[node.HashCode()]; ok {
+	if other, ok := p.visited[p.visitedKey(node)]; ok {
 		// Check if visited before scheduling children
 		node.Duplicate(other)
-		//if other.ancestors[node.Inbound[0].Node.HashCode()] {
-		//	fmt.Println("Cycle detected")
-		//	// TODO: Check if we can find the liveness here, incrementally.
-		//	// Naively calling the liveness checker here will make it very
-		//	// slow and expensive.
-		//}
-		return false
+		node.detached = true
+		if p.liveness != nil && node.Enabled && !node.linked {
+			p.liveness.DuplicateFound(node.Inbound[0].Node, other)
+		}
+		if !p.lowerPreemptions(other, node.preemptions) {
+			return false
+		}
+		// The state was explored with more preemptions, so the bound could have cut off
+		// some of its successors. Explore them again from the node already in the graph.
+		node = other
 	} else {
 		node.Attach()
 	}
+	if node.Process.FailedAssertion != nil {
+		// The thread cannot continue past a failed assertion.
+		return true
+	}
 
 	var failedInvariants map[int][]int
 	if yield {
@@ -648,25 +925,34 @@ func (p *Processor) processNode(node *Node) bool {
 	if !yield {
 		for _, fork := range forks {
 			newNode := node.ForkForAlternatePaths(fork, "")
-			_ = p.queue.Push(newNode)
+			newNode.linked = node.reexplored
+			p.enqueue(newNode)
 		}
 		return false
 	}
 
 	if yield {
+		node.yielded = true
 		if len(forks) > 0 {
 			//fmt.Println("yield and fork at the same time")
 			for _, fork := range forks {
-				p.YieldFork(node, fork)
+				p.YieldFork(node, fork, newYieldPoint(fork, threadCount, current))
 			}
 		} else {
-			p.YieldNode(node)
+			p.YieldNode(node, newYieldPoint(node.Process, threadCount, current))
 			node.Name = "yield"
 		}
 		node.Stutter()
 		if len(node.Process.Threads) == 0 {
 			return false
 		}
+		if p.config.ActionOptions[node.Process.currentThread().actionName()].GetDisableCrash() {
+			return false
+		}
+		if node.reexplored {
+			p.reexploreCrash(node)
+			return false
+		}
 		crashFork := node.Process.Fork()
 		crashFork.Name = "crash"
 		crashFork.removeCurrentThread()
@@ -676,6 +962,11 @@ func (p *Processor) processNode(node *Node) bool {
 		CheckInvariants(crashFork)
 		crashNode.Attach()
 		crashNode.Stutter()
+		// The crash node is not queued, so mark it as done here.
+		if p.liveness != nil {
+			p.liveness.ChildAdded(crashNode)
+		}
+		crashNode.yielded = true
 
 		//if other, ok := p.visited[node.HashCode()]; ok {
 		//	// Check if visited before scheduling children
@@ -684,7 +975,8 @@ func (p *Processor) processNode(node *Node) bool {
 		//} else {
 		//	node.Attach()
 		//}
-		p.YieldNode(crashNode)
+		p.YieldNode(crashNode, yieldPoint{running: -1, next: node.Process.Current})
+		p.nodeDone(crashNode)
 		return false
 	}
 	return false
@@ -694,84 +986,77 @@ func (p *Processor) processInit(node *Node) bool {
 	node.Stutter()
 	node.Process.removeCurrentThread()
 	// This is init node, generate a fork for each action in the file
+	actions := make([]candidate, 0, len(p.Files[0].Actions))
 	for i, action := range p.Files[0].Actions {
+		actions = append(actions, candidate{thread: -1, action: i, actionName: action.Name})
+	}
+	if p.config.GetScheduler() == SchedulerPriority {
+		actions = p.highestPriority(node.Process, actions)
+	}
+	for _, c := range actions {
+		i, action := c.action, p.Files[0].Actions[c.action]
 		newNode := node.ForkForAction(nil, action)
+		newNode.Inbound[0].Weight = p.config.ActionOptions[action.Name].GetWeight()
 		//newNode.Process.removeCurrentThread()
 		thread := newNode.Process.NewThread()
 		//thread := newNode.currentThread()
-		thread.currentFrame().pc = fmt.Sprintf("Actions[%d]", i)
+		thread.currentFrame().pc = thread.program().actions[i]
 		thread.currentFrame().Name = action.Name
-		_ = p.queue.Push(newNode)
+		p.enqueue(newNode)
 	}
 	return false
 }
 
-func (p *Processor) YieldNode(node *Node) {
-
-	for i, thread := range node.Threads {
-		if thread.currentPc() == "" {
-			continue
-		}
-		name := fmt.Sprintf("thread-%d", i)
-		newNode := node.ForkForAlternatePaths(thread.Process.Fork(), name)
-		newNode.Current = i
-
-		_ = p.queue.Push(newNode)
+// visitedKey returns the key to detect the duplicate nodes, the hash code of the process
+// or its view, if the view option is set. The key is computed once the node is executed,
+// and cached on the node.
+func (p *Processor) visitedKey(node *Node) string {
+	if node.visitedKey != "" {
+		return node.visitedKey
 	}
-
-	if node.actionDepth >= int(p.config.Options.MaxActions) ||
-		len(node.Threads) >= int(p.config.Options.MaxConcurrentActions) {
-		return
-	}
-	for i, action := range p.Files[0].Actions {
-		if action.Name == "Init" {
-			continue
-		}
-		if p.config.ActionOptions[action.Name] != nil &&
-			node.Stats.Counts[action.Name] >= int(p.config.ActionOptions[action.Name].MaxActions) {
-			continue
-		}
-		newNode := node.ForkForAction(nil, action)
-		newNode.Process.NewThread()
-		newNode.Process.Current = len(newNode.Process.Threads) - 1
-		newNode.currentThread().currentFrame().pc = fmt.Sprintf("Actions[%d]", i)
-		newNode.currentThread().currentFrame().Name = action.Name
-
-		_ = p.queue.Push(newNode)
+	if p.config.GetView() != "" || p.config.GetIgnoreReturns() {
+		node.visitedKey = node.ViewHashCode(p.config.GetView(), !p.config.GetIgnoreReturns())
+	} else {
+		node.visitedKey = node.HashCode()
 	}
+	return node.visitedKey
 }
 
-func (p *Processor) YieldFork(node *Node, process *Process) {
-	for i, thread := range process.Threads {
-		if thread.currentPc() == "" {
-			continue
-		}
-		name := fmt.Sprintf("thread-%d", i)
-		newNode := node.ForkForAlternatePaths(thread.Process.Fork(), name)
-		newNode.Current = i
-
-		_ = p.queue.Push(newNode)
+// lowerPreemptions is called when the visited node is reached again with the given
+// preemption count. With max_preemptions, a state reached with fewer preemptions can
+// explore more interleavings, so the node keeps the fewest, and it returns true if the
+// successors of the node must be explored again.
+func (p *Processor) lowerPreemptions(node *Node, preemptions int) bool {
+	if p.config.GetMaxPreemptions() == 0 || node.preemptions <= preemptions {
+		return false
 	}
-	if node.actionDepth >= int(p.config.Options.MaxActions) ||
-		len(process.Threads) >= int(p.config.Options.MaxConcurrentActions) {
-
-		return
+	node.previousPreemptions = node.preemptions
+	node.preemptions = preemptions
+	if node.Process.FailedAssertion != nil ||
+		(node.Process.HasFailedInvariants() && !p.config.ContinuePathOnInvariantFailures) {
+		// Not explored further in the first place.
+		return false
 	}
-	for i, action := range p.Files[0].Actions {
-		if action.Name == "Init" {
-			continue
-		}
-		if p.config.ActionOptions[action.Name] != nil &&
-			process.Stats.Counts[action.Name] >= int(p.config.ActionOptions[action.Name].MaxActions) {
+	node.reexplored = true
+	if node.boundary {
+		// Marked again if the successors are still cut off.
+		node.boundary = false
+		p.boundaryCount--
+	}
+	return true
+}
+
+// reexploreCrash explores again the successors of the crash node of the node,
+// reached again with fewer preemptions.
+func (p *Processor) reexploreCrash(node *Node) {
+	for _, link := range node.Outbound {
+		if link.Name != "crash" {
 			continue
 		}
-		newNode := node.ForkForAction(process, action)
-		newNode.Process.NewThread()
-		newNode.Process.Current = len(newNode.Process.Threads) - 1
-		newNode.currentThread().currentFrame().pc = fmt.Sprintf("Actions[%d]", i)
-		newNode.currentThread().currentFrame().Name = action.Name
-
-		_ = p.queue.Push(newNode)
+		crashNode := link.Node
+		crashNode.preemptions = node.preemptions
+		crashNode.reexplored = true
+		p.YieldNode(crashNode, yieldPoint{running: -1, next: node.Process.Current})
 	}
 }
 
@@ -11,6 +11,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"testing"
 	"time"
 )
@@ -40,6 +42,30 @@ func TestRemoveCurrentThread(t *testing.T) {
 	assert.Equal(t, 0, p.Current)
 }
 
+// TestProcess_Enable is a unit test for Process.Enable.
+func TestProcess_Enable(t *testing.T) {
+	root := &Process{}
+	p1 := &Process{Threads: []*Thread{{}}, Parent: root}
+	p2 := &Process{Threads: []*Thread{{}}, Parent: p1}
+	p3 := &Process{Threads: []*Thread{{}}, Parent: p2}
+	sibling := &Process{Threads: []*Thread{{}}, Parent: p2}
+
+	p3.Enable()
+	assert.True(t, p3.Enabled)
+	assert.True(t, p2.Enabled)
+	assert.True(t, p1.Enabled)
+	// The walk stops at the process with no threads.
+	assert.False(t, root.Enabled)
+	// The enabled processes release their parents.
+	assert.Nil(t, p3.Parent)
+	assert.Nil(t, p2.Parent)
+	assert.Nil(t, p1.Parent)
+
+	sibling.Enable()
+	assert.True(t, sibling.Enabled)
+	assert.Nil(t, sibling.Parent)
+}
+
 // TestHash is a unit test for Process.Hash.
 func TestHash(t *testing.T) {
 	file, err := parseAstFromString(ActionsWithMultipleBlocks)
@@ -52,16 +78,16 @@ func TestHash(t *testing.T) {
 	thread := process.currentThread()
 	assert.Equal(t, thread.Stack.Len(), 1)
 
-	thread.currentFrame().pc = "Actions[0]"
+	jumpTo(thread, "Actions[0]")
 
 	h1 := process.HashCode()
 	process.removeCurrentThread()
 	assert.NotEqual(t, h1, process.HashCode())
 
-	t0 := NewThread(process, files, 0, "Actions[0]")
-	t1 := NewThread(process, files, 0, "Actions[1]")
-	t2 := NewThread(process, files, 0, "Actions[2]")
-	t3 := NewThread(process, files, 0, "Actions[3]")
+	t0 := NewThread(process, files, 0, pcAt(process.programs[0], "Actions[0]"))
+	t1 := NewThread(process, files, 0, pcAt(process.programs[0], "Actions[1]"))
+	t2 := NewThread(process, files, 0, pcAt(process.programs[0], "Actions[2]"))
+	t3 := NewThread(process, files, 0, pcAt(process.programs[0], "Actions[3]"))
 	p1 := &Process{
 		Threads: []*Thread{
 			t0,
@@ -90,20 +116,553 @@ func TestHash(t *testing.T) {
 	assert.Equal(t, p1.HashCode(), p2.HashCode())
 }
 
+func TestProcess_ViewHashCode(t *testing.T) {
+	newProcess := func(x int, history ...int) *Process {
+		process := NewProcess("", []*ast.File{{}}, nil)
+		values := make([]starlark.Value, len(history))
+		for i, v := range history {
+			values[i] = starlark.MakeInt(v)
+		}
+		process.Heap.globals = starlark.StringDict{"x": starlark.MakeInt(x), "history": starlark.NewList(values)}
+		return process
+	}
+	p1 := newProcess(1, 1)
+	p2 := newProcess(1, 2, 0, 1)
+	assert.NotEqual(t, p1.HashCode(), p2.HashCode())
+	assert.Equal(t, p1.HashCode(), p1.ViewHashCode("", true))
+	assert.Equal(t, p1.ViewHashCode("x", true), p2.ViewHashCode("x", true))
+	assert.NotEqual(t, p1.ViewHashCode("x", true), newProcess(2, 1).ViewHashCode("x", true))
+
+	p2.Returns["Inc"] = starlark.MakeInt(1)
+	assert.NotEqual(t, p1.ViewHashCode("x", true), p2.ViewHashCode("x", true))
+	assert.Equal(t, p1.ViewHashCode("x", false), p2.ViewHashCode("x", false))
+
+	// A view that mutates the globals changes neither the state nor its forks.
+	fork := p2.Fork()
+	hash := p2.ViewHashCode("history.pop()", true)
+	assert.Equal(t, hash, p2.ViewHashCode("history.pop()", true))
+	assert.Equal(t, "[2, 0, 1]", p2.Heap.globals["history"].String())
+	assert.Equal(t, "[2, 0, 1]", fork.Heap.globals["history"].String())
+}
+
+func TestProcessor_View(t *testing.T) {
+	file, err := parseAstFromString(`
+{
+  "states": {
+    "code": "x = 0\nhistory = []"
+  },
+  "actions": [
+    {
+      "name": "Inc",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "x = (x + 1) % 3\nhistory = history + [x]"}}]
+      }
+    }
+  ]
+}
+`)
+	require.Nil(t, err)
+	explore := func(view string) *Processor {
+		p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+			Options: &ast.Options{MaxActions: 6, MaxConcurrentActions: 1},
+			View:    view,
+		})
+		require.Nil(t, err)
+		_, _, err = p1.Start()
+		require.Nil(t, err)
+		return p1
+	}
+	assert.Equal(t, 7, explore("").GetVisitedNodesCount())
+
+	p1 := explore("x")
+	assert.Equal(t, 3, p1.GetVisitedNodesCount())
+	// The nodes still have the full state.
+	nodes, _, _ := GetAllNodes(p1.Init)
+	for _, node := range nodes {
+		assert.Contains(t, node.Heap.globals, "history")
+	}
+}
+
 func TestProcessor_Start(t *testing.T) {
 	file, err := parseAstFromString(ActionsWithMultipleBlocks)
 	require.Nil(t, err)
 	files := []*ast.File{file}
-	p1 := NewProcessor(files, &ast.StateSpaceOptions{
+	p1, err := NewProcessor(files, &ast.StateSpaceOptions{
 		Options: &ast.Options{
 			MaxActions:           1,
 		},
 	})
+	require.Nil(t, err)
 	root, _, _ := p1.Start()
 	assert.NotNil(t, root)
 	assert.Equal(t, 93, len(p1.visited))
 }
 
+func TestProcessor_GetFailures(t *testing.T) {
+	file, err := parseAstFromString(`
+{
+  "states": {
+    "code": "a = 0"
+  },
+  "invariants": [
+    {"name": "BelowTwo", "always": true, "pyExpr": "a < 2"},
+    {"name": "BelowFour", "always": true, "pyExpr": "a < 4"}
+  ],
+  "actions": [
+    {
+      "name": "Inc",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "a = a + 1"}}]
+      }
+    }
+  ]
+}
+`)
+	require.Nil(t, err)
+	files := []*ast.File{file}
+	p1, err := NewProcessor(files, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           6,
+			MaxConcurrentActions: 1,
+		},
+		ReportAllFailures:               true,
+		ContinuePathOnInvariantFailures: true,
+	})
+	require.Nil(t, err)
+	_, _, err = p1.Start()
+	require.Nil(t, err)
+
+	failures := p1.GetFailures()
+	require.Len(t, failures, 2)
+	assert.Equal(t, "BelowTwo", failures[0].Name)
+	assert.Equal(t, 0, failures[0].Invariant.InvariantIndex)
+	assert.Equal(t, "2", failures[0].Node.Heap.globals["a"].String())
+	assert.Equal(t, "BelowFour", failures[1].Name)
+	assert.Equal(t, 1, failures[1].Invariant.InvariantIndex)
+	assert.Equal(t, "4", failures[1].Node.Heap.globals["a"].String())
+
+	nodes, _, _ := GetAllNodes(p1.Init)
+	deadlocks := p1.GetDeadlockFailures(nodes)
+	// Every node from a = 2 fails the invariants, so the node stopped by max actions is not a deadlock.
+	assert.Len(t, deadlocks, 0)
+}
+
+func TestProcessor_Boundary(t *testing.T) {
+	file, err := parseAstFromString(`
+{
+  "states": {
+    "code": "a = 0"
+  },
+  "invariants": [
+    {
+      "name": "ReachesFive",
+      "temporalOperators": ["always", "eventually"],
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"returnStmt": {"pyExpr": "a == 5"}}]
+      },
+      "pyCode": "def ReachesFive():\n  return a == 5\n"
+    }
+  ],
+  "actions": [
+    {
+      "name": "Inc",
+      "flow": "FLOW_ATOMIC",
+      "fairness": {"level": "FAIRNESS_LEVEL_STRONG"},
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "a = a + 1"}}]
+      }
+    }
+  ]
+}
+`)
+	require.Nil(t, err)
+	files := []*ast.File{file}
+	p1, err := NewProcessor(files, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           3,
+			MaxConcurrentActions: 1,
+		},
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+	assert.Equal(t, 1, p1.GetBoundaryNodesCount())
+
+	nodes, deadlock, _ := GetAllNodes(root)
+	assert.Nil(t, deadlock)
+	for _, node := range nodes {
+		assert.Equal(t, node.Heap.globals["a"].String() == "3", node.IsBoundary())
+	}
+
+	// The counter reaches 5 eventually, the node where the exploration was cut off
+	// must not be treated as stuttering forever.
+	_, failedInvariant := CheckStrictLiveness(root)
+	assert.Nil(t, failedInvariant)
+	_, failedInvariant = CheckFastLiveness(nodes)
+	assert.Nil(t, failedInvariant)
+}
+
+const actionOptionsAstJson = `
+{
+  "states": {
+    "code": "a = 0\nb = 0"
+  },
+  "actions": [
+    {
+      "name": "Work",
+      "flow": "FLOW_SERIAL",
+      "block": {
+        "flow": "FLOW_SERIAL",
+        "stmts": [
+          {"pyStmt": {"code": "a = a + 1"}},
+          {"pyStmt": {"code": "a = a - 1"}}
+        ]
+      }
+    },
+    {
+      "name": "Other",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [{"pyStmt": {"code": "b = (b + 1) % 2"}}]
+      }
+    }
+  ]
+}
+`
+
+func TestProcessor_ActionOptions(t *testing.T) {
+	newOptions := func(workOptions *ast.Options) *ast.StateSpaceOptions {
+		return &ast.StateSpaceOptions{
+			Options: &ast.Options{
+				MaxActions:           4,
+				MaxConcurrentActions: 2,
+			},
+			ActionOptions: map[string]*ast.Options{"Work": workOptions},
+		}
+	}
+	countLinks := func(nodes []*Node, name string) int {
+		count := 0
+		for _, node := range nodes {
+			for _, link := range node.Outbound {
+				if link.Name == name {
+					count++
+				}
+			}
+		}
+		return count
+	}
+	t.Run("unknownAction", func(t *testing.T) {
+		file, err := parseAstFromString(actionOptionsAstJson)
+		require.Nil(t, err)
+		options := newOptions(&ast.Options{})
+		options.ActionOptions["Missing"] = &ast.Options{MaxActions: 1}
+		assert.NotNil(t, ValidateOptions([]*ast.File{file}, options))
+		_, err = NewProcessor([]*ast.File{file}, options)
+		assert.NotNil(t, err)
+	})
+	t.Run("invalidFairness", func(t *testing.T) {
+		file, err := parseAstFromString(actionOptionsAstJson)
+		require.Nil(t, err)
+		assert.NotNil(t, ValidateOptions([]*ast.File{file}, newOptions(&ast.Options{Fairness: "always"})))
+	})
+	t.Run("negativeLimits", func(t *testing.T) {
+		file, err := parseAstFromString(actionOptionsAstJson)
+		require.Nil(t, err)
+		files := []*ast.File{file}
+		assert.NotNil(t, ValidateOptions(files, newOptions(&ast.Options{MaxActions: -1})))
+		assert.NotNil(t, ValidateOptions(files, newOptions(&ast.Options{MaxConcurrentActions: -1})))
+		options := newOptions(&ast.Options{})
+		options.MaxPreemptions = -1
+		assert.NotNil(t, ValidateOptions(files, options))
+		options = newOptions(&ast.Options{})
+		options.Options.MaxActions = -1
+		assert.NotNil(t, ValidateOptions(files, options))
+		_, err = NewProcessor(files, options)
+		assert.NotNil(t, err)
+	})
+	t.Run("defaults", func(t *testing.T) {
+		file, err := parseAstFromString(actionOptionsAstJson)
+		require.Nil(t, err)
+		p1, err := NewProcessor([]*ast.File{file}, newOptions(&ast.Options{}))
+		require.Nil(t, err)
+		_, _, err = p1.Start()
+		require.Nil(t, err)
+		nodes, _, _ := GetAllNodes(p1.Init)
+		assert.Greater(t, countLinks(nodes, "crash"), 0)
+		concurrent := false
+		for _, node := range nodes {
+			concurrent = concurrent || len(node.Threads) == 2
+		}
+		assert.True(t, concurrent)
+	})
+	t.Run("maxActions", func(t *testing.T) {
+		// 0 means no limit for the action, only the global max_actions applies.
+		for _, limit := range []int64{0, 1} {
+			file, err := parseAstFromString(actionOptionsAstJson)
+			require.Nil(t, err)
+			p1, err := NewProcessor([]*ast.File{file}, newOptions(&ast.Options{MaxActions: limit}))
+			require.Nil(t, err)
+			_, _, err = p1.Start()
+			require.Nil(t, err)
+			nodes, _, _ := GetAllNodes(p1.Init)
+			maxCount := 0
+			for _, node := range nodes {
+				maxCount = max(maxCount, node.Stats.Counts["Work"])
+			}
+			if limit == 0 {
+				assert.Greater(t, maxCount, 1)
+			} else {
+				assert.Equal(t, 1, maxCount)
+			}
+		}
+	})
+	t.Run("maxConcurrentActionsAndDisableCrash", func(t *testing.T) {
+		file, err := parseAstFromString(actionOptionsAstJson)
+		require.Nil(t, err)
+		p1, err := NewProcessor([]*ast.File{file}, newOptions(&ast.Options{MaxConcurrentActions: 1, DisableCrash: true}))
+		require.Nil(t, err)
+		_, _, err = p1.Start()
+		require.Nil(t, err)
+		nodes, _, _ := GetAllNodes(p1.Init)
+		assert.Equal(t, 0, countLinks(nodes, "crash"))
+		for _, node := range nodes {
+			running := 0
+			for _, thread := range node.Threads {
+				if thread.actionName() == "Work" {
+					running++
+				}
+			}
+			assert.LessOrEqual(t, running, 1)
+		}
+	})
+	t.Run("fairness", func(t *testing.T) {
+		file := &ast.File{}
+		err := protojson.Unmarshal([]byte(fmt.Sprintf(hourClockAstJson, "hour in [6]", "FAIRNESS_LEVEL_UNFAIR")), file)
+		require.Nil(t, err)
+		p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+			Options:       &ast.Options{MaxActions: 100, MaxConcurrentActions: 1},
+			ActionOptions: map[string]*ast.Options{"Tick": {Fairness: "weak"}},
+		})
+		require.Nil(t, err)
+		assert.Equal(t, ast.FairnessLevel_FAIRNESS_LEVEL_WEAK, p1.Files[0].Actions[0].Fairness.Level)
+		// The file of the caller keeps its fairness.
+		assert.Equal(t, ast.FairnessLevel_FAIRNESS_LEVEL_UNFAIR, file.Actions[0].Fairness.Level)
+		root, _, err := p1.Start()
+		require.Nil(t, err)
+		_, failedInvariant := CheckStrictLiveness(root)
+		assert.Nil(t, failedInvariant)
+
+		// Another processor for the same file without the option is not affected.
+		p2, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+			Options: &ast.Options{MaxActions: 100, MaxConcurrentActions: 1},
+		})
+		require.Nil(t, err)
+		root, _, err = p2.Start()
+		require.Nil(t, err)
+		_, failedInvariant = CheckStrictLiveness(root)
+		assert.NotNil(t, failedInvariant)
+	})
+	t.Run("weight", func(t *testing.T) {
+		file, err := parseAstFromString(actionOptionsAstJson)
+		require.Nil(t, err)
+		p1, err := NewProcessor([]*ast.File{file}, newOptions(&ast.Options{Weight: 3}))
+		require.Nil(t, err)
+		_, _, err = p1.Start()
+		require.Nil(t, err)
+		nodes, _, _ := GetAllNodes(p1.Init)
+		matrix := createTransitionMatrix(nodes)
+		require.Equal(t, p1.Init, nodes[0])
+		for _, link := range p1.Init.Outbound {
+			j := slices.Index(nodes, link.Node)
+			if link.Name == "Work" {
+				assert.InDelta(t, 0.75, matrix.Get(0, j), 1e-9)
+			} else {
+				assert.InDelta(t, 0.25, matrix.Get(0, j), 1e-9)
+			}
+		}
+	})
+}
+
+func TestProcessor_MaxPreemptions(t *testing.T) {
+	// Each action takes two steps, so they can interleave at the yield point in between.
+	file, err := parseAstFromString(`
+{
+  "states": {
+    "code": "x = 0\na = 0\nb = 0"
+  },
+  "actions": [
+    {
+      "name": "A",
+      "flow": "FLOW_SERIAL",
+      "block": {
+        "flow": "FLOW_SERIAL",
+        "stmts": [
+          {"pyStmt": {"code": "a = x"}},
+          {"pyStmt": {"code": "x = a + 1"}}
+        ]
+      }
+    },
+    {
+      "name": "B",
+      "flow": "FLOW_SERIAL",
+      "block": {
+        "flow": "FLOW_SERIAL",
+        "stmts": [
+          {"pyStmt": {"code": "b = x"}},
+          {"pyStmt": {"code": "x = b + 1"}}
+        ]
+      }
+    }
+  ]
+}
+`)
+	require.Nil(t, err)
+	explore := func(maxPreemptions int64) *Processor {
+		p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+			Options: &ast.Options{
+				MaxActions:           3,
+				MaxConcurrentActions: 3,
+			},
+			MaxPreemptions: maxPreemptions,
+		})
+		require.Nil(t, err)
+		_, _, err = p1.Start()
+		require.Nil(t, err)
+		return p1
+	}
+	maxThreads := func(p1 *Processor) int {
+		nodes, _, _ := GetAllNodes(p1.Init)
+		count := 0
+		for _, node := range nodes {
+			running := 0
+			for _, thread := range node.Threads {
+				if !thread.isFinishing() {
+					running++
+				}
+			}
+			count = max(count, running)
+			assert.LessOrEqual(t, node.preemptions, int(p1.config.GetMaxPreemptions()))
+		}
+		return count
+	}
+
+	// With one preemption, the other action can start in the middle of the first one.
+	onePreemption := explore(1)
+	assert.Equal(t, 2, maxThreads(onePreemption))
+	assert.Greater(t, onePreemption.GetBoundaryNodesCount(), 0)
+
+	twoPreemptions := explore(2)
+	assert.Less(t, onePreemption.GetVisitedNodesCount(), twoPreemptions.GetVisitedNodesCount())
+
+	// A bound that cuts nothing off explores the same graph as no bound, as the states
+	// reached with different preemption counts are not split.
+	unbounded := explore(0)
+	large := explore(10)
+	assert.Equal(t, unbounded.GetVisitedNodesCount(), large.GetVisitedNodesCount())
+	assert.Equal(t, unbounded.GetBoundaryNodesCount(), large.GetBoundaryNodesCount())
+	assert.LessOrEqual(t, twoPreemptions.GetVisitedNodesCount(), unbounded.GetVisitedNodesCount())
+}
+
+func TestProcessor_Prints(t *testing.T) {
+	file, err := parseAstFromString(`
+{
+  "states": {
+    "code": "x = 0\nprint('init')"
+  },
+  "actions": [
+    {
+      "name": "Inc",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {"pyStmt": {"code": "x = x + 1\nprint('x is', x)"}}
+        ]
+      }
+    }
+  ]
+}
+`)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		Options: &ast.Options{
+			MaxActions:           2,
+			MaxConcurrentActions: 1,
+		},
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+	assert.Equal(t, []string{"init"}, InitNodeToLink(root).Logs)
+
+	nodes, _, _ := GetAllNodes(p1.Init)
+	var logs []string
+	for _, node := range nodes {
+		for _, link := range node.Outbound {
+			logs = append(logs, link.Logs...)
+		}
+	}
+	// Each transition has only the lines printed in it.
+	assert.ElementsMatch(t, []string{"x is 1", "x is 2"}, logs)
+	assert.Contains(t, GenerateDotFile(root, make(map[*Node]bool)), `\nx is 1`)
+}
+
+// BenchmarkProcessor_Comparisons explores the state space of the specs used to compare
+// with the other model checkers, to measure the interpreter overhead per step.
+func BenchmarkProcessor_Comparisons(b *testing.B) {
+	runfilesDir := os.Getenv("RUNFILES_DIR")
+	specs := []string{
+		"examples/comparisons/diehard/DieHard.json",
+		"examples/comparisons/ewd426-token-ring/TokenRing.json",
+	}
+	for _, spec := range specs {
+		b.Run(spec, func(b *testing.B) {
+			file, err := readAstFromFile(filepath.Join(runfilesDir, "_main", spec))
+			require.Nil(b, err)
+			stateConfig, err := ReadOptionsFromYaml(filepath.Join(runfilesDir, "_main", filepath.Dir(spec), "fizz.yaml"))
+			require.Nil(b, err)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p1, err := NewProcessor([]*ast.File{file}, stateConfig)
+				require.Nil(b, err)
+				_, _, err = p1.Start()
+				require.Nil(b, err)
+			}
+		})
+	}
+}
+
+// BenchmarkProcessor_Memory reports the heap retained by the state graph per
+// distinct node, after the exploration completes.
+func BenchmarkProcessor_Memory(b *testing.B) {
+	runfilesDir := os.Getenv("RUNFILES_DIR")
+	spec := "examples/comparisons/ewd426-token-ring/TokenRing.json"
+	file, err := readAstFromFile(filepath.Join(runfilesDir, "_main", spec))
+	require.Nil(b, err)
+	stateConfig, err := ReadOptionsFromYaml(filepath.Join(runfilesDir, "_main", filepath.Dir(spec), "fizz.yaml"))
+	require.Nil(b, err)
+	var before, after runtime.MemStats
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		p1, err := NewProcessor([]*ast.File{file}, stateConfig)
+		require.Nil(b, err)
+		_, _, err = p1.Start()
+		require.Nil(b, err)
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/float64(len(p1.visited)), "B/node")
+		runtime.KeepAlive(p1)
+	}
+}
+
 func printFileNames(rootDir string) error {
 	return filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -532,7 +1091,8 @@ func TestProcessor_Tutorials(t *testing.T) {
 				}
 			}
 
-			p1 := NewProcessor(files, stateConfig)
+			p1, err := NewProcessor(files, stateConfig)
+			require.Nil(t, err)
 			startTime := time.Now()
 			root, _, err := p1.Start()
 			require.Nil(t, err)
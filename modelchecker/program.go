@@ -0,0 +1,235 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"strconv"
+)
+
+// noPc is the program counter of a frame with no instruction to execute.
+const noPc = -1
+
+// Program is a fizz file compiled to a table of instructions, one for each program
+// counter a thread can be at. The program counter of a frame is the index of the
+// instruction in the table, and each instruction has the indices of the instructions
+// the thread goes to from it, so the threads never resolve paths in the ast.
+type Program struct {
+	instructions []*Instruction
+	// actions and functions are the instructions of the actions and the functions
+	// in the order of the file.
+	actions   []int
+	functions []int
+}
+
+// Instruction is an action, a function, a block, a statement or the end of a block.
+type Instruction struct {
+	// Index is the position of the instruction in the program, the program counter.
+	Index int
+	// Pc is the path to the instruction in the ast.File, used only to show the
+	// program counter to the user.
+	Pc string
+	// Message is the ast node of the instruction, or nil at the end of a block.
+	Message proto.Message
+
+	// root is the action or the function the instruction is in.
+	root int
+	// owner is the instruction the innermost block around this one belongs to.
+	// For a block and the end of a block, it is the instruction of the block itself.
+	owner int
+	// stmt is the innermost statement at or around the instruction, or noPc.
+	stmt int
+	// block is the block the statement is in. It is set only for statements.
+	block int
+	// next is the statement after this one in the block, or the end of the block
+	// for the last statement. It is set only for statements.
+	next int
+
+	// body is the block of an action, a function, a branch, a block statement,
+	// an any statement, a for or a while loop, or noPc if there is none.
+	body int
+	// loop is the for or the while loop of a statement, or noPc if there is none.
+	loop int
+	// branches are the blocks of the branches of an if statement.
+	branches []int
+	// stmts are the statements of a block.
+	stmts []int
+	// end is the end of a block.
+	end int
+
+	// endsAction is set at the end of the block of an action, where the thread
+	// has nothing left to run.
+	endsAction bool
+}
+
+// CompilePrograms compiles each of the files.
+func CompilePrograms(files []*ast.File) []*Program {
+	programs := make([]*Program, len(files))
+	for i, file := range files {
+		programs[i] = CompileProgram(file)
+	}
+	return programs
+}
+
+// CompileProgram compiles the actions and the functions in the file.
+func CompileProgram(file *ast.File) *Program {
+	p := &Program{}
+	for i, action := range file.Actions {
+		inst := p.add(fmt.Sprintf("Actions[%d]", i), action, nil)
+		p.actions = append(p.actions, inst.Index)
+		inst.body = p.compileBlock(inst, action.Block)
+		if inst.body != noPc {
+			p.instructions[p.instructions[inst.body].end].endsAction = true
+		}
+	}
+	for i, function := range file.Functions {
+		inst := p.add(fmt.Sprintf("Functions[%d]", i), function, nil)
+		p.functions = append(p.functions, inst.Index)
+		inst.body = p.compileBlock(inst, function.Block)
+	}
+	return p
+}
+
+// compileBlock compiles the block of the owner and its statements, and returns
+// the index of the block, or noPc if there is no block.
+func (p *Program) compileBlock(owner *Instruction, block *ast.Block) int {
+	if block == nil {
+		return noPc
+	}
+	b := p.add(owner.Pc+".Block", block, owner)
+	b.owner = owner.Index
+	for i, stmt := range block.Stmts {
+		b.stmts = append(b.stmts, p.compileStatement(fmt.Sprintf("%s.Stmts[%d]", b.Pc, i), stmt, b))
+	}
+	end := p.add(b.Pc+".$", nil, b)
+	b.end = end.Index
+	for i, stmt := range b.stmts {
+		if i+1 < len(b.stmts) {
+			p.instructions[stmt].next = b.stmts[i+1]
+		} else {
+			p.instructions[stmt].next = b.end
+		}
+	}
+	return b.Index
+}
+
+func (p *Program) compileStatement(pc string, stmt *ast.Statement, block *Instruction) int {
+	inst := p.add(pc, stmt, block)
+	inst.stmt = inst.Index
+	inst.block = block.Index
+	if stmt.Block != nil {
+		inst.body = p.compileBlock(inst, stmt.Block)
+	}
+	if stmt.IfStmt != nil {
+		ifStmt := p.add(pc+".IfStmt", stmt.IfStmt, inst)
+		for i, branch := range stmt.IfStmt.Branches {
+			b := p.add(fmt.Sprintf("%s.Branches[%d]", ifStmt.Pc, i), branch, inst)
+			b.body = p.compileBlock(b, branch.Block)
+			inst.branches = append(inst.branches, b.body)
+		}
+	}
+	if stmt.AnyStmt != nil {
+		anyStmt := p.add(pc+".AnyStmt", stmt.AnyStmt, inst)
+		anyStmt.body = p.compileBlock(anyStmt, stmt.AnyStmt.Block)
+		inst.body = anyStmt.body
+	}
+	if stmt.ForStmt != nil {
+		loop := p.add(pc+".ForStmt", stmt.ForStmt, inst)
+		loop.body = p.compileBlock(loop, stmt.ForStmt.Block)
+		inst.loop = loop.Index
+	}
+	if stmt.WhileStmt != nil {
+		loop := p.add(pc+".WhileStmt", stmt.WhileStmt, inst)
+		loop.body = p.compileBlock(loop, stmt.WhileStmt.Block)
+		inst.loop = loop.Index
+	}
+	return inst.Index
+}
+
+// add appends the instruction, in the action or function, the block and the
+// statement of the parent.
+func (p *Program) add(pc string, message proto.Message, parent *Instruction) *Instruction {
+	inst := &Instruction{
+		Index:   len(p.instructions),
+		Pc:      pc,
+		Message: message,
+		root:    len(p.instructions),
+		owner:   noPc,
+		stmt:    noPc,
+		block:   noPc,
+		next:    noPc,
+		body:    noPc,
+		loop:    noPc,
+		end:     noPc,
+	}
+	if parent != nil {
+		inst.root = parent.root
+		inst.owner = parent.owner
+		inst.stmt = parent.stmt
+	}
+	p.instructions = append(p.instructions, inst)
+	return inst
+}
+
+// Message returns the ast node at the program counter.
+func (p *Program) Message(pc int) proto.Message {
+	if pc == noPc {
+		return nil
+	}
+	return p.instructions[pc].Message
+}
+
+// Path returns the path to the program counter in the ast, or the empty string for noPc.
+func (p *Program) Path(pc int) string {
+	if pc == noPc {
+		return ""
+	}
+	return p.instructions[pc].Pc
+}
+
+// Root returns the action or the function of the program counter.
+func (p *Program) Root(pc int) int {
+	if pc == noPc {
+		return noPc
+	}
+	return p.instructions[pc].root
+}
+
+// Owner returns the instruction the innermost block around pc belongs to.
+// At a block or at the end of a block, it is the instruction of the block.
+func (p *Program) Owner(pc int) int {
+	if pc == noPc {
+		return noPc
+	}
+	return p.instructions[pc].owner
+}
+
+// NextPc returns the statement after the innermost statement at or around pc,
+// or the end of its block.
+func (p *Program) NextPc(pc int) int {
+	return p.statement(pc).next
+}
+
+// EndOfBlock returns the end of the block of the innermost statement at or around pc.
+func (p *Program) EndOfBlock(pc int) int {
+	return p.instructions[p.ParentBlock(pc)].end
+}
+
+// ParentBlock returns the block of the innermost statement at or around pc.
+func (p *Program) ParentBlock(pc int) int {
+	return p.statement(pc).block
+}
+
+func (p *Program) statement(pc int) *Instruction {
+	stmt := p.instructions[pc].stmt
+	if stmt == noPc {
+		panic(fmt.Sprintf("No statement at %s", p.Path(pc)))
+	}
+	return p.instructions[stmt]
+}
+
+// appendPcKey appends the program counter, for hashing.
+func appendPcKey(buf []byte, pc int) []byte {
+	buf = append(buf, '#')
+	return strconv.AppendInt(buf, int64(pc), 10)
+}
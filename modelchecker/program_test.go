@@ -0,0 +1,52 @@
+package modelchecker
+
+import (
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompileProgram(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(os.Getenv("RUNFILES_DIR"), "_main", "examples", "*", "*", "*.json"))
+	require.Nil(t, err)
+	require.NotEmpty(t, files)
+	for _, filename := range files {
+		t.Run(filename, func(t *testing.T) {
+			file, err := readAstFromFile(filename)
+			require.Nil(t, err)
+			program := CompileProgram(file)
+			for i, inst := range program.instructions {
+				// The compiled program must resolve the same as the paths in the ast.
+				assert.Equal(t, i, inst.Index)
+				if inst.Message == nil {
+					assert.Nil(t, GetProtoFieldByPath(file, inst.Pc), inst.Pc)
+					assert.Equal(t, program.Path(program.Owner(i))+".Block.$", inst.Pc)
+				} else {
+					assert.True(t, proto.Equal(inst.Message, GetProtoFieldByPath(file, inst.Pc)), inst.Pc)
+				}
+				if inst.body != noPc {
+					assert.True(t, strings.HasPrefix(program.Path(inst.body), inst.Pc+"."), inst.Pc)
+				}
+				if inst.stmt == i {
+					next, _ := GetNextFieldPath(file, inst.Pc)
+					assert.Equal(t, next, program.Path(program.NextPc(i)), inst.Pc)
+					assert.Equal(t, program.Path(program.ParentBlock(i))+".$", program.Path(program.EndOfBlock(i)), inst.Pc)
+				}
+			}
+		})
+	}
+	t.Run("noPc", func(t *testing.T) {
+		file, err := parseAstFromString(twoStepActionsAstJson)
+		require.Nil(t, err)
+		program := CompileProgram(file)
+		assert.Nil(t, program.Message(noPc))
+		assert.Equal(t, "", program.Path(noPc))
+		assert.Equal(t, noPc, program.Owner(noPc))
+		assert.Equal(t, "Actions[0].Block.Stmts[0]", program.Path(2))
+		assert.Equal(t, "#2", string(appendPcKey(nil, 2)))
+	})
+}
@@ -5,13 +5,10 @@ import (
 	"fmt"
 	"github.com/golang/protobuf/proto"
 	"reflect"
-	"regexp"
 	"strconv"
 	"strings"
 )
 
-var re = regexp.MustCompile(`Stmts\[\d+\]`)
-
 type ProtoPath struct {
 	// TODO(jayaprabhakar): A quick hack, fix this. It is safe because this field is immutable.
 	filesMap map[*ast.File]map[string]proto.Message
@@ -142,68 +139,3 @@ func GetNextFieldPath(msg proto.Message, path string) (string, *reflect.Value) {
 	}
 	return "", nil
 }
-
-func ParentBlockPath(path string) string {
-	lastIndex := strings.LastIndex(path, ".Block")
-	if lastIndex == -1 {
-		return ""
-	}
-	return path[:lastIndex] + ".Block"
-}
-
-func RemoveLastBlock(path string) string {
-	return RemoveLastSegment(path, ".Block")
-}
-
-func RemoveLastForStmt(path string) string {
-	return RemoveLastSegment(path, ".ForStmt")
-}
-
-func RemoveLastWhileStmt(path string) string {
-	return RemoveLastSegment(path, ".WhileStmt")
-}
-
-func RemoveLastLoop(path string) string {
-	if strings.LastIndex(path, ".ForStmt") > strings.LastIndex(path, ".WhileStmt") {
-		return RemoveLastSegment(path, ".ForStmt")
-	} else {
-		return RemoveLastSegment(path, ".WhileStmt")
-	}
-}
-
-func RemoveLastLoopBlock(path string) string {
-	if strings.LastIndex(path, ".ForStmt") > strings.LastIndex(path, ".WhileStmt") {
-		newPath := RemoveLastSegment(path, ".ForStmt")
-		return fmt.Sprintf("%s.ForStmt.Block.$", newPath)
-	} else {
-		newPath := RemoveLastSegment(path, ".WhileStmt")
-		return fmt.Sprintf("%s.WhileStmt.Block.$", newPath)
-	}
-
-}
-
-func RemoveLastSegment(path string, substr string) string {
-	lastIndex := strings.LastIndex(path, substr)
-	if lastIndex == -1 {
-		return ""
-	}
-	return path[:lastIndex]
-}
-
-func EndOfBlock(path string) string {
-	return replaceLastStmts(path, "$")
-}
-
-func replaceLastStmts(input, replacement string) string {
-
-	matches := re.FindAllStringIndex(input, -1)
-
-	if matches == nil {
-		// Pattern not found
-		return input
-	}
-
-	lastMatch := matches[len(matches)-1]
-	result := input[:lastMatch[0]] + replacement + input[lastMatch[1]:]
-	return result
-}
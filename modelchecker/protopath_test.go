@@ -72,11 +72,6 @@ func TestGetNextPath(t *testing.T) {
 	assert.Equal(t, "Actions[0].Block.Stmts[0].AnyStmt.Block.Stmts[0].IfStmt.Branches[0].Block.$", path)
 }
 
-func TestEndOfBlock(t *testing.T) {
-	assert.Equal(t, "Actions[0].Block.Stmts[0].AnyStmt.Block.Stmts[0].IfStmt.Branches[0].Block.$",
-		EndOfBlock("Actions[0].Block.Stmts[0].AnyStmt.Block.Stmts[0].IfStmt.Branches[0].Block.Stmts[0]"))
-}
-
 func readFileToAst() (*ast.File, error) {
 	jsonFile := `
 {
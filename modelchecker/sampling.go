@@ -0,0 +1,189 @@
+package modelchecker
+
+import (
+	"fizz/proto"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+const (
+	// costSamples is the number of paths sampled for the cost distributions.
+	costSamples = 10000
+	// costSamplingSeed is the seed of the sampling, so the reports are reproducible.
+	costSamplingSeed = 1
+	// maxSampledPathLength is the maximum number of transitions of a sampled path.
+	// The longer paths are counted as truncated, and excluded from the distributions.
+	maxSampledPathLength = 100000
+)
+
+// SampledCosts has the distributions of the counters accumulated along the paths
+// of the Markov chain until absorption, estimated by sampling the paths.
+type SampledCosts struct {
+	// Samples is the number of sampled paths that reached an absorbing node.
+	Samples int `json:"samples"`
+	// Truncated is the number of sampled paths that did not reach an absorbing node
+	// within maxSampledPathLength transitions.
+	Truncated int `json:"truncated"`
+	// NotAbsorbed is the number of sampled paths that reached a node from which no
+	// absorbing node is reachable. They are stopped there, as they would only be
+	// truncated, and excluded from the distributions. If no absorbing node is
+	// reachable from any initial node, the paths are not sampled and all of them
+	// are counted here.
+	NotAbsorbed int `json:"not_absorbed"`
+	// Counters has the distribution of each counter on the absorbed paths.
+	Counters map[string]*SampledCounter `json:"counters"`
+}
+
+// SampledCounter has the statistics of a counter over the sampled paths.
+type SampledCounter struct {
+	Mean        float64            `json:"mean"`
+	StdDev      float64            `json:"stddev"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+// SampleAbsorptionCosts samples the costs to reach the nodes where the invariant
+// holds, with the same transition matrix and initial distribution as FindAbsorptionCosts.
+// Unlike the expected values, the distributions depend on the distributions of the
+// counters, and not only their means.
+func SampleAbsorptionCosts(root *Node, perfModel *proto.PerformanceModel, fileId int, invariantId int, rng *rand.Rand) *SampledCosts {
+	nodes, _, yields := getAllNodes(root)
+	transitionMatrix := createAbsorptionTransitionMatrix(nodes, fileId, invariantId)
+	initialDistribution := absorptionInitialDistribution(nodes, yields)
+	return sampleChainCosts(transitionMatrix, genLinkCounters(nodes, perfModel), initialDistribution, costSamples, rng)
+}
+
+// sampleSteadyStateCosts samples the costs until the chain starting at the root node
+// reaches a node without outbound links.
+func sampleSteadyStateCosts(root *Node, perfModel *proto.PerformanceModel, rng *rand.Rand) *SampledCosts {
+	nodes, _, _ := getAllNodes(root)
+	initialDistribution := make([]float64, len(nodes))
+	initialDistribution[0] = 1.0
+	transitionMatrix := genTransitionMatrix(nodes, perfModel)
+	return sampleChainCosts(transitionMatrix, genLinkCounters(nodes, perfModel), initialDistribution, costSamples, rng)
+}
+
+// sampleChainCosts samples paths from the initial distribution until they reach an
+// absorbing node, a node with only a self loop, and sums the counters sampled on
+// each transition of the path. It returns nil if there are no counters.
+// The paths stop at the nodes that cannot reach an absorbing node, as found by
+// absorbingReachability, so the recurrent chains are not run for every sample.
+func sampleChainCosts(transitionMatrix *SparseMatrix, linkCounters map[int][]linkCounter,
+	initialDistribution []float64, samples int, rng *rand.Rand) *SampledCosts {
+
+	n := transitionMatrix.N()
+	names := make(map[string]bool)
+	for _, counters := range linkCounters {
+		for _, c := range counters {
+			names[c.name] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	values := make(map[string][]float64)
+	for name := range names {
+		values[name] = make([]float64, 0, samples)
+	}
+
+	result := &SampledCosts{Counters: make(map[string]*SampledCounter)}
+	absorbing, escaping := absorbingReachability(transitionMatrix, transitionMatrix.Transpose())
+
+	// The initial distribution has an entry for every node, so the start node is
+	// found with a binary search on the cumulative distribution.
+	cumulative := make([]float64, n)
+	total := 0.0
+	reachable := false
+	for i, p := range initialDistribution {
+		total += p
+		cumulative[i] = total
+		reachable = reachable || (p > 0 && (absorbing[i] || escaping[i]))
+	}
+	if !reachable {
+		result.NotAbsorbed = samples
+		return result
+	}
+
+	totals := make(map[string]float64)
+	for s := 0; s < samples; s++ {
+		for name := range totals {
+			totals[name] = 0
+		}
+		r := rng.Float64() * total
+		i := sort.Search(n, func(k int) bool { return cumulative[k] > r })
+		absorbed, escaped := false, true
+		for step := 0; step < maxSampledPathLength; step++ {
+			if absorbing[i] {
+				absorbed = true
+				break
+			}
+			if !escaping[i] {
+				escaped = false
+				break
+			}
+			cols, probs := transitionMatrix.Row(i)
+			j := cols[sampleIndex(probs, rng)]
+			for _, c := range linkCounters[i*n+j] {
+				totals[c.name] += sampleCounter(c.counter, rng)
+			}
+			i = j
+		}
+		if !escaped {
+			result.NotAbsorbed++
+			continue
+		}
+		if !absorbed {
+			result.Truncated++
+			continue
+		}
+		result.Samples++
+		for name := range names {
+			values[name] = append(values[name], totals[name])
+		}
+	}
+	if result.Samples == 0 {
+		return result
+	}
+	for name, v := range values {
+		result.Counters[name] = newSampledCounter(v)
+	}
+	return result
+}
+
+// sampleIndex returns a random index with a probability proportional to the weight
+// at the index. The weights need not sum to 1.
+func sampleIndex(weights []float64, rng *rand.Rand) int {
+	r := rng.Float64() * sum(weights)
+	last := 0
+	for i, w := range weights {
+		if w <= 0 {
+			continue
+		}
+		r -= w
+		last = i
+		if r < 0 {
+			return i
+		}
+	}
+	return last
+}
+
+func newSampledCounter(values []float64) *SampledCounter {
+	sort.Float64s(values)
+	mean := sum(values) / float64(len(values))
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	counter := &SampledCounter{
+		Mean:        mean,
+		StdDev:      math.Sqrt(variance / float64(len(values))),
+		Percentiles: make(map[string]float64),
+	}
+	for _, percentile := range reportPercentiles {
+		// The nearest rank percentile.
+		rank := int(math.Ceil(percentile.p*float64(len(values)))) - 1
+		counter.Percentiles[percentile.name] = values[max(rank, 0)]
+	}
+	return counter
+}
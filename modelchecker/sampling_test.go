@@ -0,0 +1,81 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"math/rand"
+	"testing"
+)
+
+func TestSampleAbsorptionCosts(t *testing.T) {
+	file, err := parseAstFromString(labeledMarkovChainAstJson)
+	require.Nil(t, err)
+	p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+		ContinuePathOnInvariantFailures: true,
+		ContinueOnInvariantFailures:     true,
+		Options:                         &ast.Options{MaxActions: 10, MaxConcurrentActions: 1},
+	})
+	require.Nil(t, err)
+	root, _, err := p1.Start()
+	require.Nil(t, err)
+	perfModel := &ast.PerformanceModel{}
+	require.Nil(t, protojson.Unmarshal([]byte(`{"configs": {
+		"Prepare.prepare": {"counters": {"work": {"numeric": 1}, "latency": {"exponential": {"rate": 0.5}}}},
+		"Commit.commit": {"counters": {"work": {"numeric": 10}, "latency": {"uniform": {"min": 0, "max": 10}}}}
+	}}`), perfModel))
+
+	// The expected values use the means of the distributions, 2 for the latency
+	// of prepare and 5 for commit.
+	result := SolveAbsorptionCosts(root, perfModel, 0, 0)
+	assert.InDelta(t, (2*2+5+1*2+5)/4.0, result.Counters["latency"], 1e-9)
+
+	// The sampled paths start at each of the 3 states with the same probability.
+	// The work is 12 on average from in_progress, 11 from prepared and 0 from committed.
+	sampled := SampleAbsorptionCosts(root, perfModel, 0, 0, rand.New(rand.NewSource(1)))
+	require.NotNil(t, sampled)
+	assert.Equal(t, costSamples, sampled.Samples)
+	assert.Equal(t, 0, sampled.Truncated)
+	work := sampled.Counters["work"]
+	assert.InDelta(t, (12+11)/3.0, work.Mean, 0.2)
+	// Each time the chain goes back to work, it costs one more prepare. The work
+	// is at most 12 with probability 7/8, and at most 13 with probability 15/16.
+	assert.Equal(t, 13.0, work.Percentiles["p90"])
+	assert.LessOrEqual(t, work.Percentiles["p50"], work.Percentiles["p90"])
+	assert.LessOrEqual(t, work.Percentiles["p90"], work.Percentiles["p99"])
+	latency := sampled.Counters["latency"]
+	assert.InDelta(t, 4*result.Counters["latency"]/3, latency.Mean, 0.2)
+	assert.Greater(t, latency.StdDev, 0.0)
+
+	// The same seed gives the same samples.
+	again := SampleAbsorptionCosts(root, perfModel, 0, 0, rand.New(rand.NewSource(1)))
+	assert.Equal(t, sampled, again)
+}
+
+func TestSampleChainCosts_NotAbsorbed(t *testing.T) {
+	// From 0, the chain is absorbed in 1 or goes to the cycle between 2 and 3.
+	builder := newSparseBuilder(4)
+	builder.Add(0, 1, 0.5)
+	builder.Add(0, 2, 0.5)
+	builder.Add(1, 1, 1.0)
+	builder.Add(2, 3, 1.0)
+	builder.Add(3, 2, 1.0)
+	transitionMatrix := builder.Build()
+	linkCounters := map[int][]linkCounter{
+		0*4 + 1: {{name: "work", counter: &ast.Counter{Numeric: 1}}},
+		0*4 + 2: {{name: "work", counter: &ast.Counter{Numeric: 2}}},
+	}
+
+	sampled := sampleChainCosts(transitionMatrix, linkCounters, []float64{1, 0, 0, 0}, 1000, rand.New(rand.NewSource(1)))
+	assert.Equal(t, 0, sampled.Truncated)
+	assert.Equal(t, 1000, sampled.Samples+sampled.NotAbsorbed)
+	assert.InDelta(t, 500, sampled.NotAbsorbed, 100)
+	assert.Equal(t, 1.0, sampled.Counters["work"].Mean)
+
+	// No absorbing node is reachable from the cycle, so no path is sampled.
+	sampled = sampleChainCosts(transitionMatrix, linkCounters, []float64{0, 0, 1, 0}, 1000, rand.New(rand.NewSource(1)))
+	assert.Equal(t, 0, sampled.Samples)
+	assert.Equal(t, 1000, sampled.NotAbsorbed)
+	assert.Empty(t, sampled.Counters)
+}
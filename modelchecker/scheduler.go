@@ -0,0 +1,287 @@
+package modelchecker
+
+import (
+	ast "fizz/proto"
+	"fmt"
+	"slices"
+)
+
+// The values of the scheduler option, the policy to choose which of the runnable
+// threads and the new actions can run next at a yield point.
+const (
+	// SchedulerNondeterministic lets any runnable thread or new action run next.
+	// This is the default.
+	SchedulerNondeterministic = "nondeterministic"
+
+	// SchedulerRoundRobin runs the threads in turn, in the order they were started.
+	// A new action is started only at the end of a round, after the last thread ran.
+	SchedulerRoundRobin = "round_robin"
+
+	// SchedulerPriority runs only the threads and the new actions with the highest
+	// priority set in the action_options among the enabled ones, like an executor
+	// that always picks the most important task that is ready.
+	SchedulerPriority = "priority"
+)
+
+var schedulers = map[string]bool{
+	"":                        true,
+	SchedulerNondeterministic: true,
+	SchedulerRoundRobin:       true,
+	SchedulerPriority:         true,
+}
+
+// yieldPoint identifies the thread that just yielded.
+type yieldPoint struct {
+	// running is the index of the thread that yielded, or -1 if it finished.
+	running int
+	// next is the index of the thread after it in the round robin order.
+	next int
+}
+
+// newYieldPoint returns the yield point of the thread at index current in the process.
+// threadCount is the number of threads before the thread was executed.
+func newYieldPoint(process *Process, threadCount int, current int) yieldPoint {
+	if len(process.Threads) < threadCount {
+		// The thread finished, so the threads after it moved up by one.
+		return yieldPoint{running: -1, next: current}
+	}
+	if process.Threads[current].isFinishing() {
+		// The thread has nothing left to run, so switching to another one is not a preemption.
+		return yieldPoint{running: -1, next: current + 1}
+	}
+	return yieldPoint{running: current, next: current + 1}
+}
+
+// candidate is a thread or a new action that can be scheduled at a yield point.
+type candidate struct {
+	// thread is the index of the runnable thread, or -1 for a new action.
+	thread int
+	// action is the index of the new action in the file.
+	action     int
+	actionName string
+}
+
+// candidates returns the threads and the new actions that can run next in the process
+// after the thread at yp yielded, as allowed by the scheduler and the limits.
+func (p *Processor) candidates(node *Node, process *Process, yp yieldPoint) []candidate {
+	policy := p.config.GetScheduler()
+	threads := make([]candidate, 0, len(process.Threads))
+	var finishing []candidate
+	for i, thread := range process.Threads {
+		if thread.currentFrame().pc == noPc {
+			continue
+		}
+		c := candidate{thread: i, actionName: thread.actionName()}
+		if policy != "" && policy != SchedulerNondeterministic && thread.isFinishing() {
+			// Removing a finished thread does not execute any statement, so the link
+			// is not part of the graph, and the scheduler must not wait for it.
+			finishing = append(finishing, c)
+			continue
+		}
+		threads = append(threads, c)
+	}
+	startActions := true
+	if policy == SchedulerRoundRobin {
+		threads, startActions = roundRobin(threads, yp.next)
+	}
+	result := threads
+	if startActions {
+		result = append(result, p.newActions(node, process)...)
+	}
+	if policy == SchedulerPriority {
+		result = p.highestPriority(process, result)
+	}
+
+	scheduled := result[:0]
+	for _, c := range result {
+		if c.thread == yp.running || p.canPreempt(node, yp.running) {
+			scheduled = append(scheduled, c)
+		}
+	}
+	return append(scheduled, finishing...)
+}
+
+// newActions returns the actions that can be started as a new thread in the process.
+func (p *Processor) newActions(node *Node, process *Process) []candidate {
+	if node.actionDepth >= int(p.config.Options.MaxActions) {
+		p.markBoundary(node)
+		return nil
+	}
+	if len(process.Threads) >= int(p.config.Options.MaxConcurrentActions) {
+		return nil
+	}
+	var result []candidate
+	for i, action := range p.Files[0].Actions {
+		if action.Name == "Init" || !p.canStartAction(node, process, action) {
+			continue
+		}
+		result = append(result, candidate{thread: -1, action: i, actionName: action.Name})
+	}
+	return result
+}
+
+// roundRobin returns the first runnable thread at or after the index next. If there is
+// none, the round is over, so either the first thread runs again or a new action starts,
+// indicated by the second return value.
+func roundRobin(threads []candidate, next int) ([]candidate, bool) {
+	for _, c := range threads {
+		if c.thread >= next {
+			return []candidate{c}, false
+		}
+	}
+	if len(threads) > 0 {
+		return threads[:1], true
+	}
+	return threads, true
+}
+
+// highestPriority returns the candidates with the highest priority among the ones
+// that make progress in the process, that is, execute at least one statement. A
+// disabled action gives way to the lower ones, like an executor skipping the tasks
+// that are not ready. If none of them make progress, all of them are returned, so
+// they are reported as disabled.
+func (p *Processor) highestPriority(process *Process, candidates []candidate) []candidate {
+	levels := make(map[int64][]candidate)
+	priorities := make([]int64, 0)
+	for _, c := range candidates {
+		priority := p.config.ActionOptions[c.actionName].GetPriority()
+		if _, ok := levels[priority]; !ok {
+			priorities = append(priorities, priority)
+		}
+		levels[priority] = append(levels[priority], c)
+	}
+	slices.Sort(priorities)
+	for i := len(priorities) - 1; i >= 0; i-- {
+		level := levels[priorities[i]]
+		for _, c := range level {
+			if p.makesProgress(process, c) {
+				return level
+			}
+		}
+	}
+	return candidates
+}
+
+// makesProgress returns true if scheduling the candidate in the process executes
+// a statement before the thread yields, on any of the paths it can take. It runs
+// the candidate on a copy of the process, detached from the graph, so the statements
+// of every candidate tried are executed once more than without priorities. The
+// output of print() is dropped, as the copy is not a state of the model.
+func (p *Processor) makesProgress(process *Process, c candidate) bool {
+	defer process.Evaluator.silencePrints()()
+	trial := process.Fork()
+	// Enabling the copy must not enable the processes in the graph.
+	trial.Parent = nil
+	if c.thread >= 0 {
+		trial.Current = c.thread
+	} else {
+		startThread(trial, c.action, p.Files[0].Actions[c.action])
+	}
+	pending := []*Process{trial}
+	seen := make(map[string]bool)
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		forks, yield := current.currentThread().Execute()
+		if current.Enabled {
+			return true
+		}
+		for _, fork := range forks {
+			if fork.Enabled {
+				return true
+			}
+			if hash := fork.HashCode(); !yield && !seen[hash] {
+				seen[hash] = true
+				pending = append(pending, fork)
+			}
+		}
+	}
+	return false
+}
+
+// startThread starts a new thread in the process, running the action at index i,
+// and makes it the current thread.
+func startThread(process *Process, i int, action *ast.Action) {
+	process.NewThread()
+	process.Current = len(process.Threads) - 1
+	process.currentThread().currentFrame().pc = process.currentThread().program().actions[i]
+	process.currentThread().currentFrame().Name = action.Name
+}
+
+// canStartAction returns false if a new thread for the action cannot be started
+// because of the per action limits in the action_options.
+func (p *Processor) canStartAction(node *Node, process *Process, action *ast.Action) bool {
+	options := p.config.ActionOptions[action.Name]
+	if options.GetMaxActions() > 0 && process.Stats.Counts[action.Name] >= int(options.GetMaxActions()) {
+		p.markBoundary(node)
+		return false
+	}
+	if options.GetMaxConcurrentActions() > 0 {
+		running := 0
+		for _, thread := range process.Threads {
+			if thread.actionName() == action.Name {
+				running++
+			}
+		}
+		if running >= int(options.GetMaxConcurrentActions()) {
+			return false
+		}
+	}
+	return true
+}
+
+// canPreempt returns true if scheduling a thread other than the running one, or a
+// new action, from the node is within the max_preemptions limit.
+// running is the index of the thread that yielded, or -1 if it finished, in which
+// case switching to any other thread is not a preemption.
+func (p *Processor) canPreempt(node *Node, running int) bool {
+	if running < 0 || p.config.GetMaxPreemptions() == 0 ||
+		node.preemptions < int(p.config.GetMaxPreemptions()) {
+		return true
+	}
+	p.markBoundary(node)
+	return false
+}
+
+// wasScheduled returns true if the thread at index scheduled (or -1 for a new action)
+// was already scheduled from the node before it was reached with fewer preemptions,
+// that is, when its children were scheduled with the previous preemption count.
+func (p *Processor) wasScheduled(node *Node, running int, scheduled int) bool {
+	return running < 0 || running == scheduled || p.config.GetMaxPreemptions() == 0 ||
+		node.previousPreemptions < int(p.config.GetMaxPreemptions())
+}
+
+// schedule sets the preemption count of the new node, where the thread at index
+// scheduled (or -1 for a new action) was picked after the running thread yielded.
+func (n *Node) schedule(running int, scheduled int) {
+	if running >= 0 && running != scheduled {
+		n.preemptions++
+	}
+}
+
+// YieldNode schedules the threads and the new actions that can run after a thread
+// in the node yielded.
+func (p *Processor) YieldNode(node *Node, yp yieldPoint) {
+	p.YieldFork(node, node.Process, yp)
+}
+
+// YieldFork is the same as YieldNode, for the process forked at the yield point.
+func (p *Processor) YieldFork(node *Node, process *Process, yp yieldPoint) {
+	for _, c := range p.candidates(node, process, yp) {
+		var newNode *Node
+		if c.thread >= 0 {
+			thread := process.Threads[c.thread]
+			newNode = node.ForkForAlternatePaths(thread.Process.Fork(), fmt.Sprintf("thread-%d", c.thread))
+			newNode.Current = c.thread
+		} else {
+			action := p.Files[0].Actions[c.action]
+			newNode = node.ForkForAction(process, action)
+			newNode.Inbound[0].Weight = p.config.ActionOptions[action.Name].GetWeight()
+			startThread(newNode.Process, c.action, action)
+		}
+		newNode.schedule(yp.running, c.thread)
+		newNode.linked = node.reexplored && p.wasScheduled(node, yp.running, c.thread)
+
+		p.enqueue(newNode)
+	}
+}
@@ -0,0 +1,218 @@
+package modelchecker
+
+import (
+	"bytes"
+	ast "fizz/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.starlark.net/starlark"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Two actions taking two steps each, so they can interleave at the yield point in between.
+const twoStepActionsAstJson = `
+{
+  "states": {
+    "code": "x = 0\na = 0\nb = 0"
+  },
+  "actions": [
+    {
+      "name": "A",
+      "flow": "FLOW_SERIAL",
+      "block": {
+        "flow": "FLOW_SERIAL",
+        "stmts": [
+          {"pyStmt": {"code": "a = x"}},
+          {"pyStmt": {"code": "x = a + 1"}}
+        ]
+      }
+    },
+    {
+      "name": "B",
+      "flow": "FLOW_SERIAL",
+      "block": {
+        "flow": "FLOW_SERIAL",
+        "stmts": [
+          {"pyStmt": {"code": "b = x"}},
+          {"pyStmt": {"code": "x = b + 1"}}
+        ]
+      }
+    }
+  ]
+}
+`
+
+func TestProcessor_Scheduler(t *testing.T) {
+	explore := func(scheduler string, actionOptions map[string]*ast.Options) []*Node {
+		file, err := parseAstFromString(twoStepActionsAstJson)
+		require.Nil(t, err)
+		p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+			Options: &ast.Options{
+				MaxActions:           3,
+				MaxConcurrentActions: 2,
+			},
+			ActionOptions: actionOptions,
+			Scheduler:     scheduler,
+		})
+		require.Nil(t, err)
+		_, _, err = p1.Start()
+		require.Nil(t, err)
+		nodes, _, _ := GetAllNodes(p1.Init)
+		return nodes
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		file, err := parseAstFromString(twoStepActionsAstJson)
+		require.Nil(t, err)
+		assert.NotNil(t, ValidateOptions([]*ast.File{file}, &ast.StateSpaceOptions{Scheduler: "fifo"}))
+	})
+	t.Run("roundRobin", func(t *testing.T) {
+		nondeterministic := explore(SchedulerNondeterministic, nil)
+		roundRobin := explore(SchedulerRoundRobin, nil)
+		assert.Less(t, len(roundRobin), len(nondeterministic))
+		for _, node := range roundRobin {
+			// Exactly one thread is picked to continue at every yield point,
+			// and a new action does not start while a thread later in the round is runnable.
+			// The threads at the end of their action can exit at any time.
+			var threadLinks []string
+			actionLinks := 0
+			for _, link := range node.Outbound {
+				if link.Name == "A" || link.Name == "B" {
+					actionLinks++
+				} else if i, ok := threadIndex(link); ok && !node.Threads[i].isFinishing() {
+					threadLinks = append(threadLinks, link.Name)
+				}
+			}
+			assert.LessOrEqual(t, len(threadLinks), 1)
+			if len(threadLinks) == 1 && actionLinks > 0 {
+				assert.Equal(t, "thread-0", threadLinks[0])
+			}
+		}
+	})
+	t.Run("priority", func(t *testing.T) {
+		nodes := explore(SchedulerPriority, map[string]*ast.Options{
+			"A": {Priority: 1, MaxActions: 1},
+		})
+		started := false
+		for _, node := range nodes {
+			for _, link := range node.Outbound {
+				if link.Name == "B" {
+					// B starts only when A cannot be started anymore, and is not running.
+					assert.Equal(t, 1, node.Stats.Counts["A"])
+					for _, thread := range node.Threads {
+						assert.True(t, thread.actionName() != "A" || thread.isFinishing())
+					}
+					started = true
+				}
+			}
+		}
+		assert.True(t, started)
+	})
+	t.Run("priorityDisabled", func(t *testing.T) {
+		file, err := parseAstFromString(readyWorkAstJson)
+		require.Nil(t, err)
+		p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+			Options:       &ast.Options{MaxActions: 5, MaxConcurrentActions: 1},
+			ActionOptions: map[string]*ast.Options{"Ready": {Priority: 1}},
+			Scheduler:     SchedulerPriority,
+		})
+		require.Nil(t, err)
+		_, _, err = p1.Start()
+		require.Nil(t, err)
+		nodes, _, _ := GetAllNodes(p1.Init)
+		linkNames := make(map[string][]string)
+		for _, node := range nodes {
+			x := node.Heap.globals["x"].String()
+			for _, link := range node.Outbound {
+				linkNames[x] = append(linkNames[x], link.Name)
+			}
+		}
+		// Ready is disabled until x is 2, so Work runs instead, and only Ready runs once it is enabled.
+		assert.Equal(t, []string{"Work"}, linkNames["0"])
+		assert.Equal(t, []string{"Work"}, linkNames["1"])
+		assert.Equal(t, []string{"Ready"}, linkNames["2"])
+	})
+	t.Run("priorityTrialIsSilent", func(t *testing.T) {
+		file, err := parseAstFromString(strings.Replace(readyWorkAstJson, `"x = x + 1"`, `"print('work')\nx = x + 1"`, 1))
+		require.Nil(t, err)
+		p1, err := NewProcessor([]*ast.File{file}, &ast.StateSpaceOptions{
+			Options:   &ast.Options{MaxActions: 5, MaxConcurrentActions: 1},
+			Scheduler: SchedulerPriority,
+		})
+		require.Nil(t, err)
+		process := NewProcess("test", []*ast.File{file}, nil)
+		process.Heap.globals = starlark.StringDict{"x": starlark.MakeInt(0)}
+		var echoed bytes.Buffer
+		process.Evaluator.EchoPrints(&echoed)
+		var logs []string
+		defer process.Evaluator.capturePrints(&logs)()
+
+		// Work is tried on a copy, which prints nothing and leaves the process unchanged.
+		assert.True(t, p1.makesProgress(process, candidate{thread: -1, action: 1, actionName: "Work"}))
+		assert.Empty(t, echoed.String())
+		assert.Empty(t, logs)
+		assert.Equal(t, "0", process.Heap.globals["x"].String())
+	})
+}
+
+// Ready has the higher priority, but is enabled only when x is 2.
+const readyWorkAstJson = `
+{
+  "states": {
+    "code": "x = 0"
+  },
+  "actions": [
+    {
+      "name": "Ready",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {
+            "ifStmt": {
+              "flow": "FLOW_ATOMIC",
+              "branches": [
+                {
+                  "condition": "x == 2",
+                  "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "x = 10"}}]}
+                }
+              ]
+            }
+          }
+        ]
+      }
+    },
+    {
+      "name": "Work",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {
+            "ifStmt": {
+              "flow": "FLOW_ATOMIC",
+              "branches": [
+                {
+                  "condition": "x < 3",
+                  "block": {"flow": "FLOW_ATOMIC", "stmts": [{"pyStmt": {"code": "x = x + 1"}}]}
+                }
+              ]
+            }
+          }
+        ]
+      }
+    }
+  ]
+}
+`
+
+func threadIndex(link *Link) (int, bool) {
+	index, found := strings.CutPrefix(link.Name, "thread-")
+	if !found {
+		return 0, false
+	}
+	i, err := strconv.Atoi(index)
+	return i, err == nil
+}
@@ -0,0 +1,185 @@
+package modelchecker
+
+import (
+	"sort"
+)
+
+// SparseMatrix is a square matrix in the compressed sparse row (CSR) format.
+// The matrices of the state graph have an entry for each link, so they take
+// O(nodes + links) memory, and a matrix-vector product takes O(links) time,
+// instead of O(nodes²) for a dense matrix.
+type SparseMatrix struct {
+	n int
+	// rowStart[i] is the index in cols and values of the first entry of the row i,
+	// and rowStart[n] is the number of entries. The entries of a row are sorted by column.
+	rowStart []int
+	cols     []int
+	values   []float64
+}
+
+// sparseBuilder collects the entries of a sparse matrix in any order.
+// The values added to the same cell are summed.
+type sparseBuilder struct {
+	n       int
+	entries []sparseEntry
+}
+
+type sparseEntry struct {
+	row   int
+	col   int
+	value float64
+}
+
+func newSparseBuilder(n int) *sparseBuilder {
+	return &sparseBuilder{n: n}
+}
+
+func (b *sparseBuilder) Add(i, j int, value float64) {
+	b.entries = append(b.entries, sparseEntry{row: i, col: j, value: value})
+}
+
+func (b *sparseBuilder) Build() *SparseMatrix {
+	sort.Slice(b.entries, func(x, y int) bool {
+		if b.entries[x].row != b.entries[y].row {
+			return b.entries[x].row < b.entries[y].row
+		}
+		return b.entries[x].col < b.entries[y].col
+	})
+	m := &SparseMatrix{n: b.n, rowStart: make([]int, b.n+1)}
+	for k, entry := range b.entries {
+		if k > 0 && entry.row == b.entries[k-1].row && entry.col == b.entries[k-1].col {
+			m.values[len(m.values)-1] += entry.value
+			continue
+		}
+		m.cols = append(m.cols, entry.col)
+		m.values = append(m.values, entry.value)
+		m.rowStart[entry.row+1]++
+	}
+	for i := 0; i < b.n; i++ {
+		m.rowStart[i+1] += m.rowStart[i]
+	}
+	return m
+}
+
+// N returns the number of rows, and columns, of the matrix.
+func (m *SparseMatrix) N() int {
+	return m.n
+}
+
+// Row returns the columns and the values of the entries in the row i.
+// The returned slices must not be modified.
+func (m *SparseMatrix) Row(i int) ([]int, []float64) {
+	start, end := m.rowStart[i], m.rowStart[i+1]
+	return m.cols[start:end], m.values[start:end]
+}
+
+// Get returns the value at the row i and the column j.
+func (m *SparseMatrix) Get(i, j int) float64 {
+	cols, values := m.Row(i)
+	k := sort.SearchInts(cols, j)
+	if k < len(cols) && cols[k] == j {
+		return values[k]
+	}
+	return 0
+}
+
+// MulVec returns the product of the matrix and the column vector x.
+func (m *SparseMatrix) MulVec(x []float64) []float64 {
+	result := make([]float64, m.n)
+	for i := range result {
+		cols, values := m.Row(i)
+		sum := 0.0
+		for k, j := range cols {
+			sum += values[k] * x[j]
+		}
+		result[i] = sum
+	}
+	return result
+}
+
+// Transpose returns the transpose of the matrix.
+func (m *SparseMatrix) Transpose() *SparseMatrix {
+	t := &SparseMatrix{
+		n:        m.n,
+		rowStart: make([]int, m.n+1),
+		cols:     make([]int, len(m.cols)),
+		values:   make([]float64, len(m.values)),
+	}
+	for _, j := range m.cols {
+		t.rowStart[j+1]++
+	}
+	for i := 0; i < m.n; i++ {
+		t.rowStart[i+1] += t.rowStart[i]
+	}
+	// The rows are visited in order, so the entries of each row of the
+	// transpose are added sorted by column.
+	next := make([]int, m.n)
+	copy(next, t.rowStart)
+	for i := 0; i < m.n; i++ {
+		cols, values := m.Row(i)
+		for k, j := range cols {
+			t.cols[next[j]] = i
+			t.values[next[j]] = values[k]
+			next[j]++
+		}
+	}
+	return t
+}
+
+// Hadamard returns the element-wise product of the two matrices.
+func (m *SparseMatrix) Hadamard(other *SparseMatrix) *SparseMatrix {
+	if m.n != other.n {
+		panic("Matrices must have the same dimensions for element-wise multiplication")
+	}
+	result := &SparseMatrix{n: m.n, rowStart: make([]int, m.n+1)}
+	for i := 0; i < m.n; i++ {
+		aCols, aValues := m.Row(i)
+		bCols, bValues := other.Row(i)
+		for a, b := 0, 0; a < len(aCols) && b < len(bCols); {
+			switch {
+			case aCols[a] < bCols[b]:
+				a++
+			case aCols[a] > bCols[b]:
+				b++
+			default:
+				result.cols = append(result.cols, aCols[a])
+				result.values = append(result.values, aValues[a]*bValues[b])
+				a++
+				b++
+			}
+		}
+		result.rowStart[i+1] = len(result.cols)
+	}
+	return result
+}
+
+// NormalizeRows scales each row with a non-zero sum to sum to 1, in place.
+func (m *SparseMatrix) NormalizeRows() *SparseMatrix {
+	for i := 0; i < m.n; i++ {
+		_, values := m.Row(i)
+		rowSum := 0.0
+		for _, v := range values {
+			rowSum += v
+		}
+		if rowSum != 0 {
+			for k := range values {
+				values[k] /= rowSum
+			}
+		}
+	}
+	return m
+}
+
+// Dense returns the matrix as a dense n×n matrix. Used only for debugging and
+// testing on small matrices.
+func (m *SparseMatrix) Dense() [][]float64 {
+	dense := make([][]float64, m.n)
+	for i := range dense {
+		dense[i] = make([]float64, m.n)
+		cols, values := m.Row(i)
+		for k, j := range cols {
+			dense[i][j] = values[k]
+		}
+	}
+	return dense
+}
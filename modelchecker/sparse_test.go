@@ -0,0 +1,58 @@
+package modelchecker
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSparseMatrix(t *testing.T) {
+	b := newSparseBuilder(3)
+	b.Add(2, 0, 0.5)
+	b.Add(0, 1, 1.0)
+	b.Add(2, 2, 0.25)
+	b.Add(2, 0, 0.25)
+	b.Add(1, 1, 2.0)
+	m := b.Build()
+	dense := [][]float64{
+		{0, 1, 0},
+		{0, 2, 0},
+		{0.75, 0, 0.25},
+	}
+	assert.Equal(t, 3, m.N())
+	assert.Equal(t, dense, m.Dense())
+	assert.Equal(t, 0.75, m.Get(2, 0))
+	assert.Equal(t, 0.0, m.Get(1, 0))
+
+	t.Run("mulVec", func(t *testing.T) {
+		assert.Equal(t, []float64{2, 4, 1}, m.MulVec([]float64{1, 2, 1}))
+	})
+	t.Run("transpose", func(t *testing.T) {
+		transposed := m.Transpose()
+		assert.Equal(t, [][]float64{
+			{0, 0, 0.75},
+			{1, 2, 0},
+			{0, 0, 0.25},
+		}, transposed.Dense())
+		assert.Equal(t, m.Dense(), transposed.Transpose().Dense())
+	})
+	t.Run("hadamard", func(t *testing.T) {
+		other := newSparseBuilder(3)
+		other.Add(0, 0, 5)
+		other.Add(0, 1, 3)
+		other.Add(2, 2, 4)
+		assert.Equal(t, [][]float64{
+			{0, 3, 0},
+			{0, 0, 0},
+			{0, 0, 1},
+		}, m.Hadamard(other.Build()).Dense())
+	})
+	t.Run("normalizeRows", func(t *testing.T) {
+		b := newSparseBuilder(2)
+		b.Add(0, 0, 1)
+		b.Add(0, 1, 3)
+		assert.Equal(t, [][]float64{
+			{0.25, 0.75},
+			{0, 0},
+		}, b.Build().NormalizeRows().Dense())
+	})
+}
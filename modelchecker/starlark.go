@@ -8,7 +8,12 @@ import (
 
 func (e *Evaluator) EvalPyExpr(filename string, src interface{}, prevState starlark.StringDict) (starlark.Value, error) {
 
-	value, err := starlark.EvalOptions(e.options, e.thread, filename, src, prevState)
+	value, err := e.cache.evalExpr(e.options, e.thread, filename, src, func(name string) (starlark.Value, bool) {
+		if v, ok := prevState[name]; ok {
+			return v, true
+		}
+		return e.predeclared(name)
+	})
 	if err != nil {
 		glog.Errorf("Error evaluating expr: %+v", err)
 		return nil, err
@@ -19,27 +24,26 @@ func (e *Evaluator) EvalPyExpr(filename string, src interface{}, prevState starl
 
 func (e *Evaluator) ExecPyStmt(filename string, stmt *ast.PyStmt, prevState starlark.StringDict) (bool, error) {
 
-	starCode := stmt.Code
-
-	f, err := e.options.Parse(filename, starCode, 0)
-	if err != nil {
-		glog.Errorf("Error parsing expr: %+v", err)
-		return false, err
+	globals, err := e.cache.execStmt(e.options, e.thread, filename, stmt.Code, func(name string) (starlark.Value, bool) {
+		if v, ok := prevState[name]; ok {
+			return v, true
+		}
+		return e.predeclared(name)
+	})
+	// Reflect the changes to the variables, even after an error.
+	for name, v := range globals {
+		if _, found := prevState[name]; !found {
+			// A helper or a builtin is not part of the state, unless the statement
+			// assigned a new value to the name.
+			if p, ok := e.predeclared(name); ok && p == v {
+				continue
+			}
+		}
+		prevState[name] = v
 	}
-
-	err = starlark.ExecREPLChunk(f, e.thread, prevState)
-	globals := prevState
-	//globals, err := starlark.ExecFileOptions(e.options, e.thread, filename, starCode, prevState)
 	if err != nil {
 		glog.Errorf("Error executing stmt: %+v", err)
 		return false, err
 	}
-
-	// Print the global environment.
-	for _, name := range globals.Keys() {
-		v := globals[name]
-
-		prevState[name] = v
-	}
 	return true, nil
 }
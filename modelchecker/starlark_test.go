@@ -102,3 +102,86 @@ func TestExecPyStmt(t *testing.T) {
 		require.False(t, valid)
 	})
 }
+
+func TestEvaluator_CompileCache(t *testing.T) {
+	checker := NewModelChecker("test")
+
+	t.Run("expr_reused_across_states", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			val, err := checker.EvalPyExpr("myname.fizz", "count * 2", starlark.StringDict{"count": starlark.MakeInt(i)})
+			require.Nil(t, err)
+			assert.Equal(t, starlark.MakeInt(i*2), val)
+		}
+		assert.Len(t, checker.cache.exprs, 1)
+	})
+	t.Run("expr_variable_shadows_builtin", func(t *testing.T) {
+		val, err := checker.EvalPyExpr("myname.fizz", "len", starlark.StringDict{})
+		require.Nil(t, err)
+		assert.Equal(t, "builtin_function_or_method", val.Type())
+
+		val, err = checker.EvalPyExpr("myname.fizz", "len", starlark.StringDict{"len": starlark.MakeInt(3)})
+		require.Nil(t, err)
+		assert.Equal(t, starlark.MakeInt(3), val)
+
+		val, err = checker.EvalPyExpr("myname.fizz", "len", starlark.StringDict{})
+		require.Nil(t, err)
+		assert.Equal(t, "builtin_function_or_method", val.Type())
+	})
+	t.Run("expr_undefined_after_defined", func(t *testing.T) {
+		val, err := checker.EvalPyExpr("myname.fizz", "x + 1", starlark.StringDict{"x": starlark.MakeInt(1)})
+		require.Nil(t, err)
+		assert.Equal(t, starlark.MakeInt(2), val)
+
+		_, err = checker.EvalPyExpr("myname.fizz", "x + 1", starlark.StringDict{})
+		require.NotNil(t, err)
+	})
+	t.Run("stmt_reused_across_globals", func(t *testing.T) {
+		pystmt := &ast.PyStmt{Code: "y = x + 1"}
+		for i := 0; i < 3; i++ {
+			globals := starlark.StringDict{"x": starlark.MakeInt(i)}
+			if i == 1 {
+				globals["y"] = starlark.MakeInt(10)
+			}
+			valid, err := checker.ExecPyStmt("myname.fizz", pystmt, globals)
+			require.Nil(t, err)
+			assert.True(t, valid)
+			assert.Equal(t, starlark.MakeInt(i+1), globals["y"])
+		}
+
+		valid, err := checker.ExecPyStmt("myname.fizz", pystmt, starlark.StringDict{})
+		require.NotNil(t, err)
+		assert.False(t, valid)
+		assert.Len(t, checker.cache.stmts, 1)
+	})
+	t.Run("stmt_reads_and_assigns", func(t *testing.T) {
+		pystmt := &ast.PyStmt{Code: "x = x + 1\nz = len([x])"}
+		for i := 0; i < 2; i++ {
+			globals := starlark.StringDict{"x": starlark.MakeInt(i)}
+			valid, err := checker.ExecPyStmt("myname.fizz", pystmt, globals)
+			require.Nil(t, err)
+			assert.True(t, valid)
+			// The builtins are not added to the state.
+			assert.Equal(t, starlark.StringDict{"x": starlark.MakeInt(i + 1), "z": starlark.MakeInt(1)}, globals)
+		}
+	})
+	t.Run("closures_keep_their_values", func(t *testing.T) {
+		// Each call has its own environment, so a function keeps the values of
+		// the state it was created in.
+		var fns []starlark.Value
+		for i := 0; i < 2; i++ {
+			fn, err := checker.EvalPyExpr("myname.fizz", "lambda: x", starlark.StringDict{"x": starlark.MakeInt(i)})
+			require.Nil(t, err)
+			fns = append(fns, fn)
+
+			globals := starlark.StringDict{"x": starlark.MakeInt(i)}
+			_, err = checker.ExecPyStmt("myname.fizz", &ast.PyStmt{Code: "f = lambda: x"}, globals)
+			require.Nil(t, err)
+			fns = append(fns, globals["f"])
+		}
+		for i, fn := range fns {
+			val, err := starlark.Call(checker.thread, fn, nil, nil)
+			require.Nil(t, err)
+			assert.Equal(t, starlark.MakeInt(i/2), val)
+		}
+	})
+}
@@ -0,0 +1,285 @@
+package modelchecker
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// maxSubsetsElements limits the size of the set std.subsets enumerates, as the number
+// of subsets grows exponentially.
+const maxSubsetsElements = 20
+
+// stdModule is the std module of helpers for the specs, in the spirit of the TLA+
+// Sequences, FiniteSets, Bags and TLC modules. All the functions are deterministic
+// and return new values instead of modifying the arguments, so they can be used
+// in the actions, the init and the invariants alike.
+var stdModule = &starlarkstruct.Module{
+	Name: "std",
+	Members: starlark.StringDict{
+		"append":       starlark.NewBuiltin("std.append", stdAppend),
+		"head":         starlark.NewBuiltin("std.head", stdHead),
+		"tail":         starlark.NewBuiltin("std.tail", stdTail),
+		"cardinality":  starlark.NewBuiltin("std.cardinality", stdCardinality),
+		"subsets":      starlark.NewBuiltin("std.subsets", stdSubsets),
+		"permutations": starlark.NewBuiltin("std.permutations", stdPermutations),
+		"min_by":       starlark.NewBuiltin("std.min_by", stdMinBy),
+		"max_by":       starlark.NewBuiltin("std.max_by", stdMaxBy),
+		"majority":     starlark.NewBuiltin("std.majority", stdMajority),
+		"is_quorum":    starlark.NewBuiltin("std.is_quorum", stdIsQuorum),
+	},
+}
+
+// std.append(seq, x) returns a new list or tuple with x added at the end of seq.
+func stdAppend(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seq starlark.Indexable
+	var x starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &seq, &x); err != nil {
+		return nil, err
+	}
+	elems := append(indexableToSlice(seq), x)
+	if _, ok := seq.(starlark.Tuple); ok {
+		return starlark.Tuple(elems), nil
+	}
+	return starlark.NewList(elems), nil
+}
+
+// std.head(seq) returns the first element of a non-empty list or tuple.
+func stdHead(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seq starlark.Indexable
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &seq); err != nil {
+		return nil, err
+	}
+	if seq.Len() == 0 {
+		return nil, fmt.Errorf("%s: empty sequence", fn.Name())
+	}
+	return seq.Index(0), nil
+}
+
+// std.tail(seq) returns a new list or tuple with all but the first element of a non-empty seq.
+func stdTail(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var seq starlark.Indexable
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &seq); err != nil {
+		return nil, err
+	}
+	if seq.Len() == 0 {
+		return nil, fmt.Errorf("%s: empty sequence", fn.Name())
+	}
+	elems := indexableToSlice(seq)[1:]
+	if _, ok := seq.(starlark.Tuple); ok {
+		return starlark.Tuple(elems), nil
+	}
+	return starlark.NewList(elems), nil
+}
+
+// std.cardinality(s) returns the number of distinct elements in the collection.
+func stdCardinality(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &iterable); err != nil {
+		return nil, err
+	}
+	elems, err := distinctElements(iterable)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(len(elems)), nil
+}
+
+// std.subsets(s, k=None) returns the list of all the subsets of s, or only the ones
+// with k elements if k is given, like SUBSET s in TLA+.
+func stdSubsets(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	k := -1
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "s", &iterable, "k?", &k); err != nil {
+		return nil, err
+	}
+	elems, err := distinctElements(iterable)
+	if err != nil {
+		return nil, err
+	}
+	if len(elems) > maxSubsetsElements {
+		return nil, fmt.Errorf("%s: too many elements %d, at most %d are supported", fn.Name(), len(elems), maxSubsetsElements)
+	}
+	var subsets []starlark.Value
+	for mask := 0; mask < 1<<len(elems); mask++ {
+		set := starlark.NewSet(len(elems))
+		for i, elem := range elems {
+			if mask&(1<<i) != 0 {
+				if err := set.Insert(elem); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if k < 0 || set.Len() == k {
+			subsets = append(subsets, set)
+		}
+	}
+	return starlark.NewList(subsets), nil
+}
+
+// std.permutations(s) returns the list of all the orderings of the distinct elements
+// of s as tuples, like Permutations(S) in TLC.
+func stdPermutations(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &iterable); err != nil {
+		return nil, err
+	}
+	elems, err := distinctElements(iterable)
+	if err != nil {
+		return nil, err
+	}
+	var result []starlark.Value
+	var permute func(prefix starlark.Tuple, rest []starlark.Value)
+	permute = func(prefix starlark.Tuple, rest []starlark.Value) {
+		if len(rest) == 0 {
+			result = append(result, prefix)
+			return
+		}
+		for i := range rest {
+			next := append(prefix[:len(prefix):len(prefix)], rest[i])
+			remaining := append(append([]starlark.Value{}, rest[:i]...), rest[i+1:]...)
+			permute(next, remaining)
+		}
+	}
+	permute(starlark.Tuple{}, elems)
+	return starlark.NewList(result), nil
+}
+
+// std.min_by(s, key) returns the element of s with the smallest key(element).
+func stdMinBy(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return extremeBy(thread, fn, args, kwargs, syntax.LT)
+}
+
+// std.max_by(s, key) returns the element of s with the largest key(element).
+func stdMaxBy(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	return extremeBy(thread, fn, args, kwargs, syntax.GT)
+}
+
+// extremeBy returns the element whose key compares op to all the others. The ties are
+// broken by the canonical order of the elements, so the result does not depend on
+// the order the elements were added in.
+func extremeBy(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, op syntax.Token) (starlark.Value, error) {
+	var iterable starlark.Iterable
+	var key starlark.Callable
+	if err := starlark.UnpackArgs(fn.Name(), args, kwargs, "s", &iterable, "key", &key); err != nil {
+		return nil, err
+	}
+	elems := iterableToSlice(iterable)
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("%s: empty collection", fn.Name())
+	}
+	sortCanonical(elems)
+	var best, bestKey starlark.Value
+	for _, elem := range elems {
+		k, err := starlark.Call(thread, key, starlark.Tuple{elem}, nil)
+		if err != nil {
+			return nil, err
+		}
+		if best != nil {
+			better, err := starlark.Compare(op, k, bestKey)
+			if err != nil {
+				return nil, err
+			}
+			if !better {
+				continue
+			}
+		}
+		best, bestKey = elem, k
+	}
+	return best, nil
+}
+
+// std.majority(n) returns the smallest number of nodes that is more than half of n,
+// where n is the number of nodes or the collection of the nodes.
+func stdMajority(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var n starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 1, &n); err != nil {
+		return nil, err
+	}
+	count, err := nodeCount(fn, n)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(count/2 + 1), nil
+}
+
+// std.is_quorum(s, nodes) returns true if s contains a majority of the nodes.
+func stdIsQuorum(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var subset starlark.Iterable
+	var nodes starlark.Value
+	if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &subset, &nodes); err != nil {
+		return nil, err
+	}
+	count, err := nodeCount(fn, nodes)
+	if err != nil {
+		return nil, err
+	}
+	members, err := distinctElements(subset)
+	if err != nil {
+		return nil, err
+	}
+	votes := 0
+	for _, member := range members {
+		in := true
+		if container, ok := nodes.(starlark.Iterable); ok {
+			found, err := starlark.Binary(syntax.IN, member, container)
+			if err != nil {
+				return nil, err
+			}
+			in = bool(found.Truth())
+		}
+		if in {
+			votes++
+		}
+	}
+	return starlark.Bool(votes >= count/2+1), nil
+}
+
+// nodeCount returns n if it is an int, or the number of distinct elements if it is a collection.
+func nodeCount(fn *starlark.Builtin, n starlark.Value) (int, error) {
+	if iterable, ok := n.(starlark.Iterable); ok {
+		elems, err := distinctElements(iterable)
+		return len(elems), err
+	}
+	count, err := starlark.AsInt32(n)
+	if err != nil {
+		return 0, fmt.Errorf("%s: got %s, want int or collection", fn.Name(), n.Type())
+	}
+	return count, nil
+}
+
+// distinctElements returns the distinct elements of the iterable in the canonical order.
+func distinctElements(iterable starlark.Iterable) ([]starlark.Value, error) {
+	set := starlark.NewSet(0)
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for iter.Next(&x) {
+		if err := set.Insert(x); err != nil {
+			return nil, err
+		}
+	}
+	elems := iterableToSlice(set)
+	sortCanonical(elems)
+	return elems, nil
+}
+
+func iterableToSlice(iterable starlark.Iterable) []starlark.Value {
+	var elems []starlark.Value
+	iter := iterable.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for iter.Next(&x) {
+		elems = append(elems, x)
+	}
+	return elems
+}
+
+func indexableToSlice(seq starlark.Indexable) []starlark.Value {
+	elems := make([]starlark.Value, seq.Len())
+	for i := range elems {
+		elems[i] = seq.Index(i)
+	}
+	return elems
+}
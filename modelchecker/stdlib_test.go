@@ -0,0 +1,52 @@
+package modelchecker
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.starlark.net/starlark"
+	"testing"
+)
+
+func TestStdModule(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+	}{
+		{name: "appendList", expr: "std.append([1, 2], 3)", expected: "[1, 2, 3]"},
+		{name: "appendTuple", expr: "std.append((1,), 2)", expected: "(1, 2)"},
+		{name: "head", expr: "std.head([3, 4])", expected: "3"},
+		{name: "tail", expr: "std.tail([3, 4, 5])", expected: "[4, 5]"},
+		{name: "tailTuple", expr: "std.tail((3,))", expected: "()"},
+		{name: "cardinality", expr: "std.cardinality([1, 2, 1])", expected: "2"},
+		{name: "subsets", expr: "std.subsets(set([2, 1]))", expected: "[set([]), set([1]), set([2]), set([1, 2])]"},
+		{name: "subsetsOfSize", expr: "std.subsets(set([3, 1, 2]), 2)", expected: "[set([1, 2]), set([1, 3]), set([2, 3])]"},
+		{name: "permutations", expr: "std.permutations(set([2, 1]))", expected: "[(1, 2), (2, 1)]"},
+		{name: "minBy", expr: "std.min_by(['bb', 'a', 'ccc'], lambda x: len(x))", expected: `"a"`},
+		{name: "maxBy", expr: "std.max_by({'x': 1, 'y': 3}.items(), lambda kv: kv[1])", expected: `("y", 3)`},
+		{name: "minByTies", expr: "std.min_by(set(['b', 'a']), lambda x: 0)", expected: `"a"`},
+		{name: "majority", expr: "std.majority(5)", expected: "3"},
+		{name: "majorityOfNodes", expr: "std.majority(['a', 'b', 'c', 'd'])", expected: "3"},
+		{name: "quorum", expr: "std.is_quorum(set(['a', 'b']), ['a', 'b', 'c'])", expected: "True"},
+		{name: "notQuorum", expr: "std.is_quorum(['a', 'a', 'x'], ['a', 'b', 'c'])", expected: "False"},
+		{name: "quorumOfCount", expr: "std.is_quorum([1, 2, 3], 5)", expected: "True"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, evalValue(t, test.expr).String())
+		})
+	}
+
+	t.Run("argumentsUnchanged", func(t *testing.T) {
+		globals := starlark.StringDict{"s": starlark.NewList([]starlark.Value{starlark.MakeInt(1)})}
+		value, err := NewModelChecker("test").EvalPyExpr("test.fizz", "std.append(s, 2)", globals)
+		assert.Nil(t, err)
+		assert.Equal(t, "[1, 2]", value.String())
+		assert.Equal(t, "[1]", globals["s"].String())
+	})
+	t.Run("errors", func(t *testing.T) {
+		for _, expr := range []string{"std.head([])", "std.tail(())", "std.min_by([], len)", "std.subsets(range(21))"} {
+			_, err := NewModelChecker("test").EvalPyExpr("test.fizz", expr, starlark.StringDict{})
+			assert.NotNil(t, err, expr)
+		}
+	})
+}
@@ -15,54 +15,22 @@ import (
 
 type Heap struct {
 	globals starlark.StringDict
+	// shared is true if the globals may be referenced by the heap of another process.
+	// The statements work on deep copies of the values, so only the dict is copied,
+	// before it is modified.
+	shared bool
 }
 
 func (h *Heap) MarshalJSON() ([]byte, error) {
 	return StringDictToJson(h.globals)
 }
 
-func StringDictToMap(stringDict starlark.StringDict) map[string]string {
-	m := make(map[string]string, len(stringDict))
+// StringDictToMap converts the variables to a map that json marshals as typed JSON,
+// with the nested sets and dicts in a canonical order. See ToJsonValue.
+func StringDictToMap(stringDict starlark.StringDict) map[string]interface{} {
+	m := make(map[string]interface{}, len(stringDict))
 	for k, v := range stringDict {
-		if v.Type() == "set" {
-			// Convert set to a list.
-			iter := v.(starlark.Iterable).Iterate()
-
-			var x starlark.Value
-			var list []string
-			for iter.Next(&x) {
-				list = append(list, x.String())
-			}
-			sort.Strings(list)
-			m[k] = fmt.Sprintf("%v", list)
-			iter.Done()
-			continue
-		} else if v.Type() == "dict" {
-			// Convert map keys to a sorted list and add re-add them.
-			dict := v.(*starlark.Dict)
-			keys := dict.Keys()
-
-			var list []string
-			var keyMap = make(map[string]starlark.Value)
-			for _, x := range keys {
-				list = append(list, x.String())
-				keyMap[x.String()] = x
-			}
-			sort.Strings(list)
-
-			newDict := starlark.NewDict(len(list))
-			for _, x := range list {
-				key := keyMap[x]
-				val, _, _ := dict.Get(key)
-				err := newDict.SetKey(key, val)
-				PanicOnError(err)
-			}
-			m[k] = fmt.Sprintf("%v", newDict)
-			continue
-		}
-		// list is okay. no changes needed
-		str := v.String()
-		m[k] = str
+		m[k] = ToJsonValue(v)
 	}
 	return m
 }
@@ -92,12 +60,13 @@ func (h *Heap) String() string {
 // HashCode returns a string hash of the global state.
 func (h *Heap) HashCode() string {
 	hashBuf := sha256.New()
-	hashBuf.Write([]byte(h.ToJson()))
+	hashBuf.Write(StringDictCanonicalBytes(h.globals))
 	return fmt.Sprintf("%x", hashBuf.Sum(nil))
 }
 
 func (h *Heap) update(k string, v starlark.Value) bool {
 	if _, ok := h.globals[k]; ok {
+		h.own()
 		h.globals[k] = v
 		return true
 	}
@@ -105,12 +74,23 @@ func (h *Heap) update(k string, v starlark.Value) bool {
 }
 
 func (h *Heap) insert(k string, v starlark.Value) bool {
+	h.own()
 	h.globals[k] = v
 	return true
 }
 
+// own copies the globals if they are shared, so they can be modified.
+func (h *Heap) own() {
+	if h.shared {
+		h.globals = shallowCopyDict(h.globals)
+		h.shared = false
+	}
+}
+
+// Clone returns a heap that shares the globals with this one until either is modified.
 func (h *Heap) Clone() *Heap {
-	return &Heap{CloneDict(h.globals)}
+	h.shared = true
+	return &Heap{globals: h.globals, shared: true}
 }
 
 type Scope struct {
@@ -126,12 +106,17 @@ type Scope struct {
 	loopVars []string
 	// loopRange contains the range of values for the loop variables (probably a tuple when multiple loopVars).
 	loopRange []starlark.Value
+
+	// shared is true if the scope may be referenced by the frames of other threads.
+	// A shared scope is copied before it is modified, see CallFrame.writableScope.
+	// The parents of a shared scope are shared too.
+	shared bool
 }
 
 func (s *Scope) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]interface{}{
 		"parent":    s.parent,
-		"vars":      StringDictToJsonString(s.vars),
+		"vars":      StringDictToMap(s.vars),
 		"skipstmts": s.skipstmts,
 		"loopRange": s.loopRange,
 	})
@@ -153,13 +138,9 @@ func (s *Scope) Hash() hash.Hash {
 	} else {
 		h = sha256.New()
 	}
-	vars, err := StringDictToJson(s.vars)
-	if err != nil {
-		panic(err)
-	}
-	h.Write(vars)
+	h.Write(StringDictCanonicalBytes(s.vars))
 	h.Write([]byte(fmt.Sprintln(sortedCopy(s.skipstmts))))
-	h.Write([]byte(fmt.Sprintln(s.loopRange)))
+	h.Write(CanonicalBytes(starlark.Tuple(s.loopRange)))
 	return h
 }
 
@@ -174,6 +155,25 @@ func sortedCopy(slice []int) []int {
 	return sorted
 }
 
+// copy returns an unshared copy of the scope, with the same parent.
+func (s *Scope) copy() *Scope {
+	return &Scope{
+		parent:    s.parent,
+		flow:      s.flow,
+		vars:      shallowCopyDict(s.vars),
+		skipstmts: append([]int(nil), s.skipstmts...),
+		loopVars:  s.loopVars,
+		loopRange: append([]starlark.Value(nil), s.loopRange...),
+	}
+}
+
+// share marks the scope and its parents as shared.
+func (s *Scope) share() {
+	for ; s != nil && !s.shared; s = s.parent {
+		s.shared = true
+	}
+}
+
 func (s *Scope) Lookup(name string) (starlark.Value, bool) {
 	v, ok := s.vars[name]
 	if !ok && s.parent != nil {
@@ -200,6 +200,15 @@ func CloneDict(oldDict starlark.StringDict) starlark.StringDict {
 	return CopyDict(oldDict, nil)
 }
 
+// shallowCopyDict returns a copy of the dict that shares the values.
+func shallowCopyDict(dict starlark.StringDict) starlark.StringDict {
+	copied := make(starlark.StringDict, len(dict))
+	for k, v := range dict {
+		copied[k] = v
+	}
+	return copied
+}
+
 // CopyDict copies values `from` to `to` overriding existing values. If the `to` is nil, creates a new dict.
 func CopyDict(from starlark.StringDict, to starlark.StringDict) starlark.StringDict {
 	if to == nil {
@@ -216,8 +225,11 @@ func CopyDict(from starlark.StringDict, to starlark.StringDict) starlark.StringD
 type CallFrame struct {
 	// FileIndex is the ast.FileIndex that this frame is executing.
 	FileIndex int
-	// pc is the program counter, pointing at the next instruction to execute.
-	pc string
+	// program is the compiled program of the file.
+	program *Program
+	// pc is the program counter, the index of the next instruction to execute
+	// in the program, or noPc.
+	pc int
 
 	// Name is the full path of the function/action being executed.
 	Name string
@@ -234,10 +246,10 @@ type CallFrame struct {
 func (c *CallFrame) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]interface{}{
 		"fileIndex": c.FileIndex,
-		"pc":        c.pc,
+		"pc":        c.pcPath(),
 		"name":      c.Name,
 		"scope":     c.scope,
-		"vars":      StringDictToJsonString(c.vars),
+		"vars":      StringDictToMap(c.vars),
 	})
 
 }
@@ -247,10 +259,58 @@ func (c *CallFrame) HashCode() string {
 	// This is to ensure that the same scoped variables are not treated the same
 	// if program counter is at different stmts.
 	h := c.scope.Hash()
-	h.Write([]byte(c.pc))
+	h.Write(appendPcKey(nil, c.pc))
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
+// pcPath returns the path of the program counter in the ast.
+func (c *CallFrame) pcPath() string {
+	return c.program.Path(c.pc)
+}
+
+// instruction returns the instruction at the program counter.
+func (c *CallFrame) instruction() *Instruction {
+	return c.program.instructions[c.pc]
+}
+
+// Clone returns a copy of the frame that shares the scopes with this one until
+// either modifies them.
+func (c *CallFrame) Clone() *CallFrame {
+	c.scope.share()
+	clone := *c
+	return &clone
+}
+
+// writableScope returns the scope to modify in place of the target, a scope in the
+// chain of the current scope. If the target is shared, it is copied along with the
+// shared scopes below it, and the copies replace them in this frame.
+func (c *CallFrame) writableScope(target *Scope) *Scope {
+	if !target.shared {
+		return target
+	}
+	var child *Scope
+	for s := c.scope; ; s = s.parent {
+		current := s
+		if s.shared {
+			current = s.copy()
+			if child == nil {
+				c.scope = current
+			} else {
+				child.parent = current
+			}
+		}
+		if s == target {
+			return current
+		}
+		child = current
+	}
+}
+
+// currentScope returns the current scope of the frame, copied first if it is shared.
+func (c *CallFrame) currentScope() *Scope {
+	return c.writableScope(c.scope)
+}
+
 type CallStack struct {
 	*lib.Stack[*CallFrame]
 }
@@ -259,15 +319,20 @@ func NewCallStack() *CallStack {
 	return &CallStack{lib.NewStack[*CallFrame]()}
 }
 
+// Clone returns a copy of the stack, with copies of the frames sharing the scopes.
 func (s *CallStack) Clone() *CallStack {
-	return &CallStack{s.Stack.Clone()}
+	stack := NewCallStack()
+	for _, frame := range s.Values() {
+		stack.Push(frame.Clone())
+	}
+	return stack
 }
 
 func (s *CallStack) HashCode() string {
 	if s == nil {
 		return ""
 	}
-	arr := s.RawArrayCopy()
+	arr := s.Values()
 	h := sha256.New()
 
 	for _, frame := range arr {
@@ -285,9 +350,9 @@ type Thread struct {
 	Fairness ast.FairnessLevel `json:"fairness"`
 }
 
-func NewThread(Process *Process, files []*ast.File, fileIndex int, action string) *Thread {
+func NewThread(Process *Process, files []*ast.File, fileIndex int, pc int) *Thread {
 	stack := NewCallStack()
-	frame := &CallFrame{FileIndex: fileIndex, pc: action}
+	frame := &CallFrame{FileIndex: fileIndex, program: Process.programs[fileIndex], pc: pc}
 	t := &Thread{Process: Process, Files: files, Stack: stack}
 	t.pushFrame(frame)
 	return t
@@ -325,11 +390,25 @@ func (t *Thread) currentFrame() *CallFrame {
 	return frame
 }
 
+// actionName returns the name of the action the thread is running.
+func (t *Thread) actionName() string {
+	frame, ok := t.Stack.Head()
+	if !ok {
+		return ""
+	}
+	return frame.Name
+}
+
 func (t *Thread) currentFileAst() *ast.File {
 	frame := t.currentFrame()
 	return t.Files[frame.FileIndex]
 }
 
+// program returns the compiled program of the file the current frame is executing.
+func (t *Thread) program() *Program {
+	return t.currentFrame().program
+}
+
 func PanicIfFalse(ok bool, msg string) {
 	if !ok {
 		panic(msg)
@@ -360,14 +439,14 @@ func (t *Thread) Execute() ([]*Process, bool) {
 	var forks []*Process
 	yield := false
 	for t.Stack.Len() > 0 {
-		for t.currentFrame().pc == "" || strings.HasSuffix(t.currentFrame().pc, ".Block.$") {
+		for t.currentFrame().pc == noPc || t.currentFrame().instruction().Message == nil {
 			yield = t.executeEndOfBlock()
 			if yield {
 				return forks, yield
 			}
 		}
 		frame := t.currentFrame()
-		protobuf := GetProtoFieldByPath(t.currentFileAst(), frame.pc)
+		protobuf := t.program().Message(frame.pc)
 
 		switch msg := protobuf.(type) {
 		case *ast.Action:
@@ -392,27 +471,27 @@ func (t *Thread) Execute() ([]*Process, bool) {
 }
 
 func (t *Thread) executeAction() {
-	t.currentFrame().pc = t.currentFrame().pc + ".Block"
+	t.currentFrame().pc = t.currentFrame().instruction().body
 }
 
 func (t *Thread) executeBlock() []*Process {
 	newScope := t.InsertNewScope()
-	protobuf := GetProtoFieldByPath(t.currentFileAst(), t.currentPc())
-	b := convertToBlock(protobuf)
+	inst := t.currentFrame().instruction()
+	b := convertToBlock(inst.Message)
 	newScope.SetFlow(b.Flow)
 	switch newScope.flow {
 	case ast.Flow_FLOW_ATOMIC:
-		t.currentFrame().pc = t.currentPc() + ".Stmts[0]"
+		t.currentFrame().pc = t.FindNextProgramCounter()
 		return nil
 	case ast.Flow_FLOW_SERIAL:
-		t.currentFrame().pc = t.currentPc() + ".Stmts[0]"
+		t.currentFrame().pc = t.FindNextProgramCounter()
 		return nil
 	case ast.Flow_FLOW_ONEOF:
 		forks := make([]*Process, len(b.Stmts))
 		for i := range b.Stmts {
 			forks[i] = t.Process.Fork()
 			forks[i].Name = fmt.Sprintf("Stmt:%d", i)
-			forks[i].currentThread().currentFrame().pc = fmt.Sprintf("%s.Stmts[%d]", t.currentPc(), i)
+			forks[i].currentThread().currentFrame().pc = inst.stmts[i]
 		}
 		return forks
 	case ast.Flow_FLOW_PARALLEL:
@@ -420,8 +499,9 @@ func (t *Thread) executeBlock() []*Process {
 		for i := range b.Stmts {
 			forks[i] = t.Process.Fork()
 			forks[i].Name = fmt.Sprintf("Stmt:%d", i)
-			forks[i].currentThread().currentFrame().pc = fmt.Sprintf("%s.Stmts[%d]", t.currentPc(), i)
-			forks[i].currentThread().currentFrame().scope.skipstmts = append(forks[i].currentThread().currentFrame().scope.skipstmts, i)
+			forks[i].currentThread().currentFrame().pc = inst.stmts[i]
+			scope := forks[i].currentThread().currentFrame().currentScope()
+			scope.skipstmts = append(scope.skipstmts, i)
 		}
 		return forks
 	default:
@@ -433,12 +513,13 @@ func (t *Thread) executeBlock() []*Process {
 
 func (t *Thread) executeStatement() ([]*Process, bool) {
 	currentFrame := t.currentFrame()
-	protobuf := GetProtoFieldByPath(t.currentFileAst(), currentFrame.pc)
-	stmt := convertToStatement(protobuf)
+	inst := currentFrame.instruction()
+	stmt := convertToStatement(inst.Message)
 	if stmt.Label != "" {
 		t.Process.Labels = append(t.Process.Labels, currentFrame.Name + "." + stmt.Label)
 	}
 	t.Process.Fairness = t.Fairness
+	defer t.Process.Evaluator.capturePrints(&t.Process.Logs)()
 	if stmt.PyStmt != nil {
 		vars := t.Process.GetAllVariables()
 		_, err := t.Process.Evaluator.ExecPyStmt("filename.fizz", stmt.PyStmt, vars)
@@ -446,7 +527,7 @@ func (t *Thread) executeStatement() ([]*Process, bool) {
 		t.Process.updateAllVariablesInScope(vars)
 		t.Process.Enable()
 	} else if stmt.Block != nil {
-		currentFrame.pc = currentFrame.pc + ".Block"
+		currentFrame.pc = inst.body
 		forks := t.executeBlock()
 		return forks, false
 	} else if stmt.IfStmt != nil {
@@ -460,7 +541,7 @@ func (t *Thread) executeStatement() ([]*Process, bool) {
 			t.Process.PanicOnError(fmt.Sprintf("Error checking condition: %s", branch.Condition), err)
 			t.Process.updateAllVariablesInScope(vars)
 			if cond.Truth() {
-				currentFrame.pc = fmt.Sprintf("%s.IfStmt.Branches[%d].Block", currentFrame.pc, i)
+				currentFrame.pc = inst.branches[i]
 				return nil, false
 			}
 		}
@@ -496,8 +577,8 @@ func (t *Thread) executeStatement() ([]*Process, bool) {
 			//fmt.Printf("anyVariable: x: %s\n", x.String())
 			fork := t.Process.Fork()
 			fork.Name = fmt.Sprintf("Any:%s", x.String())
-			fork.currentThread().currentFrame().pc = fmt.Sprintf("%s.AnyStmt.Block", currentFrame.pc)
-			fork.currentThread().currentFrame().scope.vars[stmt.AnyStmt.LoopVars[0]] = x
+			fork.currentThread().currentFrame().pc = inst.body
+			fork.currentThread().currentFrame().currentScope().vars[stmt.AnyStmt.LoopVars[0]] = x
 			forks = append(forks, fork)
 
 		}
@@ -532,31 +613,31 @@ func (t *Thread) executeStatement() ([]*Process, bool) {
 		for iter.Next(&x) {
 			scope.loopRange = append(scope.loopRange, x)
 		}
-		currentFrame.pc = currentFrame.pc + ".ForStmt"
+		currentFrame.pc = inst.loop
 		return nil, false
 	} else if stmt.WhileStmt != nil {
 		scope := t.InsertNewScope()
 		scope.SetFlow(stmt.WhileStmt.Flow)
-		currentFrame.pc = fmt.Sprintf("%s.WhileStmt", currentFrame.pc)
+		currentFrame.pc = inst.loop
 		return nil, false
 	} else if stmt.BreakStmt != nil {
-		for !(strings.HasSuffix(currentFrame.pc, ".ForStmt") || strings.HasSuffix(currentFrame.pc, ".WhileStmt")) {
-			currentFrame.pc = RemoveLastBlock(currentFrame.pc)
+		for !isLoop(currentFrame.instruction()) {
+			currentFrame.pc = t.program().Owner(currentFrame.pc)
 			currentFrame.scope = currentFrame.scope.parent
 		}
 		currentFrame.scope = currentFrame.scope.parent
-		currentFrame.pc = RemoveLastLoop(currentFrame.pc)
+		currentFrame.pc = currentFrame.instruction().stmt
 		return t.executeEndOfStatement()
 
 	} else if stmt.ContinueStmt != nil {
 		for {
-			currentFrame.pc = RemoveLastBlock(currentFrame.pc)
-			if strings.HasSuffix(currentFrame.pc, ".ForStmt") || strings.HasSuffix(currentFrame.pc, ".WhileStmt") {
+			currentFrame.pc = t.program().Owner(currentFrame.pc)
+			if isLoop(currentFrame.instruction()) {
 				break
 			}
 			currentFrame.scope = currentFrame.scope.parent
 		}
-		currentFrame.pc = currentFrame.pc + ".Block.$"
+		currentFrame.pc = t.program().instructions[currentFrame.instruction().body].end
 		return nil, false
 	} else if stmt.ReturnStmt != nil {
 		vars := t.Process.GetAllVariables()
@@ -567,8 +648,7 @@ func (t *Thread) executeStatement() ([]*Process, bool) {
 			//PanicOnError(err)
 			val = v
 		}
-		actionPath := strings.Split(currentFrame.pc, ".")[0]
-		action := GetProtoFieldByPath(t.currentFileAst(), actionPath)
+		action := t.program().Message(t.program().Root(currentFrame.pc))
 		oldFrame := t.popFrame()
 		if t.Stack.Len() == 0 {
 			t.Process.removeCurrentThread()
@@ -582,12 +662,33 @@ func (t *Thread) executeStatement() ([]*Process, bool) {
 				panic("Multiple return values not supported yet")
 			}
 			for _, name := range oldFrame.callerAssignVarNames {
-				t.currentFrame().scope.vars[name] = val
+				t.currentFrame().currentScope().vars[name] = val
 				t.Process.Enable()
 			}
 			return t.executeEndOfStatement()
 		}
 		return nil, false
+	} else if stmt.AssertStmt != nil {
+		vars := t.Process.GetAllVariables()
+		cond, err := t.Process.Evaluator.EvalPyExpr("filename.fizz", stmt.AssertStmt.PyExpr, vars)
+		t.Process.PanicOnError(fmt.Sprintf("Error evaluating assertion: %s", stmt.AssertStmt.PyExpr), err)
+		if !cond.Truth() {
+			msg := fmt.Sprintf("Assertion failed: %s", stmt.AssertStmt.PyExpr)
+			if stmt.AssertStmt.MessagePyExpr != "" {
+				v, err := t.Process.Evaluator.EvalPyExpr("filename.fizz", stmt.AssertStmt.MessagePyExpr, vars)
+				t.Process.PanicOnError(fmt.Sprintf("Error evaluating expr: %s", stmt.AssertStmt.MessagePyExpr), err)
+				if str, ok := v.(starlark.String); ok {
+					msg = fmt.Sprintf("%s, %s", msg, str.GoString())
+				} else {
+					msg = fmt.Sprintf("%s, %s", msg, v.String())
+				}
+			}
+			// Stop at the assert statement, so the counterexample ends at this statement
+			// and the stack trace points to it.
+			t.Process.FailedAssertion = t.Process.NewModelError(msg, nil)
+			t.Process.Enable()
+			return nil, true
+		}
 	} else if stmt.CallStmt != nil {
 
 		frame := currentFrame
@@ -626,7 +727,9 @@ func (t *Thread) executeStatement() ([]*Process, bool) {
 			t.Process.Enable()
 		} else {
 
-			newFrame := &CallFrame{FileIndex: def.fileIndex, pc: def.path + ".Block", Name: stmt.CallStmt.Name}
+			program := t.Process.programs[def.fileIndex]
+			function := program.instructions[program.functions[def.index]]
+			newFrame := &CallFrame{FileIndex: def.fileIndex, program: program, pc: function.body, Name: stmt.CallStmt.Name}
 			newFrame.callerAssignVarNames = stmt.CallStmt.Vars
 			t.Process.Labels = append(t.Process.Labels, newFrame.Name+".call")
 			// TODO: Handle args
@@ -644,15 +747,16 @@ func (t *Thread) executeForStatement() ([]*Process, bool) {
 	currentFrame := t.currentFrame()
 	if len(currentFrame.scope.loopRange) == 0 {
 		currentFrame.scope = currentFrame.scope.parent
-		currentFrame.pc = RemoveLastForStmt(t.currentPc())
+		currentFrame.pc = currentFrame.instruction().stmt
 		return t.executeEndOfStatement()
 		//return nil, false
 	}
 	scope := currentFrame.scope
-	currentFrame.pc = fmt.Sprintf("%s.Block", t.currentPc())
+	currentFrame.pc = currentFrame.instruction().body
 
 	// only atomic flow is supported for now.
 	if scope.flow == ast.Flow_FLOW_ATOMIC || scope.flow == ast.Flow_FLOW_SERIAL {
+		scope = currentFrame.currentScope()
 		scope.vars[scope.loopVars[0]] = scope.loopRange[0]
 		scope.loopRange = scope.loopRange[1:]
 		return nil, false
@@ -670,10 +774,10 @@ func (t *Thread) executeForStatement() ([]*Process, bool) {
 		// This is a subtle difference, but it will be important in the future for performance analysis. After all,
 		// if anyone uses parallel flow, it is to speed up.
 		fork := t.Process.Fork()
-		fork.currentThread().currentFrame().scope.vars[scope.loopVars[0]] = x
+		forkScope := fork.currentThread().currentFrame().currentScope()
+		forkScope.vars[scope.loopVars[0]] = x
 		fork.Name = fmt.Sprintf("For:%s", x.String())
-		newSlice := removeElement(scope.loopRange, i)
-		fork.currentThread().currentFrame().scope.loopRange = newSlice
+		forkScope.loopRange = removeElement(scope.loopRange, i)
 
 		forks = append(forks, fork)
 	}
@@ -681,8 +785,8 @@ func (t *Thread) executeForStatement() ([]*Process, bool) {
 }
 
 func (t *Thread) executeWhileStatement() ([]*Process, bool) {
-	protobuf := GetProtoFieldByPath(t.currentFileAst(), t.currentPc())
-	stmt := convertToWhileStmt(protobuf)
+	inst := t.currentFrame().instruction()
+	stmt := convertToWhileStmt(inst.Message)
 
 	if stmt.Flow == ast.Flow_FLOW_PARALLEL || stmt.Flow == ast.Flow_FLOW_ONEOF {
 		panic("Only atomic/serial flow is supported for while statements")
@@ -693,11 +797,11 @@ func (t *Thread) executeWhileStatement() ([]*Process, bool) {
 	//PanicOnError(err)
 	t.Process.updateAllVariablesInScope(vars)
 	if cond.Truth() {
-		t.currentFrame().pc = fmt.Sprintf("%s.Block", t.currentPc())
+		t.currentFrame().pc = inst.body
 		return nil, false
 	}
 	t.currentFrame().scope = t.currentFrame().scope.parent
-	t.currentFrame().pc = RemoveLastWhileStmt(t.currentPc())
+	t.currentFrame().pc = inst.stmt
 	return t.executeEndOfStatement()
 }
 
@@ -723,22 +827,18 @@ func (t *Thread) executeEndOfStatement() ([]*Process, bool) {
 		currentFrame.pc = t.FindNextProgramCounter()
 		return nil, true
 	case ast.Flow_FLOW_ONEOF:
-		currentFrame.pc = EndOfBlock(t.currentPc())
+		currentFrame.pc = t.program().EndOfBlock(currentFrame.pc)
 		return nil, false
 	case ast.Flow_FLOW_PARALLEL:
-		// if currentPc ends with .ForStmt do not execute end of statement.
-		if strings.HasSuffix(t.currentPc(), ".ForStmt") {
+		// if the current instruction is a for loop, do not execute end of statement.
+		if _, ok := currentFrame.instruction().Message.(*ast.ForStmt); ok {
 			return nil, true
 		}
-		blockPath := ParentBlockPath(t.currentPc())
-		if blockPath == "" {
-			//return nil, t.executeEndOfBlock()
-		}
-		protobuf := GetProtoFieldByPath(t.currentFileAst(), blockPath)
-		b := convertToBlock(protobuf)
+		block := t.program().instructions[t.program().ParentBlock(currentFrame.pc)]
+		b := convertToBlock(block.Message)
 		skipstmts := currentFrame.scope.skipstmts
 		if len(skipstmts) == len(b.Stmts) {
-			currentFrame.pc = EndOfBlock(t.currentPc())
+			currentFrame.pc = block.end
 			return nil, false
 		}
 		forks := make([]*Process, 0, len(b.Stmts)-len(skipstmts))
@@ -748,11 +848,12 @@ func (t *Thread) executeEndOfStatement() ([]*Process, bool) {
 			}
 			fork := t.Process.Fork()
 			fork.Name = fmt.Sprintf("Stmt:%d", i)
-			fork.currentThread().currentFrame().pc = fmt.Sprintf("%s.Stmts[%d]", blockPath, i)
-			fork.currentThread().currentFrame().scope.skipstmts = append(fork.currentThread().currentFrame().scope.skipstmts, i)
+			fork.currentThread().currentFrame().pc = block.stmts[i]
+			forkScope := fork.currentThread().currentFrame().currentScope()
+			forkScope.skipstmts = append(forkScope.skipstmts, i)
 			forks = append(forks, fork)
 		}
-		currentFrame.pc = ""
+		currentFrame.pc = noPc
 		return forks, true
 	default:
 		panic(fmt.Sprintf("Unknown flow type at %s", t.currentPc()))
@@ -770,8 +871,7 @@ func (t *Thread) executeEndOfBlock() bool {
 		frame.scope = frame.scope.parent
 		if frame.scope == nil {
 			//t.popFrame()
-			actionPath := strings.Split(frame.pc, ".")[0]
-			protobuf := GetProtoFieldByPath(t.currentFileAst(), actionPath)
+			protobuf := t.program().Message(t.program().Root(frame.pc))
 			if action, ok := protobuf.(*ast.Action); ok {
 				if action.Name == "Init" {
 					variables := oldScope.GetAllVisibleVariables()
@@ -793,20 +893,20 @@ func (t *Thread) executeEndOfBlock() bool {
 						panic("Multiple return values not supported yet")
 					}
 					for _, name := range oldFrame.callerAssignVarNames {
-						frame.scope.vars[name] = starlark.None
+						frame.currentScope().vars[name] = starlark.None
 					}
 					_,yield := t.executeEndOfStatement()
 					return yield
 				}
 			}
 		}
-		frame.pc = RemoveLastBlock(t.currentPc())
+		frame.pc = t.program().Owner(frame.pc)
 		forks, yield := t.executeEndOfStatement()
 		if len(forks) > 0 || yield {
 			return yield
 		}
 
-		if t.currentPc() != "" {
+		if frame.pc != noPc {
 			break
 		}
 	}
@@ -826,24 +926,43 @@ func ContainsInt(skipstmts []int, i int) bool {
 	return false
 }
 
+// currentPc returns the path of the program counter of the current frame in the ast.
 func (t *Thread) currentPc() string {
-	return t.currentFrame().pc
+	return t.currentFrame().pcPath()
 }
 
-func (t *Thread) FindNextProgramCounter() string {
+// isFinishing returns true if the thread is at the end of its action block, so the
+// next step only removes the thread without executing any statement.
+func (t *Thread) isFinishing() bool {
 	frame := t.currentFrame()
-	protobuf := GetProtoFieldByPath(t.currentFileAst(), frame.pc)
-	switch protobuf.(type) {
+	return frame.pc != noPc && frame.instruction().endsAction
+}
+
+// isLoop returns true if the instruction is a for or a while loop.
+func isLoop(inst *Instruction) bool {
+	switch inst.Message.(type) {
+	case *ast.ForStmt, *ast.WhileStmt:
+		return true
+	}
+	return false
+}
+
+func (t *Thread) FindNextProgramCounter() int {
+	frame := t.currentFrame()
+	inst := frame.instruction()
+	switch inst.Message.(type) {
 	case *ast.Action:
-		return frame.pc + ".Block"
+		return inst.body
 	case *ast.Block:
-		convertToBlock(protobuf)
-		return frame.pc + ".Stmts[0]"
+		if len(inst.stmts) == 0 {
+			return inst.end
+		}
+		return inst.stmts[0]
 	case *ast.Statement:
-		path, _ := GetNextFieldPath(t.currentFileAst(), frame.pc)
+		path := t.program().NextPc(frame.pc)
 		return path
 	case *ast.AnyStmt:
-		path, _ := GetNextFieldPath(t.currentFileAst(), frame.pc)
+		path := t.program().NextPc(frame.pc)
 		frame.scope = frame.scope.parent
 		return path
 	case *ast.ForStmt:
@@ -852,10 +971,10 @@ func (t *Thread) FindNextProgramCounter() string {
 	case *ast.WhileStmt:
 		return frame.pc
 	case *ast.Branch:
-		path, _ := GetNextFieldPath(t.currentFileAst(), frame.pc)
+		path := t.program().NextPc(frame.pc)
 		return path
 	}
-	return ""
+	return noPc
 }
 
 func convertToAction(message proto.Message) *ast.Action {
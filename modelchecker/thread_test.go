@@ -9,12 +9,28 @@ import (
 	"testing"
 )
 
+// pcAt returns the program counter of the instruction at the path in the ast.
+func pcAt(program *Program, path string) int {
+	for _, inst := range program.instructions {
+		if inst.Pc == path {
+			return inst.Index
+		}
+	}
+	panic("no instruction at " + path)
+}
+
+// jumpTo sets the program counter of the current frame of the thread to the
+// instruction at the path in the ast.
+func jumpTo(thread *Thread, path string) {
+	thread.currentFrame().pc = pcAt(thread.program(), path)
+}
+
 func TestThread_FindNextProgramCounter(t *testing.T) {
 	file, err := parseAstFromString(ActionsWithMultipleBlocks)
 	require.Nil(t, err)
 
-	thread := NewThread(nil, []*ast.File{file}, 0, "")
-	thread.pushFrame(&CallFrame{FileIndex: 0})
+	process := NewProcess("", []*ast.File{file}, nil)
+	thread := process.NewThread()
 	tests := []struct {
 		name string
 		pc   string
@@ -89,8 +105,8 @@ func TestThread_FindNextProgramCounter(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			thread.currentFrame().pc = tt.pc
-			if got := thread.FindNextProgramCounter(); got != tt.want {
+			jumpTo(thread, tt.pc)
+			if got := thread.program().Path(thread.FindNextProgramCounter()); got != tt.want {
 				t.Errorf("Thread.FindNextProgramCounter() = %v, want %v", got, tt.want)
 			}
 		})
@@ -101,12 +117,13 @@ func TestThread_ExecuteAction(t *testing.T) {
 	file, err := parseAstFromString(ActionsWithMultipleBlocks)
 	require.Nil(t, err)
 	files := []*ast.File{file}
-	thread := NewThread(nil, files, 0, "Actions[0]")
+	process := NewProcess("", files, nil)
+	thread := NewThread(process, files, 0, pcAt(process.programs[0], "Actions[0]"))
 	assert.Equal(t, thread.Stack.Len(), 1)
-	assert.Equal(t, thread.currentFrame().pc, "Actions[0]")
+	assert.Equal(t, thread.currentPc(), "Actions[0]")
 	thread.executeAction()
 	assert.Equal(t, thread.Stack.Len(), 1)
-	assert.Equal(t, thread.currentFrame().pc, "Actions[0].Block")
+	assert.Equal(t, thread.currentPc(), "Actions[0].Block")
 }
 
 func TestThread_ExecuteBlock(t *testing.T) {
@@ -115,11 +132,11 @@ func TestThread_ExecuteBlock(t *testing.T) {
 	files := []*ast.File{file}
 	process := NewProcess("", files, nil)
 	process.NewThread()
-	baseThread := NewThread(process, files, 0, "Actions[0]")
+	baseThread := NewThread(process, files, 0, pcAt(process.programs[0], "Actions[0]"))
 	assert.Equal(t, baseThread.Stack.Len(), 1)
 	t.Run("atomic", func(t *testing.T) {
 		thread := baseThread.Clone()
-		thread.currentFrame().pc = "Actions[0].Block"
+		jumpTo(thread, "Actions[0].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, thread.currentPc(), "Actions[0].Block.Stmts[0]")
@@ -128,7 +145,7 @@ func TestThread_ExecuteBlock(t *testing.T) {
 	})
 	t.Run("serial", func(t *testing.T) {
 		thread := baseThread.Clone()
-		thread.currentFrame().pc = "Actions[2].Block"
+		jumpTo(thread, "Actions[2].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, thread.currentPc(), "Actions[2].Block.Stmts[0]")
@@ -137,35 +154,74 @@ func TestThread_ExecuteBlock(t *testing.T) {
 	})
 	t.Run("oneof", func(t *testing.T) {
 		thread := process.Fork().currentThread()
-		thread.currentFrame().pc = "Actions[1].Block"
+		jumpTo(thread, "Actions[1].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		//assert.Equal(t, thread.currentPc(), "")
 		assert.Len(t, forks, 5)
-		assert.Len(t, thread.Process.Children, 5)
 		assert.Equal(t, ast.Flow_FLOW_ONEOF, thread.currentFrame().scope.flow)
 		for i, fork := range forks {
 			assert.Equal(t, fmt.Sprintf("Actions[1].Block.Stmts[%d]", i), fork.currentThread().currentPc())
-			assert.Equal(t, fork, thread.Process.Children[i])
+			assert.Same(t, thread.Process, fork.Parent)
 		}
 	})
 	t.Run("parallel", func(t *testing.T) {
 		thread := process.Fork().currentThread()
-		thread.currentFrame().pc = "Actions[3].Block"
+		jumpTo(thread, "Actions[3].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		//assert.Equal(t, "", thread.currentPc())
 		assert.Len(t, forks, 5)
 		assert.Equal(t, ast.Flow_FLOW_PARALLEL, thread.currentFrame().scope.flow)
-		assert.Len(t, thread.Process.Children, 5)
+
 		for i, fork := range forks {
 			assert.Equal(t, fmt.Sprintf("Actions[3].Block.Stmts[%d]", i), fork.currentThread().currentPc())
 			assert.Equal(t, []int{i}, fork.currentThread().currentFrame().scope.skipstmts)
-			assert.Equal(t, fork, thread.Process.Children[i])
+			assert.Same(t, thread.Process, fork.Parent)
 		}
 	})
 }
 
+func TestThread_CloneCopyOnWrite(t *testing.T) {
+	file, err := parseAstFromString(ActionsWithMultipleBlocks)
+	require.Nil(t, err)
+	files := []*ast.File{file}
+	process := NewProcess("", files, nil)
+	process.Heap.insert("x", starlark.MakeInt(1))
+	thread := process.NewThread()
+	outer := thread.InsertNewScope()
+	outer.vars["a"] = starlark.MakeInt(1)
+	inner := thread.InsertNewScope()
+	inner.vars["b"] = starlark.MakeInt(2)
+
+	fork := process.Fork()
+	forkFrame := fork.currentThread().currentFrame()
+	assert.Same(t, inner, forkFrame.scope)
+
+	// Modifying a variable in the outer scope copies the scopes of the fork only.
+	fork.updateAllVariablesInScope(starlark.StringDict{
+		"a": starlark.MakeInt(10), "x": starlark.MakeInt(20), "c": starlark.MakeInt(30),
+	})
+	assert.NotSame(t, inner, forkFrame.scope)
+	assert.NotSame(t, outer, forkFrame.scope.parent)
+	assert.Equal(t, starlark.StringDict{"a": starlark.MakeInt(10)}, forkFrame.scope.parent.vars)
+	assert.Equal(t, starlark.StringDict{"b": starlark.MakeInt(2), "c": starlark.MakeInt(30)}, forkFrame.scope.vars)
+	assert.Equal(t, starlark.StringDict{"x": starlark.MakeInt(20)}, fork.Heap.globals)
+
+	frame := thread.currentFrame()
+	assert.Same(t, inner, frame.scope)
+	assert.Same(t, outer, frame.scope.parent)
+	assert.Equal(t, starlark.StringDict{"a": starlark.MakeInt(1)}, outer.vars)
+	assert.Equal(t, starlark.StringDict{"b": starlark.MakeInt(2)}, inner.vars)
+	assert.Equal(t, starlark.StringDict{"x": starlark.MakeInt(1)}, process.Heap.globals)
+
+	// The original is shared as well, so modifying it does not change the fork.
+	frame.currentScope().vars["b"] = starlark.MakeInt(3)
+	assert.Equal(t, starlark.MakeInt(2), inner.vars["b"])
+	assert.Equal(t, starlark.MakeInt(3), frame.scope.vars["b"])
+	assert.Same(t, outer, frame.scope.parent)
+}
+
 func TestThread_ExecuteStatement(t *testing.T) {
 	file, err := parseAstFromString(ActionsWithMultipleBlocks)
 	require.Nil(t, err)
@@ -178,7 +234,7 @@ func TestThread_ExecuteStatement(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[0].Block"
+		jumpTo(thread, "Actions[0].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[0].Block.Stmts[0]", thread.currentPc())
@@ -194,7 +250,7 @@ func TestThread_ExecuteStatement(t *testing.T) {
 		assert.Len(t, forks, 0)
 		assert.False(t, yield)
 
-		thread.currentFrame().pc = "Actions[0].Block.Stmts[4]"
+		jumpTo(thread, "Actions[0].Block.Stmts[4]")
 		forks, yield = thread.executeStatement()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[0].Block.$", thread.currentPc())
@@ -209,7 +265,7 @@ func TestThread_ExecuteStatement(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[2].Block"
+		jumpTo(thread, "Actions[2].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[2].Block.Stmts[0]", thread.currentPc())
@@ -224,7 +280,7 @@ func TestThread_ExecuteStatement(t *testing.T) {
 		assert.Len(t, forks, 0)
 		assert.True(t, yield)
 
-		thread.currentFrame().pc = "Actions[2].Block.Stmts[4]"
+		jumpTo(thread, "Actions[2].Block.Stmts[4]")
 		forks, yield = thread.executeStatement()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[2].Block.$", thread.currentPc())
@@ -239,7 +295,7 @@ func TestThread_ExecuteStatement(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[1].Block"
+		jumpTo(thread, "Actions[1].Block")
 		oneofForks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Len(t, oneofForks, 5)
@@ -266,7 +322,7 @@ func TestThread_ExecuteStatement(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[3].Block"
+		jumpTo(thread, "Actions[3].Block")
 		parallelForks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Len(t, parallelForks, 5)
@@ -304,7 +360,7 @@ func TestThread_ExecuteStatement(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[3].Block"
+		jumpTo(thread, "Actions[3].Block")
 		parallelForks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Len(t, parallelForks, 5)
@@ -340,12 +396,12 @@ func TestThread_ExecuteEndOfBlock(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[0].Block"
+		jumpTo(thread, "Actions[0].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[0].Block.Stmts[0]", thread.currentPc())
 		assert.Len(t, forks, 0)
-		thread.currentFrame().pc = "Actions[0].Block.$"
+		jumpTo(thread, "Actions[0].Block.$")
 		yield := thread.executeEndOfBlock()
 		assert.Len(t, process.Threads, 0)
 		assert.Equal(t, thread.Stack.Len(), 0)
@@ -359,18 +415,18 @@ func TestThread_ExecuteEndOfBlock(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[0].Block"
+		jumpTo(thread, "Actions[0].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[0].Block.Stmts[0]", thread.currentPc())
 		assert.Len(t, forks, 0)
-		thread.currentFrame().pc = "Actions[0].Block.Stmts[2].Block"
+		jumpTo(thread, "Actions[0].Block.Stmts[2].Block")
 		forks = thread.executeBlock()
 		assert.Equal(t, 1, thread.Stack.Len())
 		assert.Equal(t, "Actions[0].Block.Stmts[2].Block.Stmts[0]", thread.currentPc())
 		assert.Len(t, forks, 0)
 
-		thread.currentFrame().pc = "Actions[0].Block.Stmts[2].Block.$"
+		jumpTo(thread, "Actions[0].Block.Stmts[2].Block.$")
 		yield := thread.executeEndOfBlock()
 		assert.Len(t, process.Threads, 1)
 		assert.Equal(t, 1, thread.Stack.Len())
@@ -384,18 +440,18 @@ func TestThread_ExecuteEndOfBlock(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[2].Block"
+		jumpTo(thread, "Actions[2].Block")
 		forks := thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[2].Block.Stmts[0]", thread.currentPc())
 		assert.Len(t, forks, 0)
-		thread.currentFrame().pc = "Actions[2].Block.Stmts[2].Block"
+		jumpTo(thread, "Actions[2].Block.Stmts[2].Block")
 		forks = thread.executeBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.Equal(t, "Actions[2].Block.Stmts[2].Block.Stmts[0]", thread.currentPc())
 		assert.Len(t, forks, 0)
 
-		thread.currentFrame().pc = "Actions[2].Block.Stmts[2].Block.$"
+		jumpTo(thread, "Actions[2].Block.Stmts[2].Block.$")
 		yield := thread.executeEndOfBlock()
 		assert.Equal(t, thread.Stack.Len(), 1)
 		assert.True(t, yield)
@@ -415,7 +471,7 @@ func TestThread_Execute(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[0]"
+		jumpTo(thread, "Actions[0]")
 		forks, yield := thread.Execute()
 		assert.Equal(t, thread.Stack.Len(), 0)
 		assert.Len(t, forks, 0)
@@ -433,7 +489,7 @@ func TestThread_Execute(t *testing.T) {
 		thread := process.currentThread()
 		assert.Equal(t, thread.Stack.Len(), 1)
 
-		thread.currentFrame().pc = "Actions[1]"
+		jumpTo(thread, "Actions[1]")
 		oneofForks, yield := thread.Execute()
 		assert.Equal(t, 1, thread.Stack.Len())
 		assert.Len(t, oneofForks, 5)
@@ -517,3 +573,74 @@ func TestThread_Execute(t *testing.T) {
 	})
 
 }
+
+func TestThread_ExecuteAssert(t *testing.T) {
+	file, err := parseAstFromString(`
+{
+  "states": {
+    "code": "a=0"
+  },
+  "actions": [
+    {
+      "name": "Inc",
+      "flow": "FLOW_ATOMIC",
+      "block": {
+        "flow": "FLOW_ATOMIC",
+        "stmts": [
+          {"pyStmt": {"code": "b = a + 1"}},
+          {"assertStmt": {"pyExpr": "b < 3", "messagePyExpr": "'b is ' + str(b)"}},
+          {"pyStmt": {"code": "a = b"}}
+        ]
+      }
+    }
+  ]
+}
+`)
+	require.Nil(t, err)
+	files := []*ast.File{file}
+
+	t.Run("pass", func(t *testing.T) {
+		process := NewProcess("", files, nil)
+		process.NewThread()
+		process.Heap.globals = starlark.StringDict{"a": starlark.MakeInt(1)}
+
+		thread := process.currentThread()
+		jumpTo(thread, "Actions[0]")
+		forks, yield := thread.Execute()
+		assert.Len(t, forks, 0)
+		assert.True(t, yield)
+		assert.Nil(t, process.FailedAssertion)
+		assert.Equal(t, starlark.MakeInt(2), process.Heap.globals["a"])
+	})
+	t.Run("fail", func(t *testing.T) {
+		process := NewProcess("", files, nil)
+		process.NewThread()
+		process.Heap.globals = starlark.StringDict{"a": starlark.MakeInt(2)}
+
+		thread := process.currentThread()
+		jumpTo(thread, "Actions[0]")
+		forks, yield := thread.Execute()
+		assert.Len(t, forks, 0)
+		assert.True(t, yield)
+		require.NotNil(t, process.FailedAssertion)
+		assert.Equal(t, "Assertion failed: b < 3, b is 3", process.FailedAssertion.Msg)
+		assert.Equal(t, "Actions[0].Block.Stmts[1]", thread.currentPc())
+		assert.Equal(t, starlark.MakeInt(2), process.Heap.globals["a"])
+
+		stackTrace := process.FailedAssertion.SprintStackTrace()
+		assert.Contains(t, stackTrace, "Actions[0].Block.Stmts[1]")
+		assert.Contains(t, stackTrace, `"b":3`)
+	})
+	t.Run("processor", func(t *testing.T) {
+		stateConfig := &ast.StateSpaceOptions{
+			Options: &ast.Options{MaxActions: 10, MaxConcurrentActions: 1},
+		}
+		p1, err := NewProcessor(files, stateConfig)
+		require.Nil(t, err)
+		_, failedNode, err := p1.Start()
+		require.Nil(t, err)
+		require.NotNil(t, failedNode)
+		require.NotNil(t, failedNode.Process.FailedAssertion)
+		assert.Equal(t, 3, failedNode.actionDepth)
+	})
+}
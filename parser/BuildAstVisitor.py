@@ -48,6 +48,8 @@ class BuildAstVisitor(FizzParserVisitor):
                         file.actions.append(childProto)
                 elif isinstance(childProto, ast.Function):
                     file.functions.append(childProto)
+                elif isinstance(childProto, ast.PyFunction):
+                    file.helpers.append(childProto)
                 elif isinstance(childProto, ast.Invariant):
                     file.invariants.append(childProto)
                 elif BuildAstVisitor.is_list_of_type(childProto, ast.Invariant):
@@ -313,6 +315,14 @@ class BuildAstVisitor(FizzParserVisitor):
         print("function", function)
         return function
 
+    # Visit a parse tree produced by FizzParser#class_or_func_def_stmt.
+    # Only the plain python functions are supported, as pure helpers.
+    def visitClass_or_func_def_stmt(self, ctx:FizzParser.Class_or_func_def_stmtContext):
+        if ctx.funcdef() is None:
+            raise Exception(f"Error: Line: {ctx.start.line}: classes are not supported")
+        py_str = BuildAstVisitor.transform_code(self.get_py_str(ctx))
+        return ast.PyFunction(name=ctx.funcdef().name().getText(), code=py_str)
+
     # Visit a parse tree produced by FizzParser#func_call_stmt.
     def visitFunc_call_stmt(self, ctx:FizzParser.Func_call_stmtContext):
         print("\n\nvisitFunc_call_stmt",ctx.__class__.__name__)
@@ -544,6 +554,8 @@ class BuildAstVisitor(FizzParserVisitor):
             return ast.Statement(return_stmt=childProto)
         elif isinstance(childProto, ast.CallStmt):
             return ast.Statement(call_stmt=childProto)
+        elif isinstance(childProto, ast.AssertStmt):
+            return ast.Statement(assert_stmt=childProto)
 
         elif isinstance(childProto, ast.StateVars):
             return childProto
@@ -551,6 +563,8 @@ class BuildAstVisitor(FizzParserVisitor):
             return childProto
         elif isinstance(childProto, ast.Function):
             return childProto
+        elif isinstance(childProto, ast.PyFunction):
+            return childProto
         elif isinstance(childProto, ast.Invariant):
             return childProto
         elif isinstance(childProto, ast.Statement):
@@ -824,6 +838,17 @@ class BuildAstVisitor(FizzParserVisitor):
         return return_stmt
 
 
+    # Visit a parse tree produced by FizzParser#assert_stmt.
+    def visitAssert_stmt(self, ctx:FizzParser.Assert_stmtContext):
+        assert_stmt = ast.AssertStmt()
+        tests = ctx.test()
+        assert_stmt.py_expr = self.get_py_str(tests[0])
+        if len(tests) > 1:
+            assert_stmt.message_py_expr = self.get_py_str(tests[1])
+        print("visitAssert_stmt assert_stmt", assert_stmt)
+        return assert_stmt
+
+
     # Visit a parse tree produced by FizzParser#exprlist.
     def visitExprlist(self, ctx:FizzParser.ExprlistContext):
         py_exprs = []
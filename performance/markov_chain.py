@@ -122,6 +122,23 @@ def create_transition_matrix_sparse(links, model):
     return matrix.tocsr()
 
 
+def counter_mean(counter):
+    """Returns the expected value added to the counter on each transition."""
+    distribution = counter.WhichOneof('distribution')
+    if distribution == 'uniform':
+        return (counter.uniform.min + counter.uniform.max) / 2
+    elif distribution == 'normal':
+        return counter.normal.mean
+    elif distribution == 'exponential':
+        return 1 / counter.exponential.rate
+    elif distribution == 'log_normal':
+        return np.exp(counter.log_normal.mu + counter.log_normal.sigma ** 2 / 2)
+    elif distribution == 'empirical':
+        total_weight = sum(bucket.weight for bucket in counter.empirical.buckets)
+        return sum(bucket.weight * bucket.value for bucket in counter.empirical.buckets) / total_weight
+    return counter.numeric
+
+
 def create_cost_matrices_sparse(links, model):
     if not model:
         return {}
@@ -143,7 +160,7 @@ def create_cost_matrices_sparse(links, model):
             config = model.configs[label]
             for counter in config.counters:
                 # print(counter, link.src, link.dest, config.counters[counter])
-                cost_matrices[counter][link.src,link.dest] += config.counters[counter].numeric
+                cost_matrices[counter][link.src,link.dest] += counter_mean(config.counters[counter])
 
     print('cost_matrices', cost_matrices)
     csr_matrices = {}
@@ -172,7 +189,7 @@ def create_cost_matrices(links, model):
             config = model.configs[label]
             for counter in config.counters:
                 # print(counter, link.src, link.dest, config.counters[counter])
-                cost_matrices[counter][link.src][link.dest] += config.counters[counter].numeric
+                cost_matrices[counter][link.src][link.dest] += counter_mean(config.counters[counter])
 
     print('cost_matrices', cost_matrices)
     return cost_matrices